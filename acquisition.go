@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	ErrSubscriptionUnsupported = bg.Error("DAQ can't be subscribed to")
+)
+
+// opcSubscription is a subscription to the data changes of OPC tags, which lasts until it's closed
+type opcSubscription interface {
+	Close()
+}
+
+// dataChangeFunc is called with the items of the subscribed tags which changed, by OPC tag
+type dataChangeFunc func(items map[string]opc.Item)
+
+// subscriber is a DAQ whose channels can be subscribed to, so that the DAQ pushes the channels which changed rather
+// than every channel being polled
+type subscriber interface {
+	Subscribe(rate time.Duration, changed chan<- time.Time) error
+	Unsubscribe()
+}
+
+// Compile time check to ensure DAQConnection satisfies the subscriber interface
+var _ subscriber = (*DAQConnection)(nil)
+
+// Subscribe subscribes to the data changes of the channels in an OPC group of their own, which the OPC server updates
+// once every rate and pushes the items of whenever they change. ReadItems then returns the channels which changed
+// since it was last called, and the time of the change is sent on changed, without blocking, whenever there are
+// changes to read. Stand ins for the OPC server can't push changes, so they're polled for them at the rate instead
+func (d *DAQConnection) Subscribe(rate time.Duration, changed chan<- time.Time) error {
+	onChange := func(items map[string]opc.Item) {
+		d.changesMu.Lock()
+		for tag, item := range items {
+			d.changes[tag] = item
+		}
+		d.changesMu.Unlock()
+		select {
+		case changed <- time.Now():
+		default:
+		}
+	}
+	d.changesMu.Lock()
+	d.changes = make(map[string]opc.Item)
+	d.changesMu.Unlock()
+	tags := channelTags(d.GetTagMap())
+	var sub opcSubscription
+	if d.standIn {
+		sub = pollChanges(d.Connection, tags, rate, onChange)
+	} else {
+		err := d.credentials.run(func() (err error) {
+			sub, err = newOPCSubscription(d.ServerName, d.Host, tags, rate, onChange)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: could not subscribe to the channels: %w", d.Name, err)
+		}
+	}
+	d.changesMu.Lock()
+	d.subscription = sub
+	d.changesMu.Unlock()
+	return nil
+}
+
+// Unsubscribe ends the subscription to the channels, after which they're polled again
+func (d *DAQConnection) Unsubscribe() {
+	d.changesMu.Lock()
+	sub := d.subscription
+	d.subscription = nil
+	d.changesMu.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+// isSubscribed returns true if the channels are subscribed to
+func (d *DAQConnection) isSubscribed() bool {
+	d.changesMu.Lock()
+	defer d.changesMu.Unlock()
+	return d.subscription != nil
+}
+
+// readChanges returns the readings of the channels which changed since they were last read, in tag order
+func (d *DAQConnection) readChanges() []Reading {
+	d.changesMu.Lock()
+	changes := d.changes
+	d.changes = make(map[string]opc.Item)
+	d.changesMu.Unlock()
+	tagMap := d.GetTagMap()
+	idxs := make([]int, 0, len(changes))
+	for i, tag := range tagMap {
+		if _, ok := changes[tag.tag]; ok && i != 0 {
+			idxs = append(idxs, i)
+		}
+	}
+	sort.Ints(idxs)
+	readings := make([]Reading, len(idxs))
+	for pos, i := range idxs {
+		tag := tagMap[i]
+		item := changes[tag.tag]
+		item.Value = tag.convert(item.Value)
+		readings[pos] = Reading{
+			Item:   item,
+			Name:   tag.name,
+			Type:   tag.tagType,
+			Unit:   tag.unit,
+			Kind:   tag.kind,
+			Index:  i,
+			OPCTag: tag.tag,
+			Labels: tag.labels,
+		}
+	}
+	return readings
+}
+
+// subscribe subscribes to the channels of every DAQ, which push their changes at most once every rate. If one can't
+// be subscribed to, those already subscribed are unsubscribed
+func (e *FlukeDatasource) subscribe(rate time.Duration, changed chan<- time.Time) error {
+	daqs := e.getDAQs()
+	for i, daq := range daqs {
+		s, ok := daq.(subscriber)
+		var err error
+		if !ok {
+			err = fmt.Errorf("%s: %w", daqName(daq, i), ErrSubscriptionUnsupported)
+		} else {
+			err = s.Subscribe(rate, changed)
+		}
+		if err != nil {
+			for _, subscribed := range daqs[:i] {
+				subscribed.(subscriber).Unsubscribe()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribe ends the subscriptions to the channels of every DAQ
+func (e *FlukeDatasource) unsubscribe() {
+	for _, daq := range e.getDAQs() {
+		if s, ok := daq.(subscriber); ok {
+			s.Unsubscribe()
+		}
+	}
+}
+
+// pollSubscription stands in for the subscription of a connection which can't push data changes, e.g. to a
+// simulated or replayed DAQ, by reading every tag at the update rate and passing on those whose value or OPC
+// timestamp changed since they were last read
+type pollSubscription struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// pollChanges starts polling the given tags of the connection for changes at the given rate. Like an OPC server, it
+// passes on every tag the first time they're read
+func pollChanges(conn opc.Connection, tags []string, rate time.Duration, onChange dataChangeFunc) *pollSubscription {
+	s := &pollSubscription{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(rate)
+		defer ticker.Stop()
+		last := make(map[string]opc.Item, len(tags))
+		for {
+			items := conn.Read()
+			changed := make(map[string]opc.Item)
+			for _, tag := range tags {
+				item, ok := items[tag]
+				if !ok || item.Value == nil {
+					continue
+				}
+				if prev, ok := last[tag]; ok && reflect.DeepEqual(prev.Value, item.Value) && prev.Timestamp.Equal(item.Timestamp) {
+					continue
+				}
+				last[tag] = item
+				changed[tag] = item
+			}
+			if len(changed) > 0 {
+				onChange(changed)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close stops polling for changes, waiting for a poll in progress
+func (s *pollSubscription) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
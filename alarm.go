@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+var (
+	alarmFrameType     = "application/x-fluke-alarm"
+	alarmDirectionHigh = "high"
+	alarmDirectionLow  = "low"
+	alarmDirectionRise = "rising"
+	alarmDirectionFall = "falling"
+	alarmStateActive   = "active"
+	alarmStateCleared  = "cleared"
+	alarmStateAcked    = "acknowledged"
+	alarmTypeLevel     = "level"
+	alarmTypeRate      = "rate"
+	defaultRateWindow  = 60 * time.Second
+)
+
+// Alarm is the payload of an alarm frame, sent when a channel crosses one of its limits, when the alarm is
+// acknowledged and again once it's back within its limits. The value and limit of rate alarms are in units per minute
+// and those of stale alarms in seconds
+type Alarm struct {
+	Channel   string  `json:"channel,omitempty"`
+	Rule      string  `json:"rule,omitempty"`
+	Type      string  `json:"type"`
+	Value     float64 `json:"value"`
+	Limit     float64 `json:"limit"`
+	Direction string  `json:"direction,omitempty"`
+	Fault     string  `json:"fault,omitempty"`
+	State     string  `json:"state"`
+	Timestamp int64   `json:"timestamp"`
+	Sequence  uint64  `json:"sequence"`
+	// set on the frame clearing an alarm which was acknowledged
+	Acked bool `json:"acknowledged,omitempty"`
+}
+
+// alarmLimits are the High and Low limits of a channel and its MaxRate over RateWindow, along with the deadbands
+// within which their alarms aren't cleared
+type alarmLimits struct {
+	high         *float64
+	low          *float64
+	deadband     float64
+	maxRate      *float64
+	window       time.Duration
+	rateDeadband float64
+}
+
+// alarmKey identifies the level or rate alarm of a channel
+type alarmKey struct {
+	channel   string
+	alarmType string
+}
+
+// rateSample is a value of a channel used to work out its rate of change
+type rateSample struct {
+	value float64
+	time  time.Time
+}
+
+// alarmChecker keeps track of which channels are beyond their limits over a recording
+type alarmChecker struct {
+	limits map[string]alarmLimits
+	// the direction of each active alarm
+	active  map[alarmKey]string
+	samples map[string][]rateSample
+}
+
+// newAlarmChecker returns an alarmChecker for the channels with limits, or nil if no channel has any
+func newAlarmChecker(config *cfg.Config) *alarmChecker {
+	limits := make(map[string]alarmLimits)
+	for _, daq := range config.DAQs {
+		for i, tag := range daq.FlukeTags {
+			if i == 0 || (tag.High == nil && tag.Low == nil && tag.MaxRate == nil) {
+				continue
+			}
+			window := defaultRateWindow
+			if tag.RateWindow > 0 {
+				window = time.Duration(tag.RateWindow) * time.Second
+			}
+			limits[tag.Tag] = alarmLimits{
+				high:         tag.High,
+				low:          tag.Low,
+				deadband:     tag.Deadband,
+				maxRate:      tag.MaxRate,
+				window:       window,
+				rateDeadband: tag.RateDeadband,
+			}
+		}
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	return &alarmChecker{limits: limits, active: make(map[alarmKey]string), samples: make(map[string][]rateSample)}
+}
+
+// check returns the alarms raised and cleared by a frame. Bad and non numeric values leave the state of their
+// channel unchanged
+func (a *alarmChecker) check(f *Frame, t time.Time) []Alarm {
+	if a == nil {
+		return nil
+	}
+	var alarms []Alarm
+	for _, payload := range f.Data {
+		limits, ok := a.limits[payload.Name]
+		if !ok || payload.Bad {
+			continue
+		}
+		var v float64
+		switch value := payload.Value.(type) {
+		case float64:
+			v = value
+		case int64:
+			v = float64(value)
+		default:
+			continue
+		}
+		if isBadValue(v) {
+			continue
+		}
+		alarm := Alarm{Channel: payload.Name, Value: v, Timestamp: t.UnixMilli(), Sequence: f.Sequence}
+		if limits.high != nil || limits.low != nil {
+			var direction string
+			switch {
+			case limits.high != nil && v > *limits.high:
+				direction = alarmDirectionHigh
+			case limits.low != nil && v < *limits.low:
+				direction = alarmDirectionLow
+			}
+			alarm.Type = alarmTypeLevel
+			alarms = a.transition(alarms, alarm, direction, limits, limits.deadband)
+		}
+		if limits.maxRate != nil {
+			rate, ok := a.rate(payload.Name, v, t, limits.window)
+			if !ok {
+				continue
+			}
+			var direction string
+			switch {
+			case rate > *limits.maxRate:
+				direction = alarmDirectionRise
+			case rate < -*limits.maxRate:
+				direction = alarmDirectionFall
+			}
+			alarm.Type, alarm.Value = alarmTypeRate, rate
+			alarms = a.transition(alarms, alarm, direction, limits, limits.rateDeadband)
+		}
+	}
+	return alarms
+}
+
+// transition appends the alarms for the alarm of a channel moving to the given direction, a blank direction being
+// within limits. An active alarm in the other direction is cleared first. An active alarm is only cleared once the
+// value is back within its limit by at least the deadband, so that a value hovering around the limit doesn't
+// raise and clear the alarm over and over
+func (a *alarmChecker) transition(alarms []Alarm, alarm Alarm, direction string, limits alarmLimits, deadband float64) []Alarm {
+	key := alarmKey{channel: alarm.Channel, alarmType: alarm.Type}
+	prev := a.active[key]
+	if direction == "" && prev != "" {
+		switch limit := limits.limit(prev); prev {
+		case alarmDirectionHigh, alarmDirectionRise:
+			if alarm.Value > limit-deadband {
+				return alarms
+			}
+		default:
+			if alarm.Value < limit+deadband {
+				return alarms
+			}
+		}
+	}
+	if direction == prev {
+		return alarms
+	}
+	if prev != "" {
+		alarm.Direction, alarm.State, alarm.Limit = prev, alarmStateCleared, limits.limit(prev)
+		alarms = append(alarms, alarm)
+	}
+	if direction != "" {
+		alarm.Direction, alarm.State, alarm.Limit = direction, alarmStateActive, limits.limit(direction)
+		alarms = append(alarms, alarm)
+	}
+	a.active[key] = direction
+	return alarms
+}
+
+// rate adds a value of a channel and returns its rate of change per minute since the latest value at least a window
+// old. False is returned until the channel has been read for a whole window
+func (a *alarmChecker) rate(channel string, v float64, t time.Time, window time.Duration) (float64, bool) {
+	samples := append(a.samples[channel], rateSample{value: v, time: t})
+	// only the latest of the samples older than the window is kept as the start of the window
+	start := -1
+	for i, sample := range samples {
+		if t.Sub(sample.time) < window {
+			break
+		}
+		start = i
+	}
+	if start < 0 {
+		a.samples[channel] = samples
+		return 0, false
+	}
+	samples = samples[start:]
+	a.samples[channel] = samples
+	elapsed := t.Sub(samples[0].time)
+	return (v - samples[0].value) / elapsed.Minutes(), true
+}
+
+// limit returns the limit in the given direction
+func (l alarmLimits) limit(direction string) float64 {
+	switch direction {
+	case alarmDirectionHigh:
+		return *l.high
+	case alarmDirectionLow:
+		return *l.low
+	case alarmDirectionRise:
+		return *l.maxRate
+	}
+	return -*l.maxRate
+}
+
+// alarmFrame returns the frame of an alarm
+func (e *FlukeDatasource) alarmFrame(alarm Alarm) (*proto.Frame, error) {
+	b, err := json.Marshal(&alarm)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Frame{
+		Source:    e.frameSource(),
+		Type:      alarmFrameType,
+		Timestamp: alarm.Timestamp,
+		Payload:   b,
+	}, nil
+}
+
+// logAlarm logs an alarm being raised or cleared
+func logAlarm(alarm Alarm) {
+	active := alarm.State == alarmStateActive
+	switch {
+	case alarm.Type == alarmTypeStale && active:
+		log.Printf("Alarm: %s hasn't changed for %.0fs", alarm.Channel, alarm.Value)
+		return
+	case alarm.Type == alarmTypeStale:
+		log.Printf("Alarm cleared: %s changed again after %.0fs", alarm.Channel, alarm.Value)
+		return
+	case alarm.Type == alarmTypeFault && active:
+		log.Printf("Alarm: %s is faulted: %s", alarm.Channel, alarm.Fault)
+		return
+	case alarm.Type == alarmTypeFault:
+		log.Printf("Alarm cleared: %s is no longer faulted: %s", alarm.Channel, alarm.Fault)
+		return
+	case alarm.Type == alarmTypeRule && active:
+		log.Printf("Alarm: rule %s holds", alarm.Rule)
+		return
+	case alarm.Type == alarmTypeRule:
+		log.Printf("Alarm cleared: rule %s no longer holds", alarm.Rule)
+		return
+	}
+	unit := ""
+	if alarm.Type == alarmTypeRate {
+		unit = "/min"
+	}
+	if active {
+		log.Printf("Alarm: %s is %v%s, beyond its %s limit of %v%s", alarm.Channel, alarm.Value, unit, alarm.Direction, alarm.Limit, unit)
+		return
+	}
+	log.Printf("Alarm cleared: %s is %v%s, back within its %s limit of %v%s", alarm.Channel, alarm.Value, unit, alarm.Direction, alarm.Limit, unit)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	ErrNoActiveAlarm        = bg.Error("no active alarm")
+	ErrInvalidShelveMinutes = bg.Error("alarms must be shelved for at least a minute")
+	ErrUnknownAlarmSource   = bg.Error("unknown channel or alarm rule")
+)
+
+// alarmSource returns the channel which raised an alarm, or the rule of rule alarms since they aren't raised for a
+// channel. Alarms are acknowledged and shelved by their source
+func alarmSource(alarm Alarm) string {
+	return alarm.Channel + alarm.Rule
+}
+
+// checkAlarmSources returns an error if any of the names is neither a channel nor an alarm rule
+func (e *FlukeDatasource) checkAlarmSources(names []string) error {
+	known := make(map[string]bool)
+	for _, name := range e.channelNames() {
+		known[name] = true
+	}
+	for _, rule := range e.getConfig().AlarmRules {
+		known[rule.Name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("%w %q", ErrUnknownAlarmSource, name)
+		}
+	}
+	return nil
+}
+
+// AcknowledgeAlarms acknowledges the active alarms of the given channels or alarm rules. An alarm frame is sent for
+// every alarm acknowledged, unless its alarms are shelved, and the frames clearing those alarms are marked as
+// acknowledged
+func (e *FlukeDatasource) AcknowledgeAlarms(names []string) error {
+	if err := e.checkAlarmSources(names); err != nil {
+		return err
+	}
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	for _, name := range names {
+		var found bool
+		for key := range e.alarms {
+			if key.channel == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w for %q", ErrNoActiveAlarm, name)
+		}
+	}
+	now := time.Now()
+	var acked []Alarm
+	for key, alarm := range e.alarms {
+		for _, name := range names {
+			if key.channel != name || alarm.Acked {
+				continue
+			}
+			alarm.Acked = true
+			e.alarms[key] = alarm
+			if e.isShelved(name, now) {
+				continue
+			}
+			alarm.State = alarmStateAcked
+			alarm.Timestamp = now.UnixMilli()
+			alarm.Sequence = atomic.LoadUint64(&e.sequence)
+			acked = append(acked, alarm)
+		}
+	}
+	sort.Slice(acked, func(i, j int) bool {
+		if alarmSource(acked[i]) != alarmSource(acked[j]) {
+			return alarmSource(acked[i]) < alarmSource(acked[j])
+		}
+		return acked[i].Type < acked[j].Type
+	})
+	e.acked = append(e.acked, acked...)
+	// let the recording goroutine send the frames of the acknowledged alarms
+	select {
+	case e.ackChan <- struct{}{}:
+	default:
+	}
+	log.Printf("Acknowledged alarms of %s", strings.Join(names, ", "))
+	return nil
+}
+
+// takeAcked returns the acknowledged alarms whose frames haven't been sent yet
+func (e *FlukeDatasource) takeAcked() []Alarm {
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	acked := e.acked
+	e.acked = nil
+	return acked
+}
+
+// ShelveAlarms stops the alarms of the given channels or alarm rules from being sent for the given number of
+// minutes, e.g. during a known transient. Shelved alarms are still logged and tracked, so an alarm still active once
+// it's unshelved is only sent again if it's cleared and raised again
+func (e *FlukeDatasource) ShelveAlarms(names []string, minutes int64) error {
+	if minutes < 1 {
+		return ErrInvalidShelveMinutes
+	}
+	if err := e.checkAlarmSources(names); err != nil {
+		return err
+	}
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	if e.shelved == nil {
+		e.shelved = make(map[string]time.Time)
+	}
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	for _, name := range names {
+		e.shelved[name] = until
+	}
+	log.Printf("Shelved alarms of %s for %d minutes", strings.Join(names, ", "), minutes)
+	return nil
+}
+
+// UnshelveAlarms sends the alarms of previously shelved channels or alarm rules again
+func (e *FlukeDatasource) UnshelveAlarms(names []string) error {
+	if err := e.checkAlarmSources(names); err != nil {
+		return err
+	}
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	for _, name := range names {
+		delete(e.shelved, name)
+	}
+	log.Printf("Unshelved alarms of %s", strings.Join(names, ", "))
+	return nil
+}
+
+// isShelved returns true if the alarms of a channel or alarm rule are shelved, forgetting the shelf once it has
+// expired. The caller must hold alarmMu
+func (e *FlukeDatasource) isShelved(source string, now time.Time) bool {
+	until, ok := e.shelved[source]
+	if ok && !now.Before(until) {
+		delete(e.shelved, source)
+		return false
+	}
+	return ok
+}
+
+// trackAlarm keeps track of an alarm being raised or cleared, marking a cleared alarm as acknowledged if it was.
+// False is returned if the alarms of its channel or rule are shelved
+func (e *FlukeDatasource) trackAlarm(alarm Alarm) (Alarm, bool) {
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	if e.alarms == nil {
+		e.alarms = make(map[alarmKey]Alarm)
+	}
+	source := alarmSource(alarm)
+	key := alarmKey{channel: source, alarmType: alarm.Type}
+	if alarm.State == alarmStateActive {
+		e.alarms[key] = alarm
+	} else {
+		alarm.Acked = e.alarms[key].Acked
+		delete(e.alarms, key)
+	}
+	return alarm, !e.isShelved(source, time.Now())
+}
+
+// resetAlarms forgets the alarms of the previous recording. Shelves are kept until they expire
+func (e *FlukeDatasource) resetAlarms() {
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	e.alarms = nil
+	e.acked = nil
+}
+
+// shelvedChannels returns the names of the channels and alarm rules whose alarms are shelved, in order
+func (e *FlukeDatasource) shelvedChannels() []string {
+	e.alarmMu.Lock()
+	defer e.alarmMu.Unlock()
+	names := make([]string, 0, len(e.shelved))
+	for name, until := range e.shelved {
+		if time.Now().Before(until) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	alarmTypeRule = "rule"
+)
+
+// ruleChecker evaluates the alarm rules against the latest good value of every channel, so that channels which
+// aren't read on every scan are still taken into account
+type ruleChecker struct {
+	rules  []cfg.AlarmRule
+	values map[string]float64
+	active map[string]bool
+}
+
+// newRuleChecker returns a ruleChecker for the configured rules, or nil if there are none
+func newRuleChecker(config *cfg.Config) *ruleChecker {
+	if len(config.AlarmRules) == 0 {
+		return nil
+	}
+	return &ruleChecker{rules: config.AlarmRules, values: make(map[string]float64), active: make(map[string]bool)}
+}
+
+// check updates the channels with a frame and returns the rule alarms raised and cleared
+func (r *ruleChecker) check(f *Frame, t time.Time) []Alarm {
+	if r == nil {
+		return nil
+	}
+	for _, payload := range f.Data {
+		if payload.Bad {
+			continue
+		}
+		switch v := payload.Value.(type) {
+		case float64:
+			r.values[payload.Name] = v
+		case int64:
+			r.values[payload.Name] = float64(v)
+		}
+	}
+	var alarms []Alarm
+	for _, rule := range r.rules {
+		holds := r.holds(&rule.When)
+		if holds == r.active[rule.Name] {
+			continue
+		}
+		r.active[rule.Name] = holds
+		alarm := Alarm{Rule: rule.Name, Type: alarmTypeRule, State: alarmStateCleared, Timestamp: t.UnixMilli(), Sequence: f.Sequence}
+		if holds {
+			alarm.State = alarmStateActive
+		}
+		alarms = append(alarms, alarm)
+	}
+	return alarms
+}
+
+// holds returns true if a condition holds for the latest values. Channels without a value don't meet comparisons
+func (r *ruleChecker) holds(c *cfg.AlarmCondition) bool {
+	switch {
+	case len(c.All) > 0:
+		for i := range c.All {
+			if !r.holds(&c.All[i]) {
+				return false
+			}
+		}
+		return true
+	case len(c.Any) > 0:
+		for i := range c.Any {
+			if r.holds(&c.Any[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	if !cfg.IsTagPattern(c.Channel) {
+		v, ok := r.values[c.Channel]
+		return ok && meets(c, v)
+	}
+	matched := false
+	for channel, v := range r.values {
+		if ok, _ := path.Match(c.Channel, channel); !ok {
+			continue
+		}
+		matched = true
+		if meets(c, v) != c.Every {
+			return !c.Every
+		}
+	}
+	return matched && c.Every
+}
+
+// meets returns true if a value is above or below the limit of a comparison
+func meets(c *cfg.AlarmCondition, v float64) bool {
+	if c.Above != nil {
+		return v > *c.Above
+	}
+	return c.Below != nil && v < *c.Below
+}
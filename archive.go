@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	archivePurgeInterval time.Duration = 1 * time.Hour
+	archiveSchema                      = `
+CREATE TABLE IF NOT EXISTS readings (
+	timestamp INTEGER NOT NULL,
+	channel   TEXT NOT NULL,
+	value     REAL,
+	text      TEXT,
+	unit      TEXT,
+	quality   INTEGER NOT NULL,
+	sequence  INTEGER NOT NULL,
+	bad       INTEGER NOT NULL,
+	PRIMARY KEY (timestamp, channel)
+)`
+)
+
+// archive keeps every reading in a local SQLite database so that a rig has its own queryable history regardless of
+// upstream outages. Numeric and bool values are stored in the value column and strings in the text column.
+// Readings older than the retention are purged periodically
+type archive struct {
+	db        *sql.DB
+	retention time.Duration
+	lastPurge time.Time
+}
+
+func init() {
+	RegisterSink("archive", func(e *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.ArchiveFile == "" {
+			return nil, nil
+		}
+		return openArchive(e.configRelativePath(config.ArchiveFile), time.Duration(config.ArchiveDays)*24*time.Hour)
+	})
+}
+
+// openArchive opens the archive at the given path, creating it if it doesn't exist
+func openArchive(path string, retention time.Duration) (*archive, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// WAL lets the archive be queried while recording
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", archiveSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &archive{db: db, retention: retention}, nil
+}
+
+// Write stores the readings of a frame in a single transaction, purging old readings first if it's time to
+func (a *archive) Write(frame *SinkFrame) error {
+	f, t := frame.Frame, frame.Time
+	if a.retention > 0 && time.Since(a.lastPurge) > archivePurgeInterval {
+		if _, err := a.db.Exec("DELETE FROM readings WHERE timestamp < ?", time.Now().Add(-a.retention).UnixMilli()); err != nil {
+			return err
+		}
+		a.lastPurge = time.Now()
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO readings (timestamp, channel, value, text, unit, quality, sequence, bad) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, payload := range f.Data {
+		var (
+			value interface{}
+			text  interface{}
+		)
+		switch v := payload.Value.(type) {
+		case float64, int64:
+			value = v
+		case bool:
+			value = 0
+			if v {
+				value = 1
+			}
+		case string:
+			text = v
+		}
+		if _, err := stmt.Exec(t.UnixMilli(), payload.Name, value, text, payload.Unit, payload.Quality, f.Sequence, payload.Bad); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Flush does nothing as every frame is committed as it's written
+func (a *archive) Flush() error {
+	return nil
+}
+
+// Close closes the database
+func (a *archive) Close() error {
+	return a.db.Close()
+}
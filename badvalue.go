@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	// the Fluke DAQ software reports overloaded channels (+OVER/-OVER) with this magnitude
+	overRangeValue = 9.9e37
+)
+
+// isBadValue returns true if a payload value is NaN, infinite or an overload
+func isBadValue(value interface{}) bool {
+	v, ok := value.(float64)
+	if !ok {
+		return false
+	}
+	return math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) >= overRangeValue
+}
+
+// badValueFilter applies the bad value policy to readings which have bad OPC quality or a bad value. It keeps the
+// last good value of every channel for the last-good policy
+type badValueFilter struct {
+	policy   string
+	lastGood map[string]interface{}
+}
+
+// newBadValueFilter returns a badValueFilter for the given policy
+func newBadValueFilter(policy string) *badValueFilter {
+	return &badValueFilter{policy: policy, lastGood: make(map[string]interface{})}
+}
+
+// apply returns the value to send for a reading and whether it is bad. False is returned if the reading is dropped.
+// Bad values which can't be encoded, like NaN, are always replaced with nil
+func (f *badValueFilter) apply(reading Reading, value interface{}) (interface{}, bool, bool) {
+	if reading.Item.Good() && !isBadValue(value) {
+		f.lastGood[reading.Name] = value
+		return value, false, true
+	}
+	switch f.policy {
+	case cfg.BadValuePolicyDrop:
+		return nil, true, false
+	case cfg.BadValuePolicyNull:
+		return nil, true, true
+	case cfg.BadValuePolicyLastGood:
+		last, ok := f.lastGood[reading.Name]
+		return last, true, ok
+	}
+	if isBadValue(value) {
+		return nil, true, true
+	}
+	return value, true, true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultBurstInterval time.Duration = 500 * time.Millisecond
+	defaultBurstDuration time.Duration = 60 * time.Second
+	minBurstInterval     time.Duration = 100 * time.Millisecond
+)
+
+// burst is a period of faster polling during a transient event, like venting
+type burst struct {
+	interval time.Duration
+	until    time.Time
+}
+
+// burstSettings returns the configured interval and duration of bursts
+func (e *FlukeDatasource) burstSettings() (time.Duration, time.Duration) {
+	config := e.getConfig()
+	interval, duration := defaultBurstInterval, defaultBurstDuration
+	if config.BurstInterval != 0 {
+		interval = time.Duration(config.BurstInterval) * time.Millisecond
+	}
+	if config.BurstDuration != 0 {
+		duration = time.Duration(config.BurstDuration) * time.Second
+	}
+	return interval, duration
+}
+
+// StartBurst polls at the given interval for the given duration, after which the normal polling interval is
+// restored. Zero values use the configured burst interval and duration. Starting a burst during another replaces it
+func (e *FlukeDatasource) StartBurst(interval, duration time.Duration) error {
+	if atomic.LoadInt32(&e.recording) == 0 {
+		return ErrNotRecording
+	}
+	defaultInterval, defaultDuration := e.burstSettings()
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if duration == 0 {
+		duration = defaultDuration
+	}
+	if interval < minBurstInterval {
+		return fmt.Errorf("burst interval cannot be shorter than %v", minBurstInterval)
+	}
+	if duration < 0 {
+		return fmt.Errorf("burst duration cannot be negative")
+	}
+	e.burstMu.Lock()
+	e.burst = &burst{interval: interval, until: time.Now().Add(duration)}
+	e.burstMu.Unlock()
+	// let the recording goroutine pick up the burst interval
+	select {
+	case e.reloadChan <- struct{}{}:
+	default:
+	}
+	log.Printf("Polling every %v for %v", interval, duration)
+	return nil
+}
+
+// currentPollingInterval returns the polling interval in effect and whether it's that of a burst
+func (e *FlukeDatasource) currentPollingInterval() (time.Duration, bool) {
+	if e.intervalOverride != 0 {
+		return e.intervalOverride, false
+	}
+	e.burstMu.Lock()
+	defer e.burstMu.Unlock()
+	if e.burst != nil {
+		if time.Now().Before(e.burst.until) {
+			return e.burst.interval, true
+		}
+		e.burst = nil
+		log.Println("Burst finished, returning to the normal polling interval")
+	}
+	return pollingInterval(e.getConfig()), false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// calibrate applies the calibration of the tag to a value in engineering units, once linearized for channels with a
+// sensor, e.g. to the temperature of an RTD rather than its resistance. Bad values, like overloads, and non numeric
+// values are returned unchanged
+func (t Tag) calibrate(value interface{}) interface{} {
+	if t.calib == nil {
+		return value
+	}
+	var v float64
+	switch value := value.(type) {
+	case float64:
+		v = value
+	case int64:
+		v = float64(value)
+	default:
+		return value
+	}
+	if isBadValue(v) {
+		return value
+	}
+	switch {
+	case len(t.calib.Polynomial) > 0:
+		return polynomial(t.calib.Polynomial, v)
+	case len(t.calib.Table) > 0:
+		return interpolate(t.calib.Table, t.calib.Interpolation == cfg.InterpolationLog, v)
+	}
+	gain := t.calib.Gain
+	if gain == 0 {
+		gain = 1
+	}
+	return v*gain + t.calib.Offset
+}
+
+// linearizeValue applies the linearization of the sensor of the tag to a value in engineering units. Bad values,
+// like overloads, and non numeric values are returned unchanged
+func (t Tag) linearizeValue(value interface{}) interface{} {
+	if t.linearize == nil {
+		return value
+	}
+	var v float64
+	switch value := value.(type) {
+	case float64:
+		v = value
+	case int64:
+		v = float64(value)
+	default:
+		return value
+	}
+	if isBadValue(v) {
+		return value
+	}
+	return t.linearize(v)
+}
+
+// convertUnit converts a value in engineering units from the unit the channel is measured in to the one it's sent
+// in. Bad values, like overloads, and non numeric values are returned unchanged
+func (t Tag) convertUnit(value interface{}) interface{} {
+	if t.toUnit == nil {
+		return value
+	}
+	var v float64
+	switch value := value.(type) {
+	case float64:
+		v = value
+	case int64:
+		v = float64(value)
+	default:
+		return value
+	}
+	if isBadValue(v) {
+		return value
+	}
+	return t.toUnit(v)
+}
+
+// polynomial evaluates the polynomial with the given coefficients, in increasing order of degree, at v
+func polynomial(coefficients []float64, v float64) float64 {
+	var result float64
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = result*v + coefficients[i]
+	}
+	return result
+}
+
+// interpolate returns the calibrated value of v from a table of [value, calibrated value] points, clamping v to the
+// ends of the table. With logarithmic interpolation, the logarithm of the calibrated values is interpolated linearly
+func interpolate(table [][]float64, logarithmic bool, v float64) float64 {
+	last := len(table) - 1
+	if v <= table[0][0] {
+		return table[0][1]
+	}
+	if v >= table[last][0] {
+		return table[last][1]
+	}
+	i := sort.Search(len(table), func(i int) bool { return table[i][0] >= v })
+	x0, y0, x1, y1 := table[i-1][0], table[i-1][1], table[i][0], table[i][1]
+	frac := (v - x0) / (x1 - x0)
+	if logarithmic {
+		return math.Pow(10, math.Log10(y0)+frac*(math.Log10(y1)-math.Log10(y0)))
+	}
+	return y0 + frac*(y1-y0)
+}
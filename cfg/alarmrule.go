@@ -0,0 +1,98 @@
+package cfg
+
+import (
+	"fmt"
+	"path"
+)
+
+// AlarmCondition is either a comparison of channels with Above or Below, or a combination of conditions which holds
+// if All or Any of them hold. Channel can be a wildcard pattern like "TC_*", in which case the comparison holds if
+// any of the matching channels meets it, or every one of them with Every
+type AlarmCondition struct {
+	Channel string           `yaml:"Channel,omitempty" json:"Channel"`
+	Above   *float64         `yaml:"Above,omitempty" json:"Above"`
+	Below   *float64         `yaml:"Below,omitempty" json:"Below"`
+	Every   bool             `yaml:"Every" json:"Every"`
+	All     []AlarmCondition `yaml:"All,omitempty" json:"All"`
+	Any     []AlarmCondition `yaml:"Any,omitempty" json:"Any"`
+}
+
+// AlarmRule raises a single named alarm while its condition holds, e.g. as test abort criteria
+type AlarmRule struct {
+	Name string         `yaml:"Name" json:"Name"`
+	When AlarmCondition `yaml:"When" json:"When"`
+}
+
+// validateAlarmRules returns the problems with the alarm rules. Rules need unique names and their channels have to
+// be channels of one of the DAQs or virtual channels
+func validateAlarmRules(rules []AlarmRule, daqs []DAQConfig, virtuals []VirtualChannel) []string {
+	var problems []string
+	channels := make(map[string]bool)
+	for _, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 {
+				channels[tag.Tag] = true
+			}
+		}
+	}
+	for _, virtual := range virtuals {
+		channels[virtual.Name] = true
+	}
+	names := make(map[string]bool)
+	for r, rule := range rules {
+		switch {
+		case rule.Name == "":
+			problems = append(problems, fmt.Sprintf("AlarmRule %d has a blank Name", r))
+		case names[rule.Name]:
+			problems = append(problems, fmt.Sprintf("AlarmRule %d has duplicate Name %q", r, rule.Name))
+		}
+		names[rule.Name] = true
+		for _, problem := range rule.When.validate(channels) {
+			problems = append(problems, fmt.Sprintf("AlarmRule %q: %s", rule.Name, problem))
+		}
+	}
+	return problems
+}
+
+// validate returns the problems with the condition and those it combines
+func (c *AlarmCondition) validate(channels map[string]bool) []string {
+	var problems []string
+	kinds := 0
+	for _, set := range []bool{c.Channel != "", len(c.All) > 0, len(c.Any) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return append(problems, "a condition must have exactly one of Channel, All and Any")
+	}
+	for _, conditions := range [][]AlarmCondition{c.All, c.Any} {
+		for i := range conditions {
+			problems = append(problems, conditions[i].validate(channels)...)
+		}
+	}
+	if c.Channel == "" {
+		return problems
+	}
+	if (c.Below == nil) == (c.Above == nil) {
+		problems = append(problems, fmt.Sprintf("the condition on %q must have exactly one of Below and Above", c.Channel))
+	}
+	if !IsTagPattern(c.Channel) {
+		if !channels[c.Channel] {
+			problems = append(problems, fmt.Sprintf("Channel %q is not a channel in FlukeTags", c.Channel))
+		}
+		return problems
+	}
+	if _, err := path.Match(c.Channel, ""); err != nil {
+		return append(problems, fmt.Sprintf("Channel %q is not a valid pattern", c.Channel))
+	}
+	matched := false
+	for channel := range channels {
+		ok, _ := path.Match(c.Channel, channel)
+		matched = matched || ok
+	}
+	if !matched {
+		problems = append(problems, fmt.Sprintf("Channel pattern %q doesn't match any channel in FlukeTags", c.Channel))
+	}
+	return problems
+}
@@ -0,0 +1,57 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	InterpolationLinear = "linear"
+	InterpolationLog    = "log"
+)
+
+// Calibration corrects the readings of a channel once converted to engineering units, e.g. with the coefficients
+// from its calibration certificate. By default the calibrated value is value * Gain + Offset, a Gain of 0 being
+// treated as 1. Alternatively, it's given by the Polynomial with the coefficients in increasing order of degree, or
+// interpolated from the Table of [value, calibrated value] points in increasing order of value. Values outside the
+// table are clamped to its ends. With log Interpolation, calibrated values are interpolated logarithmically, e.g.
+// for a vacuum gauge whose output voltage is logarithmic in pressure
+type Calibration struct {
+	Gain          float64     `yaml:"Gain" json:"Gain"`
+	Offset        float64     `yaml:"Offset" json:"Offset"`
+	Polynomial    []float64   `yaml:"Polynomial,omitempty" json:"Polynomial"`
+	Table         [][]float64 `yaml:"Table,omitempty" json:"Table"`
+	Interpolation string      `yaml:"Interpolation,omitempty" json:"Interpolation"`
+}
+
+// validate returns the problems with the calibration
+func (c *Calibration) validate() []string {
+	var problems []string
+	kinds := 0
+	for _, set := range []bool{c.Gain != 0 || c.Offset != 0, len(c.Polynomial) > 0, len(c.Table) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		problems = append(problems, "can only have one of Gain and Offset, Polynomial and Table")
+	}
+	if len(c.Table) == 1 {
+		problems = append(problems, "Table must have at least two points")
+	}
+	for i, point := range c.Table {
+		switch {
+		case len(point) != 2:
+			problems = append(problems, fmt.Sprintf("Table point %d must be a [value, calibrated value] pair", i))
+		case i > 0 && len(c.Table[i-1]) == 2 && point[0] <= c.Table[i-1][0]:
+			problems = append(problems, fmt.Sprintf("Table point %d must have a higher value than the point before it", i))
+		case c.Interpolation == InterpolationLog && point[1] <= 0:
+			problems = append(problems, fmt.Sprintf("Table point %d must have a positive calibrated value for log Interpolation", i))
+		}
+	}
+	switch c.Interpolation {
+	case "", InterpolationLinear, InterpolationLog:
+	default:
+		problems = append(problems, fmt.Sprintf("Interpolation must be %q or %q", InterpolationLinear, InterpolationLog))
+	}
+	return problems
+}
@@ -1,45 +1,350 @@
 package cfg
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
+	"github.com/BurntSushi/toml"
+	bg "github.com/SSSOCPaulCote/blunderguard"
 	"github.com/btcsuite/btcd/btcutil"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type CfgTag struct {
-	Tag  string `yaml:"Tag"`
-	Type string `yaml:"Type"`
+	Tag          string            `yaml:"Tag" json:"Tag"`
+	Type         string            `yaml:"Type" json:"Type"`
+	OPCTag       string            `yaml:"OPCTag" json:"OPCTag"`
+	PollEvery    int64             `yaml:"PollEvery" json:"PollEvery"`
+	Unit         string            `yaml:"Unit" json:"Unit"`
+	Scale        float64           `yaml:"Scale" json:"Scale"`
+	Offset       float64           `yaml:"Offset" json:"Offset"`
+	Kind         string            `yaml:"Kind,omitempty" json:"Kind"`
+	Labels       map[string]string `yaml:"Labels,omitempty" json:"Labels"`
+	High         *float64          `yaml:"High,omitempty" json:"High"`
+	Low          *float64          `yaml:"Low,omitempty" json:"Low"`
+	Deadband     float64           `yaml:"Deadband" json:"Deadband"`
+	MaxRate      *float64          `yaml:"MaxRate,omitempty" json:"MaxRate"`
+	RateWindow   int64             `yaml:"RateWindow" json:"RateWindow"`
+	RateDeadband float64           `yaml:"RateDeadband" json:"RateDeadband"`
+	Calibration  *Calibration      `yaml:"Calibration,omitempty" json:"Calibration"`
+	ConvertTo    string            `yaml:"ConvertTo,omitempty" json:"ConvertTo"`
+	Smoothing    *Smoothing        `yaml:"Smoothing,omitempty" json:"Smoothing"`
+	Spikes       *SpikeFilter      `yaml:"Spikes,omitempty" json:"Spikes"`
+	Thermocouple *Thermocouple     `yaml:"Thermocouple,omitempty" json:"Thermocouple"`
+	Sensor       string            `yaml:"Sensor,omitempty" json:"Sensor"`
+	Waveform     *Waveform         `yaml:"Waveform,omitempty" json:"Waveform"`
+}
+
+// PressureConfig marks the channels at the given indices as pressure readings. Its Unit, Scale and Offset apply to
+// those channels which don't set their own
+type PressureConfig struct {
+	Channels []int   `yaml:"Channels" json:"Channels"`
+	Unit     string  `yaml:"Unit" json:"Unit"`
+	Scale    float64 `yaml:"Scale" json:"Scale"`
+	Offset   float64 `yaml:"Offset" json:"Offset"`
+}
+
+type DAQConfig struct {
+	Name         string         `yaml:"Name" json:"Name"`
+	ServerName   string         `yaml:"ServerName" json:"ServerName"`
+	ServerNames  []string       `yaml:"ServerNames" json:"ServerNames"`
+	Host         string         `yaml:"Host" json:"Host"`
+	Username     string         `yaml:"Username" json:"Username"`
+	Password     string         `yaml:"Password" json:"Password"`
+	PasswordFile string         `yaml:"PasswordFile,omitempty" json:"PasswordFile"`
+	Domain       string         `yaml:"Domain" json:"Domain"`
+	FlukeTags    TagMap         `yaml:"FlukeTags" json:"FlukeTags"`
+	Pressure     PressureConfig `yaml:"Pressure,omitempty" json:"Pressure"`
 }
 
 type Config struct {
-	Influx           bool           `yaml:"Influx"`
-	InfluxURL        string         `yaml:"InfluxURL"`
-	InfuxAPIToken    string         `yaml:"InfluxAPIToken"`
-	InfluxOrgName    string         `yaml:"InfluxOrgName"`
-	InfluxBucketName string         `yaml:"InfluxBucketName"`
-	InfluxSkipTLS    bool           `yaml:"InfluxSkipTLS"`
-	PollingInterval  int64          `yaml:"PollingInterval"`
-	FlukeTags        map[int]CfgTag `yaml:"FlukeTags"`
+	Version            int64              `yaml:"Version" json:"Version"`
+	Influx             bool               `yaml:"Influx" json:"Influx"`
+	InfluxURL          string             `yaml:"InfluxURL" json:"InfluxURL"`
+	InfluxAPIToken     string             `yaml:"InfluxAPIToken" json:"InfluxAPIToken"`
+	InfluxAPITokenFile string             `yaml:"InfluxAPITokenFile,omitempty" json:"InfluxAPITokenFile"`
+	InfluxVersion      int64              `yaml:"InfluxVersion" json:"InfluxVersion"`
+	InfluxUsername     string             `yaml:"InfluxUsername,omitempty" json:"InfluxUsername"`
+	InfluxPassword     string             `yaml:"InfluxPassword,omitempty" json:"InfluxPassword"`
+	InfluxDatabase     string             `yaml:"InfluxDatabase,omitempty" json:"InfluxDatabase"`
+	InfluxRetention    string             `yaml:"InfluxRetention,omitempty" json:"InfluxRetention"`
+	InfluxOrgName      string             `yaml:"InfluxOrgName" json:"InfluxOrgName"`
+	InfluxBucketName   string             `yaml:"InfluxBucketName" json:"InfluxBucketName"`
+	InfluxTags         map[string]string  `yaml:"InfluxTags,omitempty" json:"InfluxTags"`
+	InfluxInterval     int64              `yaml:"InfluxInterval" json:"InfluxInterval"`
+	InfluxBatchSize    int64              `yaml:"InfluxBatchSize" json:"InfluxBatchSize"`
+	InfluxFlushPeriod  int64              `yaml:"InfluxFlushPeriod" json:"InfluxFlushPeriod"`
+	InfluxExportDir    string             `yaml:"InfluxExportDir,omitempty" json:"InfluxExportDir"`
+	InfluxExportMaxMB  int64              `yaml:"InfluxExportMaxMB" json:"InfluxExportMaxMB"`
+	InfluxExportEvery  int64              `yaml:"InfluxExportEvery" json:"InfluxExportEvery"`
+	InfluxAggBucket    string             `yaml:"InfluxAggBucket,omitempty" json:"InfluxAggBucket"`
+	InfluxAggInterval  int64              `yaml:"InfluxAggInterval" json:"InfluxAggInterval"`
+	InfluxQueueDir     string             `yaml:"InfluxQueueDir,omitempty" json:"InfluxQueueDir"`
+	InfluxQueueMaxMB   int64              `yaml:"InfluxQueueMaxMB" json:"InfluxQueueMaxMB"`
+	InfluxQueueMaxAge  int64              `yaml:"InfluxQueueMaxAge" json:"InfluxQueueMaxAge"`
+	InfluxCAFile       string             `yaml:"InfluxCAFile,omitempty" json:"InfluxCAFile"`
+	InfluxCertFile     string             `yaml:"InfluxCertFile,omitempty" json:"InfluxCertFile"`
+	InfluxKeyFile      string             `yaml:"InfluxKeyFile,omitempty" json:"InfluxKeyFile"`
+	InfluxSkipTLS      bool               `yaml:"InfluxSkipTLS" json:"InfluxSkipTLS"`
+	PollingInterval    int64              `yaml:"PollingInterval" json:"PollingInterval"`
+	HeartbeatInterval  int64              `yaml:"HeartbeatInterval" json:"HeartbeatInterval"`
+	ReadTimeout        int64              `yaml:"ReadTimeout" json:"ReadTimeout"`
+	ReadWorkers        int64              `yaml:"ReadWorkers" json:"ReadWorkers"`
+	ConnectAttempts    int64              `yaml:"ConnectAttempts" json:"ConnectAttempts"`
+	ConnectRetryDelay  int64              `yaml:"ConnectRetryDelay" json:"ConnectRetryDelay"`
+	TagCacheTTL        int64              `yaml:"TagCacheTTL" json:"TagCacheTTL"`
+	GroupRead          bool               `yaml:"GroupRead" json:"GroupRead"`
+	AcquisitionMode    string             `yaml:"AcquisitionMode" json:"AcquisitionMode"`
+	PayloadEncoding    string             `yaml:"PayloadEncoding" json:"PayloadEncoding"`
+	PayloadOPCTags     bool               `yaml:"PayloadOPCTags" json:"PayloadOPCTags"`
+	GroupByKind        bool               `yaml:"GroupByKind" json:"GroupByKind"`
+	BadValuePolicy     string             `yaml:"BadValuePolicy" json:"BadValuePolicy"`
+	SampleInterval     int64              `yaml:"SampleInterval" json:"SampleInterval"`
+	StaleAfter         int64              `yaml:"StaleAfter" json:"StaleAfter"`
+	AlarmRules         []AlarmRule        `yaml:"AlarmRules,omitempty" json:"AlarmRules"`
+	VirtualChannels    []VirtualChannel   `yaml:"VirtualChannels,omitempty" json:"VirtualChannels"`
+	Precision          map[string]int64   `yaml:"Precision,omitempty" json:"Precision"`
+	CompressPayload    bool               `yaml:"CompressPayload" json:"CompressPayload"`
+	FrameSource        string             `yaml:"FrameSource" json:"FrameSource"`
+	FrameType          string             `yaml:"FrameType" json:"FrameType"`
+	WarmupDelay        int64              `yaml:"WarmupDelay" json:"WarmupDelay"`
+	WaitForGoodRead    bool               `yaml:"WaitForGoodRead" json:"WaitForGoodRead"`
+	BurstInterval      int64              `yaml:"BurstInterval" json:"BurstInterval"`
+	BurstDuration      int64              `yaml:"BurstDuration" json:"BurstDuration"`
+	MaxDuration        int64              `yaml:"MaxDuration" json:"MaxDuration"`
+	MaxFrames          int64              `yaml:"MaxFrames" json:"MaxFrames"`
+	Trigger            *Trigger           `yaml:"Trigger,omitempty" json:"Trigger"`
+	Decimation         *Decimation        `yaml:"Decimation,omitempty" json:"Decimation"`
+	Schedule           Schedule           `yaml:"Schedule,omitempty" json:"Schedule"`
+	MQTT               *MQTT              `yaml:"MQTT,omitempty" json:"MQTT"`
+	Kafka              *Kafka             `yaml:"Kafka,omitempty" json:"Kafka"`
+	Modbus             *Modbus            `yaml:"Modbus,omitempty" json:"Modbus"`
+	Webhook            *Webhook           `yaml:"Webhook,omitempty" json:"Webhook"`
+	CSVLogDir          string             `yaml:"CSVLogDir,omitempty" json:"CSVLogDir"`
+	CSVLogMaxMB        int64              `yaml:"CSVLogMaxMB" json:"CSVLogMaxMB"`
+	ArchiveFile        string             `yaml:"ArchiveFile,omitempty" json:"ArchiveFile"`
+	ArchiveDays        int64              `yaml:"ArchiveDays" json:"ArchiveDays"`
+	ParquetDir         string             `yaml:"ParquetDir,omitempty" json:"ParquetDir"`
+	ParquetPeriod      string             `yaml:"ParquetPeriod" json:"ParquetPeriod"`
+	PostgresDSN        string             `yaml:"PostgresDSN,omitempty" json:"PostgresDSN"`
+	PostgresTable      string             `yaml:"PostgresTable,omitempty" json:"PostgresTable"`
+	SpillFile          string             `yaml:"SpillFile,omitempty" json:"SpillFile"`
+	SpillTimeout       int64              `yaml:"SpillTimeout" json:"SpillTimeout"`
+	PauseFile          string             `yaml:"PauseFile,omitempty" json:"PauseFile"`
+	WatchConfig        bool               `yaml:"WatchConfig" json:"WatchConfig"`
+	Simulate           bool               `yaml:"Simulate" json:"Simulate"`
+	Replay             *Replay            `yaml:"Replay,omitempty" json:"Replay"`
+	FaultInjection     *FaultInjection    `yaml:"FaultInjection,omitempty" json:"FaultInjection"`
+	GoldenDir          string             `yaml:"GoldenDir,omitempty" json:"GoldenDir"`
+	UDPAddress         string             `yaml:"UDPAddress,omitempty" json:"UDPAddress"`
+	HTTPAddress        string             `yaml:"HTTPAddress,omitempty" json:"HTTPAddress"`
+	Profile            string             `yaml:"Profile,omitempty" json:"Profile"`
+	Profiles           map[string]Profile `yaml:"Profiles,omitempty" json:"Profiles"`
+	FlukeTags          TagMap             `yaml:"FlukeTags,omitempty" json:"FlukeTags"`
+	DAQs               []DAQConfig        `yaml:"DAQs,omitempty" json:"DAQs"`
+	migratedFrom       int64
 }
 
 var (
-	configFileName = "fluke.yaml"
+	configFileNames   = []string{"fluke.yaml", "fluke.toml", "fluke.json"}
+	configEnvVar      = "FLUKE_PLUGIN_CONFIG"
+	programDataDir    = "fluke-laniakea-plugin"
+	DefaultServerName = "Fluke.DAQ.OPC"
+	DefaultServerHost = "localhost"
+	ErrConfigNotFound = bg.Error("config file not found")
 )
 
-// InitConfig initializes the config from the config YAML file
-func InitConfig() (*Config, error) {
-	// Use lani appdata dir for Fluke plugin config
-	cfgBytes, err := ioutil.ReadFile(filepath.Join(btcutil.AppDataDir("fmtd", false), configFileName))
+// AppDataDir returns the lani appdata dir where the Fluke plugin config and other plugin files are kept
+func AppDataDir() string {
+	return btcutil.AppDataDir("fmtd", false)
+}
+
+// configSearchPaths returns the locations searched for the config file, in order, when no path is given
+func configSearchPaths() []string {
+	// lani appdata dir is searched first since it's where the Fluke plugin config has always been kept
+	dirs := []string{AppDataDir()}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	if programData := os.Getenv("ProgramData"); programData != "" {
+		dirs = append(dirs, filepath.Join(programData, programDataDir))
+	}
+	var paths []string
+	for _, dir := range dirs {
+		for _, name := range configFileNames {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
+
+// FindConfigFile returns the path of the config file. The given path takes precedence, followed by the
+// FLUKE_PLUGIN_CONFIG environment variable and finally the first file found in the default search paths
+func FindConfigFile(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if path = os.Getenv(configEnvVar); path != "" {
+		return path, nil
+	}
+	paths := configSearchPaths()
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%w: searched %s", ErrConfigNotFound, strings.Join(paths, ", "))
+}
+
+// InitConfig initializes the config from the config YAML file found at the given path or in the default locations
+func InitConfig(path string) (*Config, error) {
+	path, err := FindConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfgBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	// marshall into YAML
 	var cfg Config
-	err = yaml.Unmarshal(cfgBytes, &cfg)
+	err = unmarshalConfig(path, cfgBytes, &cfg)
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.migrate(); err != nil {
+		return nil, err
+	}
+	// the profile is applied before the environment overrides so that they take precedence over it, which means the
+	// profile itself has to be taken from the environment first
+	if profile, ok := os.LookupEnv(envPrefix + envName("Profile")); ok {
+		cfg.Profile = profile
+	}
+	if err := cfg.applyProfile(); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(reflect.ValueOf(&cfg).Elem(), envPrefix); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveSecrets(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	for i := range cfg.DAQs {
+		cfg.DAQs[i].applyPressure()
+		cfg.DAQs[i].applyKinds()
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// unmarshalConfig decodes the config file according to its extension. Files without a .toml or .json extension are
+// decoded as YAML
+func unmarshalConfig(path string, b []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return jsonErrorPosition(b, decodeJSONStrict(b, cfg))
+	case ".toml":
+		// the TOML decoder can't decode into maps with integer keys like FlukeTags so it goes through JSON instead
+		var m map[string]interface{}
+		if err := toml.Unmarshal(b, &m); err != nil {
+			var perr toml.ParseError
+			if errors.As(err, &perr) {
+				line, col := lineColumn(b, perr.Position.Start)
+				return fmt.Errorf("line %d, column %d: %w", line, col, err)
+			}
+			return err
+		}
+		jsonBytes, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return decodeJSONStrict(jsonBytes, cfg)
+	default:
+		return yaml.UnmarshalStrict(b, cfg)
+	}
+}
+
+// decodeJSONStrict decodes JSON into v, rejecting fields which v doesn't have so that typos aren't silently ignored
+func decodeJSONStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// jsonErrorPosition adds the line and column a JSON syntax or type error occurred at to the error, which otherwise
+// only gives the byte offset into the file
+func jsonErrorPosition(b []byte, err error) error {
+	var offset int64
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		offset = err.Offset
+	case *json.UnmarshalTypeError:
+		offset = err.Offset
+	default:
+		return err
+	}
+	// the offset is past the byte the error occurred at
+	if offset > 0 {
+		offset--
+	}
+	line, col := lineColumn(b, int(offset))
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// lineColumn returns the line and column, both starting at 1, of the byte at the given offset
+func lineColumn(b []byte, offset int) (int, int) {
+	if offset > len(b) {
+		offset = len(b)
+	}
+	before := b[:offset]
+	return bytes.Count(before, []byte("\n")) + 1, offset - bytes.LastIndexByte(before, '\n')
+}
+
+// Server returns the candidate OPC server names of the DAQ in the order they should be tried and its host,
+// substituting the defaults for blank values
+func (d DAQConfig) Server() ([]string, string) {
+	serverNames := d.ServerNames
+	if len(serverNames) == 0 && d.ServerName != "" {
+		serverNames = []string{d.ServerName}
+	} else if len(serverNames) == 0 {
+		serverNames = []string{DefaultServerName}
+	}
+	host := d.Host
+	if host == "" {
+		host = DefaultServerHost
+	}
+	return serverNames, host
+}
+
+// DefaultName returns the name a DAQ connected to its OPC server goes by when it has no Name, made of its first
+// server name and its host
+func (d DAQConfig) DefaultName() string {
+	serverNames, host := d.Server()
+	return fmt.Sprintf("%s@%s", serverNames[0], host)
+}
+
+// applyPressure marks the pressure channels of the DAQ and gives them the pressure unit and transform
+func (d *DAQConfig) applyPressure() {
+	for _, i := range d.Pressure.Channels {
+		tag, ok := d.FlukeTags[i]
+		if !ok {
+			continue
+		}
+		tag.Kind = KindPressure
+		if tag.Unit == "" {
+			tag.Unit = d.Pressure.Unit
+		}
+		if tag.Scale == 0 && tag.Offset == 0 {
+			tag.Scale, tag.Offset = d.Pressure.Scale, d.Pressure.Offset
+		}
+		d.FlukeTags[i] = tag
+	}
+}
@@ -0,0 +1,72 @@
+package cfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalConfigErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		config   string
+		position string
+	}{
+		{
+			name:     "JSON syntax",
+			path:     "fluke.json",
+			config:   "{\n  \"PollingInterval\": 5,\n  \"Influx\": tru\n}",
+			position: "line 3, column 16: ",
+		},
+		{
+			name:     "JSON type",
+			path:     "fluke.json",
+			config:   "{\n  \"PollingInterval\": \"5\"\n}",
+			position: "line 2, column 24: ",
+		},
+		{
+			name:     "JSON type in a DAQ",
+			path:     "fluke.json",
+			config:   "{\n  \"DAQs\": [\n    {\"Host\": 5}\n  ]\n}",
+			position: "line 3, column 14: ",
+		},
+		{
+			name:     "TOML syntax",
+			path:     "fluke.toml",
+			config:   "PollingInterval = 5\nInflux = tru\n",
+			position: "line 2, column 10: ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c Config
+			err := unmarshalConfig(test.path, []byte(test.config), &c)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), test.position) {
+				t.Fatalf("error %q doesn't start with %q", err, test.position)
+			}
+		})
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	b := []byte("ab\ncd\n\nef")
+	tests := []struct {
+		offset    int
+		line, col int
+	}{
+		{offset: 0, line: 1, col: 1},
+		{offset: 1, line: 1, col: 2},
+		{offset: 3, line: 2, col: 1},
+		{offset: 6, line: 3, col: 1},
+		{offset: 8, line: 4, col: 2},
+		{offset: 100, line: 4, col: 3},
+	}
+	for _, test := range tests {
+		if line, col := lineColumn(b, test.offset); line != test.line || col != test.col {
+			t.Errorf("lineColumn(%d) = %d, %d, expected %d, %d", test.offset, line, col, test.line, test.col)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	AggregateMean = "mean"
+	AggregateMin  = "min"
+	AggregateMax  = "max"
+)
+
+// Decimation forwards only every Nth scan to Laniakea while every scan is still written to the local sinks. With
+// Aggregate, the numeric channels of the forwarded frame carry the mean, min or max of the scans since the previous
+// one rather than those of the last scan
+type Decimation struct {
+	Every     int64  `yaml:"Every" json:"Every"`
+	Aggregate string `yaml:"Aggregate,omitempty" json:"Aggregate"`
+}
+
+// validate returns the problems with the decimation
+func (d *Decimation) validate() []string {
+	var problems []string
+	if d.Every < 1 {
+		problems = append(problems, "Decimation Every must be at least 1")
+	}
+	switch d.Aggregate {
+	case "", AggregateMean, AggregateMin, AggregateMax:
+	default:
+		problems = append(problems, fmt.Sprintf("Decimation has unknown Aggregate %q", d.Aggregate))
+	}
+	return problems
+}
@@ -0,0 +1,139 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	envPrefix = "FLUKE_"
+)
+
+// envName converts a YAML key like InfluxAPIToken into the environment variable form INFLUX_API_TOKEN
+func envName(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// envSet returns true if any environment variable starts with the given prefix
+func envSet(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvOverrides overrides the fields of the given struct with the values of their FLUKE_* environment variables.
+// Lists of structs are overridden element by element (e.g. FLUKE_DAQ_0_HOST) and lists of strings are comma separated.
+// Optional sections like Kafka are overridden like nested structs (e.g. FLUKE_KAFKA_TOPIC) and are added to the config
+// if it doesn't have them, as are optional values like FLUKE_TRIGGER_ABOVE. Maps, like the tag maps, and lists of
+// anything but structs and strings aren't supported
+func applyEnvOverrides(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + envName(key)
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := applyEnvOverrides(field, name+"_"); err != nil {
+				return err
+			}
+			continue
+		case reflect.Ptr:
+			if field.Type().Elem().Kind() != reflect.Struct {
+				break
+			}
+			if field.IsNil() {
+				if !envSet(name + "_") {
+					continue
+				}
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			if err := applyEnvOverrides(field.Elem(), name+"_"); err != nil {
+				return err
+			}
+			continue
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.Struct {
+				name = prefix + envName(strings.TrimSuffix(key, "s"))
+				for j := 0; j < field.Len(); j++ {
+					if err := applyEnvOverrides(field.Index(j), fmt.Sprintf("%s_%d_", name, j)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses the given string into the field according to its kind
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var values []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cfg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "Influx", want: "INFLUX"},
+		{key: "InfluxURL", want: "INFLUX_URL"},
+		{key: "InfluxAPIToken", want: "INFLUX_API_TOKEN"},
+		{key: "PollingInterval", want: "POLLING_INTERVAL"},
+		{key: "DAQ", want: "DAQ"},
+		{key: "SASLMechanism", want: "SASL_MECHANISM"},
+	}
+	for _, test := range tests {
+		if got := envName(test.key); got != test.want {
+			t.Errorf("envName(%q) = %q, expected %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		config  Config
+		check   func(c *Config) bool
+		wantErr bool
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"FLUKE_INFLUX_URL": "https://influx:8086"},
+			check: func(c *Config) bool { return c.InfluxURL == "https://influx:8086" },
+		},
+		{
+			name:  "bool and int",
+			env:   map[string]string{"FLUKE_INFLUX": "true", "FLUKE_POLLING_INTERVAL": "10"},
+			check: func(c *Config) bool { return c.Influx && c.PollingInterval == 10 },
+		},
+		{
+			name:    "invalid int",
+			env:     map[string]string{"FLUKE_POLLING_INTERVAL": "ten"},
+			wantErr: true,
+		},
+		{
+			name:   "field of a DAQ",
+			env:    map[string]string{"FLUKE_DAQ_1_HOST": "rig2"},
+			config: Config{DAQs: []DAQConfig{{Host: "rig1"}, {Host: "rig1"}}},
+			check:  func(c *Config) bool { return c.DAQs[0].Host == "rig1" && c.DAQs[1].Host == "rig2" },
+		},
+		{
+			name:   "list of strings",
+			env:    map[string]string{"FLUKE_DAQ_0_SERVER_NAMES": "Fluke.DAQ.OPC, Fluke.DAQ.OPC.2,"},
+			config: Config{DAQs: []DAQConfig{{}}},
+			check: func(c *Config) bool {
+				return reflect.DeepEqual(c.DAQs[0].ServerNames, []string{"Fluke.DAQ.OPC", "Fluke.DAQ.OPC.2"})
+			},
+		},
+		{
+			name:  "optional section added",
+			env:   map[string]string{"FLUKE_KAFKA_TOPIC": "fluke"},
+			check: func(c *Config) bool { return c.Kafka != nil && c.Kafka.Topic == "fluke" },
+		},
+		{
+			name:  "optional section left out",
+			check: func(c *Config) bool { return c.Kafka == nil },
+		},
+		{
+			name:   "optional value",
+			env:    map[string]string{"FLUKE_TRIGGER_ABOVE": "-40.5"},
+			config: Config{Trigger: &Trigger{Channel: "TC_1"}},
+			check: func(c *Config) bool {
+				return c.Trigger.Channel == "TC_1" && c.Trigger.Above != nil && *c.Trigger.Above == -40.5
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			c := test.config
+			err := applyEnvOverrides(reflect.ValueOf(&c).Elem(), envPrefix)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnvOverrides: %v", err)
+			}
+			if !test.check(&c) {
+				t.Fatalf("overrides %v not applied as expected: %+v", test.env, c)
+			}
+		})
+	}
+}
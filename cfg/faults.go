@@ -0,0 +1,40 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// FaultInjection injects faults into the OPC reads of every DAQ to check that consumers and alarms cope with
+// degraded data. The rates are the probability of each fault on any single read: DropRate fails the read,
+// NaNRate reads NaN, BadQualityRate reads with bad quality and DisconnectRate loses the connection for
+// DisconnectSeconds (default 10), failing every read and write in the meantime
+type FaultInjection struct {
+	DropRate          float64 `yaml:"DropRate" json:"DropRate"`
+	NaNRate           float64 `yaml:"NaNRate" json:"NaNRate"`
+	BadQualityRate    float64 `yaml:"BadQualityRate" json:"BadQualityRate"`
+	DisconnectRate    float64 `yaml:"DisconnectRate" json:"DisconnectRate"`
+	DisconnectSeconds int64   `yaml:"DisconnectSeconds" json:"DisconnectSeconds"`
+}
+
+// validate returns the problems with the fault injection
+func (f *FaultInjection) validate() []string {
+	var problems []string
+	rates := []struct {
+		name string
+		rate float64
+	}{
+		{"DropRate", f.DropRate},
+		{"NaNRate", f.NaNRate},
+		{"BadQualityRate", f.BadQualityRate},
+		{"DisconnectRate", f.DisconnectRate},
+	}
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			problems = append(problems, fmt.Sprintf("FaultInjection %s %v must be between 0 and 1", r.name, r.rate))
+		}
+	}
+	if f.DisconnectSeconds < 0 {
+		problems = append(problems, "FaultInjection DisconnectSeconds cannot be negative")
+	}
+	return problems
+}
@@ -0,0 +1,28 @@
+package cfg
+
+// Golden returns a copy of the config to keep in a golden bundle. Only what shapes the frames is kept: secrets,
+// outputs other than the frames, the schedule and time limits are removed, as are the simulation, replay and fault
+// injection modes since the recorded reads take the place of the DAQs
+func (c *Config) Golden() *Config {
+	golden := *c
+	golden.Influx = false
+	golden.InfluxAPIToken, golden.InfluxAPITokenFile = "", ""
+	golden.InfluxUsername, golden.InfluxPassword = "", ""
+	golden.InfluxInterval = 0
+	golden.InfluxExportDir, golden.InfluxQueueDir = "", ""
+	golden.MQTT, golden.Kafka, golden.Modbus, golden.Webhook = nil, nil, nil, nil
+	golden.CSVLogDir, golden.ArchiveFile, golden.ParquetDir, golden.PostgresDSN = "", "", "", ""
+	golden.SpillFile, golden.PauseFile, golden.UDPAddress, golden.HTTPAddress = "", "", "", ""
+	golden.WatchConfig = false
+	golden.Schedule = nil
+	golden.MaxDuration = 0
+	golden.Simulate, golden.Replay, golden.FaultInjection = false, nil, nil
+	golden.Profile, golden.Profiles = "", nil
+	golden.GoldenDir = ""
+	golden.DAQs = make([]DAQConfig, len(c.DAQs))
+	for i, daq := range c.DAQs {
+		daq.Username, daq.Password, daq.PasswordFile, daq.Domain = "", "", "", ""
+		golden.DAQs[i] = daq
+	}
+	return &golden
+}
@@ -0,0 +1,35 @@
+package cfg
+
+// InfluxV1 returns true if points are written to an InfluxDB 1.x server
+func (c *Config) InfluxV1() bool {
+	return c.InfluxVersion == InfluxVersion1
+}
+
+// InfluxToken returns the token the Influx client authenticates with. InfluxDB 1.x takes the username and password
+// in its place
+func (c *Config) InfluxToken() string {
+	if c.InfluxV1() {
+		return c.InfluxUsername + ":" + c.InfluxPassword
+	}
+	return c.InfluxAPIToken
+}
+
+// InfluxOrg returns the organization points are written to, which is blank for InfluxDB 1.x
+func (c *Config) InfluxOrg() string {
+	if c.InfluxV1() {
+		return ""
+	}
+	return c.InfluxOrgName
+}
+
+// InfluxBucket returns the bucket points are written to. For InfluxDB 1.x, it's the database and retention policy
+// in the form database/retention-policy
+func (c *Config) InfluxBucket() string {
+	if !c.InfluxV1() {
+		return c.InfluxBucketName
+	}
+	if c.InfluxRetention == "" {
+		return c.InfluxDatabase
+	}
+	return c.InfluxDatabase + "/" + c.InfluxRetention
+}
@@ -0,0 +1,38 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// Kafka publishes every frame sent to Laniakea as a message to a Kafka topic. SASL authentication is used if
+// SASLMechanism is set
+type Kafka struct {
+	Brokers       []string `yaml:"Brokers" json:"Brokers"`
+	Topic         string   `yaml:"Topic" json:"Topic"`
+	TLS           bool     `yaml:"TLS" json:"TLS"`
+	SASLMechanism string   `yaml:"SASLMechanism,omitempty" json:"SASLMechanism"`
+	Username      string   `yaml:"Username,omitempty" json:"Username"`
+	Password      string   `yaml:"Password,omitempty" json:"Password"`
+	PasswordFile  string   `yaml:"PasswordFile,omitempty" json:"PasswordFile"`
+}
+
+// validate returns the problems with the Kafka settings
+func (k *Kafka) validate() []string {
+	var problems []string
+	if len(k.Brokers) == 0 {
+		problems = append(problems, "Kafka must have at least one broker")
+	}
+	if k.Topic == "" {
+		problems = append(problems, "Kafka Topic cannot be blank")
+	}
+	switch k.SASLMechanism {
+	case "":
+	case KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512:
+		if k.Username == "" {
+			problems = append(problems, "Kafka Username cannot be blank when SASLMechanism is set")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("Kafka SASLMechanism must be %q, %q or %q", KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512))
+	}
+	return problems
+}
@@ -0,0 +1,56 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	KindTemperature = "temperature"
+	KindPressure    = "pressure"
+	KindVoltage     = "voltage"
+	KindDigital     = "digital"
+	// Kinds are the kinds of channels in the order they're grouped in the payload
+	Kinds = []string{KindTemperature, KindPressure, KindVoltage, KindDigital}
+	// KindUnits are the units given to channels of each kind which don't set their own
+	KindUnits = map[string]string{
+		KindTemperature: "degC",
+		KindPressure:    "mbar",
+		KindVoltage:     "V",
+	}
+	// sensorKinds are the kinds of the channels of each sensor type
+	sensorKinds = map[string]string{
+		SensorThermocoupleK: KindTemperature,
+		SensorThermocoupleJ: KindTemperature,
+		SensorThermocoupleT: KindTemperature,
+		SensorPT100:         KindTemperature,
+		SensorPT1000:        KindTemperature,
+		SensorPKR251:        KindPressure,
+	}
+)
+
+// applyKinds gives the channels of the DAQ without a Kind the kind of their sensor, and those without a Unit the
+// default unit of their kind
+func (d *DAQConfig) applyKinds() {
+	for i, tag := range d.FlukeTags {
+		if tag.Kind == "" {
+			tag.Kind = sensorKinds[tag.Sensor]
+			if tag.Thermocouple != nil {
+				tag.Kind = KindTemperature
+			}
+		}
+		if tag.Unit == "" {
+			tag.Unit = KindUnits[tag.Kind]
+		}
+		d.FlukeTags[i] = tag
+	}
+}
+
+// validateKind returns the problems with the kind of a channel
+func (t CfgTag) validateKind() []string {
+	for _, kind := range Kinds {
+		if t.Kind == kind {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("has unknown Kind %q", t.Kind)}
+}
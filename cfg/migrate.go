@@ -0,0 +1,39 @@
+package cfg
+
+import (
+	"fmt"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	CurrentConfigVersion        int64 = 2
+	ErrUnsupportedConfigVersion       = bg.Error("unsupported config version")
+)
+
+// migrations upgrade a config from the version they are keyed by to the next version
+var migrations = map[int64]func(c *Config){
+	// version 2 defines every DAQ under DAQs rather than a single DAQ with the top level FlukeTags
+	1: func(c *Config) {
+		if len(c.DAQs) == 0 {
+			c.DAQs = []DAQConfig{{FlukeTags: c.FlukeTags}}
+		}
+		c.FlukeTags = nil
+	},
+}
+
+// migrate upgrades a config written for an older version of the plugin to the current version. Configs without a
+// Version are the original layout, version 1
+func (c *Config) migrate() error {
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	if c.Version < 1 || c.Version > CurrentConfigVersion {
+		return fmt.Errorf("%w: %d, this version of the plugin supports up to version %d", ErrUnsupportedConfigVersion, c.Version, CurrentConfigVersion)
+	}
+	c.migratedFrom = c.Version
+	for ; c.Version < CurrentConfigVersion; c.Version++ {
+		migrations[c.Version](c)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cfg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tags := TagMap{0: {Tag: "Scan"}, 1: {Tag: "TC_1"}}
+	tests := []struct {
+		name         string
+		config       Config
+		want         Config
+		migratedFrom int64
+		wantErr      error
+	}{
+		{
+			name:         "unversioned",
+			config:       Config{FlukeTags: tags},
+			want:         Config{Version: 2, DAQs: []DAQConfig{{FlukeTags: tags}}},
+			migratedFrom: 1,
+		},
+		{
+			name:         "version 1",
+			config:       Config{Version: 1, FlukeTags: tags},
+			want:         Config{Version: 2, DAQs: []DAQConfig{{FlukeTags: tags}}},
+			migratedFrom: 1,
+		},
+		{
+			name:         "version 1 with DAQs",
+			config:       Config{Version: 1, FlukeTags: tags, DAQs: []DAQConfig{{Host: "rig1", FlukeTags: tags}}},
+			want:         Config{Version: 2, DAQs: []DAQConfig{{Host: "rig1", FlukeTags: tags}}},
+			migratedFrom: 1,
+		},
+		{
+			name:         "current version",
+			config:       Config{Version: 2, DAQs: []DAQConfig{{FlukeTags: tags}}},
+			want:         Config{Version: 2, DAQs: []DAQConfig{{FlukeTags: tags}}},
+			migratedFrom: 2,
+		},
+		{
+			name:    "newer version",
+			config:  Config{Version: CurrentConfigVersion + 1},
+			wantErr: ErrUnsupportedConfigVersion,
+		},
+		{
+			name:    "negative version",
+			config:  Config{Version: -1},
+			wantErr: ErrUnsupportedConfigVersion,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := test.config
+			err := c.migrate()
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("migrate returned %v, expected %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if c.migratedFrom != test.migratedFrom {
+				t.Fatalf("migrated from version %d, expected %d", c.migratedFrom, test.migratedFrom)
+			}
+			test.want.migratedFrom = test.migratedFrom
+			if !reflect.DeepEqual(c, test.want) {
+				t.Fatalf("migrated config %+v, expected %+v", c, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+)
+
+// ModbusRegister maps a channel to holding registers starting at Address. A float32 takes two registers, high word
+// first, and an int16 takes one, holding the value multiplied by Scale
+type ModbusRegister struct {
+	Channel string  `yaml:"Channel" json:"Channel"`
+	Address int64   `yaml:"Address" json:"Address"`
+	Type    string  `yaml:"Type" json:"Type"`
+	Scale   float64 `yaml:"Scale" json:"Scale"`
+}
+
+// Modbus serves the latest values of the mapped channels as Modbus TCP holding registers, e.g. for PLC interlocks.
+// The server is read only
+type Modbus struct {
+	Address   string           `yaml:"Address" json:"Address"`
+	UnitID    int64            `yaml:"UnitID" json:"UnitID"`
+	Registers []ModbusRegister `yaml:"Registers" json:"Registers"`
+}
+
+// Size returns the number of registers taken by a register of the given type
+func (r ModbusRegister) Size() int64 {
+	if r.Type == ModbusTypeInt16 {
+		return 1
+	}
+	return 2
+}
+
+// validate returns the problems with the Modbus settings. Channels have to be channels of one of the DAQs and
+// registers can't overlap
+func (m *Modbus) validate(daqs []DAQConfig) []string {
+	var problems []string
+	if _, _, err := net.SplitHostPort(m.Address); err != nil {
+		problems = append(problems, fmt.Sprintf("Modbus Address %q is not a valid host:port address", m.Address))
+	}
+	if m.UnitID < 0 || m.UnitID > 255 {
+		problems = append(problems, "Modbus UnitID must be between 0 and 255")
+	}
+	channels := make(map[string]bool)
+	for _, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 {
+				channels[tag.Tag] = true
+			}
+		}
+	}
+	used := make(map[int64]string)
+	for _, r := range m.Registers {
+		if !channels[r.Channel] {
+			problems = append(problems, fmt.Sprintf("Modbus channel %q is not a channel in FlukeTags", r.Channel))
+		}
+		switch r.Type {
+		case "", ModbusTypeFloat32, ModbusTypeInt16:
+		default:
+			problems = append(problems, fmt.Sprintf("Modbus register type of %q must be %q or %q", r.Channel, ModbusTypeFloat32, ModbusTypeInt16))
+		}
+		if r.Address < 0 || r.Address+r.Size() > 65536 {
+			problems = append(problems, fmt.Sprintf("Modbus register address of %q must be between 0 and 65535", r.Channel))
+			continue
+		}
+		for a := r.Address; a < r.Address+r.Size(); a++ {
+			if other, ok := used[a]; ok {
+				problems = append(problems, fmt.Sprintf("Modbus registers of %q and %q overlap at %d", other, r.Channel, a))
+				break
+			}
+			used[a] = r.Channel
+		}
+	}
+	return problems
+}
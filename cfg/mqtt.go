@@ -0,0 +1,34 @@
+package cfg
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// MQTT publishes every reading to an MQTT broker, e.g. for the facility SCADA. Topic is a template in which
+// {channel} and {type} are replaced with the name and type of the channel
+type MQTT struct {
+	Broker       string `yaml:"Broker" json:"Broker"`
+	ClientID     string `yaml:"ClientID,omitempty" json:"ClientID"`
+	Username     string `yaml:"Username,omitempty" json:"Username"`
+	Password     string `yaml:"Password,omitempty" json:"Password"`
+	PasswordFile string `yaml:"PasswordFile,omitempty" json:"PasswordFile"`
+	Topic        string `yaml:"Topic" json:"Topic"`
+	QoS          int64  `yaml:"QoS" json:"QoS"`
+	Retain       bool   `yaml:"Retain" json:"Retain"`
+}
+
+// validate returns the problems with the MQTT settings
+func (m *MQTT) validate() []string {
+	var problems []string
+	if u, err := url.Parse(m.Broker); err != nil || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("MQTT Broker %q is not a valid URL like tcp://broker:1883", m.Broker))
+	}
+	if m.Topic == "" {
+		problems = append(problems, "MQTT Topic cannot be blank")
+	}
+	if m.QoS < 0 || m.QoS > 2 {
+		problems = append(problems, "MQTT QoS must be 0, 1 or 2")
+	}
+	return problems
+}
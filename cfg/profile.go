@@ -0,0 +1,52 @@
+package cfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	ErrUnknownProfile = bg.Error("unknown profile")
+)
+
+type ProfileDAQ struct {
+	FlukeTags TagMap `yaml:"FlukeTags" json:"FlukeTags"`
+}
+
+// Profile is a named test setup which overrides the polling interval and the tag maps of the DAQs, given in the
+// same order as DAQs. Blank fields keep the values of the rest of the config
+type Profile struct {
+	PollingInterval int64        `yaml:"PollingInterval" json:"PollingInterval"`
+	DAQs            []ProfileDAQ `yaml:"DAQs" json:"DAQs"`
+}
+
+// applyProfile overrides the config with the selected profile, if any
+func (c *Config) applyProfile() error {
+	if c.Profile == "" {
+		return nil
+	}
+	profile, ok := c.Profiles[c.Profile]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%w %q, the config defines: %s", ErrUnknownProfile, c.Profile, strings.Join(names, ", "))
+	}
+	if len(profile.DAQs) > len(c.DAQs) {
+		return fmt.Errorf("profile %q defines tags for %d DAQs but only %d are configured", c.Profile, len(profile.DAQs), len(c.DAQs))
+	}
+	if profile.PollingInterval != 0 {
+		c.PollingInterval = profile.PollingInterval
+	}
+	for i, daq := range profile.DAQs {
+		if len(daq.FlukeTags) > 0 {
+			c.DAQs[i].FlukeTags = daq.FlukeTags
+		}
+	}
+	return nil
+}
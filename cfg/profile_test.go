@@ -0,0 +1,60 @@
+package cfg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	base := TagMap{0: {Tag: "Scan"}, 1: {Tag: "TC_1"}}
+	soak := TagMap{0: {Tag: "Scan"}, 1: {Tag: "TC_soak"}}
+	profiles := map[string]Profile{
+		"fast":  {PollingInterval: 1},
+		"soak":  {PollingInterval: 60, DAQs: []ProfileDAQ{{}, {FlukeTags: soak}}},
+		"three": {DAQs: []ProfileDAQ{{}, {}, {FlukeTags: soak}}},
+	}
+	daqs := func() []DAQConfig {
+		return []DAQConfig{{Name: "a", FlukeTags: base}, {Name: "b", FlukeTags: base}}
+	}
+	tests := []struct {
+		name     string
+		profile  string
+		interval int64
+		tags     []TagMap
+		wantErr  bool
+		errIs    error
+	}{
+		{name: "no profile", interval: 5, tags: []TagMap{base, base}},
+		{name: "polling interval only", profile: "fast", interval: 1, tags: []TagMap{base, base}},
+		{name: "tags of one DAQ", profile: "soak", interval: 60, tags: []TagMap{base, soak}},
+		{name: "more DAQs than configured", profile: "three", wantErr: true},
+		{name: "unknown profile", profile: "slow", wantErr: true, errIs: ErrUnknownProfile},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Config{PollingInterval: 5, Profile: test.profile, Profiles: profiles, DAQs: daqs()}
+			err := c.applyProfile()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if test.errIs != nil && !errors.Is(err, test.errIs) {
+					t.Fatalf("applyProfile returned %v, expected %v", err, test.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyProfile: %v", err)
+			}
+			if c.PollingInterval != test.interval {
+				t.Fatalf("polling interval %d, expected %d", c.PollingInterval, test.interval)
+			}
+			for i, daq := range c.DAQs {
+				if !reflect.DeepEqual(daq.FlukeTags, test.tags[i]) {
+					t.Fatalf("DAQ %d tags %v, expected %v", i, daq.FlukeTags, test.tags[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// Replay replays a recording in place of the OPC servers. File is a CSV log written with CSVLogDir or a JSON file of
+// data frames, one after the other, as sent with the json PayloadEncoding. Speed is the rate the recording is played
+// back at, e.g. 10 for ten times real-time (default 1). With Loop the recording starts over once it ends, otherwise
+// the recording is stopped
+type Replay struct {
+	File  string  `yaml:"File" json:"File"`
+	Speed float64 `yaml:"Speed" json:"Speed"`
+	Loop  bool    `yaml:"Loop" json:"Loop"`
+}
+
+// validate returns the problems with the replay
+func (r *Replay) validate(simulate bool) []string {
+	var problems []string
+	if r.File == "" {
+		problems = append(problems, "Replay File is required")
+	}
+	if r.Speed < 0 {
+		problems = append(problems, fmt.Sprintf("Replay Speed %v cannot be negative", r.Speed))
+	}
+	if simulate {
+		problems = append(problems, "Replay and Simulate cannot both be set")
+	}
+	return problems
+}
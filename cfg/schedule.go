@@ -0,0 +1,76 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	dailyTimeLayout = "15:04"
+)
+
+// ScheduleWindow is a period during which the plugin records. Start and Stop are either RFC 3339 timestamps for a
+// one off window, like "2022-10-01T18:00:00-04:00", or times of day like "18:00" for a window repeated every day.
+// A daily window whose Stop is before its Start runs overnight
+type ScheduleWindow struct {
+	Start string `yaml:"Start" json:"Start"`
+	Stop  string `yaml:"Stop" json:"Stop"`
+}
+
+// Schedule is a list of recording windows. An empty schedule records all the time
+type Schedule []ScheduleWindow
+
+// parse returns the bounds of the window, and whether it repeats daily, in which case only the time of day of
+// the bounds is meaningful
+func (w ScheduleWindow) parse() (time.Time, time.Time, bool, error) {
+	if start, err := time.Parse(dailyTimeLayout, w.Start); err == nil {
+		stop, err := time.Parse(dailyTimeLayout, w.Stop)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, fmt.Errorf("Stop %q must be a time of day like Start", w.Stop)
+		}
+		return start, stop, true, nil
+	}
+	start, err := time.Parse(time.RFC3339, w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("Start %q is neither a time of day nor an RFC 3339 timestamp", w.Start)
+	}
+	stop, err := time.Parse(time.RFC3339, w.Stop)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("Stop %q must be an RFC 3339 timestamp like Start", w.Stop)
+	}
+	if !stop.After(start) {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("Stop %q must be after Start %q", w.Stop, w.Start)
+	}
+	return start, stop, false, nil
+}
+
+// Active returns true if the given time falls within the window. Daily windows use the local time of day
+func (w ScheduleWindow) Active(t time.Time) bool {
+	start, stop, daily, err := w.parse()
+	if err != nil {
+		return false
+	}
+	if !daily {
+		return !t.Before(start) && t.Before(stop)
+	}
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := stop.Hour()*60 + stop.Minute()
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+// Active returns true if the given time falls within any window of the schedule, or if the schedule is empty
+func (s Schedule) Active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package cfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveSecret returns the contents of the given file if one is set, relative paths being relative to dir.
+// Otherwise a value of the form ${NAME} is replaced with the NAME environment variable and any other value is
+// returned as is
+func resolveSecret(value, file, dir string) (string, error) {
+	if file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+		name := value[2 : len(value)-1]
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces the secret fields of the config with the values they refer to. Secret files are found
+// relative to the directory of the config file
+func (c *Config) resolveSecrets(dir string) error {
+	token, err := resolveSecret(c.InfluxAPIToken, c.InfluxAPITokenFile, dir)
+	if err != nil {
+		return fmt.Errorf("could not read InfluxAPIToken: %w", err)
+	}
+	c.InfluxAPIToken = token
+	if c.InfluxPassword, err = resolveSecret(c.InfluxPassword, "", dir); err != nil {
+		return fmt.Errorf("could not read InfluxPassword: %w", err)
+	}
+	if c.PostgresDSN, err = resolveSecret(c.PostgresDSN, "", dir); err != nil {
+		return fmt.Errorf("could not read PostgresDSN: %w", err)
+	}
+	if c.MQTT != nil {
+		if c.MQTT.Password, err = resolveSecret(c.MQTT.Password, c.MQTT.PasswordFile, dir); err != nil {
+			return fmt.Errorf("could not read the MQTT Password: %w", err)
+		}
+	}
+	if c.Kafka != nil {
+		if c.Kafka.Password, err = resolveSecret(c.Kafka.Password, c.Kafka.PasswordFile, dir); err != nil {
+			return fmt.Errorf("could not read the Kafka Password: %w", err)
+		}
+	}
+	if c.Webhook != nil {
+		if c.Webhook.URL, err = resolveSecret(c.Webhook.URL, "", dir); err != nil {
+			return fmt.Errorf("could not read the Webhook URL: %w", err)
+		}
+	}
+	for i := range c.DAQs {
+		password, err := resolveSecret(c.DAQs[i].Password, c.DAQs[i].PasswordFile, dir)
+		if err != nil {
+			return fmt.Errorf("could not read the password of DAQ %d: %w", i, err)
+		}
+		c.DAQs[i].Password = password
+	}
+	return nil
+}
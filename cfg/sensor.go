@@ -0,0 +1,52 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	SensorThermocoupleK = "TC-K"
+	SensorThermocoupleJ = "TC-J"
+	SensorThermocoupleT = "TC-T"
+	SensorPT100         = "PT100"
+	SensorPT1000        = "PT1000"
+	SensorPKR251        = "PKR251"
+	// SensorUnits are the units the readings of each sensor type are in once linearized. Thermocouples are read in
+	// mV, RTDs in ohms and gauges in V
+	SensorUnits = map[string]string{
+		SensorThermocoupleK: "degC",
+		SensorThermocoupleJ: "degC",
+		SensorThermocoupleT: "degC",
+		SensorPT100:         "degC",
+		SensorPT1000:        "degC",
+		SensorPKR251:        "mbar",
+	}
+	// sensorThermocouples are the thermocouple types of the thermocouple sensors
+	sensorThermocouples = map[string]string{
+		SensorThermocoupleK: ThermocoupleK,
+		SensorThermocoupleJ: ThermocoupleJ,
+		SensorThermocoupleT: ThermocoupleT,
+	}
+)
+
+// MeasuredUnit returns the unit of the channel before its conversion to ConvertTo, which is that of its sensor type
+// if it has one
+func (t CfgTag) MeasuredUnit() string {
+	if unit, ok := SensorUnits[t.Sensor]; ok {
+		return unit
+	}
+	return t.Unit
+}
+
+// validateSensor returns the problems with the sensor type of a channel. A thermocouple compensated with an external
+// reference junction has to be of the same type as its sensor
+func (t CfgTag) validateSensor() []string {
+	var problems []string
+	if _, ok := SensorUnits[t.Sensor]; !ok {
+		return append(problems, fmt.Sprintf("has unknown Sensor %q", t.Sensor))
+	}
+	if t.Thermocouple != nil && sensorThermocouples[t.Sensor] != t.Thermocouple.Type {
+		problems = append(problems, fmt.Sprintf("Sensor %q doesn't match Thermocouple Type %q", t.Sensor, t.Thermocouple.Type))
+	}
+	return problems
+}
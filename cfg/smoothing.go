@@ -0,0 +1,29 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// Smoothing filters the readings of a noisy channel before they're sent, either with a moving average of the last
+// Samples readings or with exponential smoothing by a factor of Alpha, the weight of the newest reading. The reading
+// before smoothing is included in the payload as well with IncludeRaw
+type Smoothing struct {
+	Samples    int64   `yaml:"Samples" json:"Samples"`
+	Alpha      float64 `yaml:"Alpha" json:"Alpha"`
+	IncludeRaw bool    `yaml:"IncludeRaw" json:"IncludeRaw"`
+}
+
+// validate returns the problems with the smoothing
+func (s *Smoothing) validate() []string {
+	var problems []string
+	if (s.Samples != 0) == (s.Alpha != 0) {
+		problems = append(problems, "must have exactly one of Samples and Alpha")
+	}
+	if s.Samples < 0 {
+		problems = append(problems, "Samples cannot be negative")
+	}
+	if s.Alpha < 0 || s.Alpha > 1 {
+		problems = append(problems, fmt.Sprintf("Alpha %v must be between 0 and 1", s.Alpha))
+	}
+	return problems
+}
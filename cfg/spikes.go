@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// SpikeFilter rejects single sample spikes of a channel, such as those caused by chattering scanner relays. A reading
+// is a spike when it's further than MaxJump from the previous good reading, or from the median of the last Median good
+// readings if set. Spikes are replaced by the previous good reading. After MaxRejects spikes in a row (default 1) the
+// reading is taken as a genuine step change and accepted
+type SpikeFilter struct {
+	MaxJump    float64 `yaml:"MaxJump" json:"MaxJump"`
+	Median     int64   `yaml:"Median,omitempty" json:"Median"`
+	MaxRejects int64   `yaml:"MaxRejects,omitempty" json:"MaxRejects"`
+}
+
+// validate returns the problems with the spike filter
+func (s *SpikeFilter) validate() []string {
+	var problems []string
+	if s.MaxJump <= 0 {
+		problems = append(problems, fmt.Sprintf("MaxJump %v must be greater than 0", s.MaxJump))
+	}
+	if s.Median < 0 {
+		problems = append(problems, "Median cannot be negative")
+	}
+	if s.MaxRejects < 0 {
+		problems = append(problems, "MaxRejects cannot be negative")
+	}
+	return problems
+}
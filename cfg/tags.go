@@ -0,0 +1,116 @@
+package cfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TagMap maps tag indices to channels. Besides single indices, keys can be ranges like "101-120" which define a
+// channel for every index in the range, replacing {n} in the channel's Tag and OPCTag with the index. A channel can
+// also be given as just its name, e.g. 101-120: "TC_{n}"
+type TagMap map[int]CfgTag
+
+// IsTagPattern returns true if the given OPC tag is a wildcard pattern to be matched against the browsed tags
+func IsTagPattern(opcTag string) bool {
+	return strings.ContainsAny(opcTag, "*?[")
+}
+
+// parseTagKey returns the indices covered by a tag map key, which is either a single index or a range
+func parseTagKey(key string) ([]int, error) {
+	if i, err := strconv.Atoi(key); err == nil {
+		return []int{i}, nil
+	}
+	bounds := strings.SplitN(key, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid tag index %q", key)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag index %q", key)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil || end < start {
+		return nil, fmt.Errorf("invalid tag index %q", key)
+	}
+	idxs := make([]int, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		idxs = append(idxs, i)
+	}
+	return idxs, nil
+}
+
+// add defines the given channel at every index covered by key
+func (m TagMap) add(key string, tag CfgTag) error {
+	idxs, err := parseTagKey(key)
+	if err != nil {
+		return err
+	}
+	for _, i := range idxs {
+		if _, ok := m[i]; ok {
+			return fmt.Errorf("tag index %d is defined more than once", i)
+		}
+		n := strconv.Itoa(i)
+		t := tag
+		t.Tag = strings.ReplaceAll(t.Tag, "{n}", n)
+		t.OPCTag = strings.ReplaceAll(t.OPCTag, "{n}", n)
+		m[i] = t
+	}
+	return nil
+}
+
+// tagValue is a channel in a tag map, given either in full or as just its name
+type tagValue CfgTag
+
+// UnmarshalYAML decodes a channel given either in full or as just its name
+func (t *tagValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*t = tagValue{Tag: name}
+		return nil
+	}
+	return unmarshal((*CfgTag)(t))
+}
+
+// UnmarshalYAML expands ranges and channel name shorthands while decoding a YAML tag map
+func (m *TagMap) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]tagValue
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	tags := make(TagMap, len(raw))
+	for key, value := range raw {
+		if err := tags.add(key, CfgTag(value)); err != nil {
+			return err
+		}
+	}
+	*m = tags
+	return nil
+}
+
+// UnmarshalJSON expands ranges and channel name shorthands while decoding a JSON tag map
+func (m *TagMap) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		// wrapped since the offset of the error is into the tag map rather than the file
+		return fmt.Errorf("tag map: %w", err)
+	}
+	tags := make(TagMap, len(raw))
+	for key, value := range raw {
+		var tag CfgTag
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte(`"`)) {
+			if err := json.Unmarshal(value, &tag.Tag); err != nil {
+				return err
+			}
+		} else if err := decodeJSONStrict(value, &tag); err != nil {
+			return fmt.Errorf("tag %s: %w", key, err)
+		}
+		if err := tags.add(key, tag); err != nil {
+			return err
+		}
+	}
+	*m = tags
+	return nil
+}
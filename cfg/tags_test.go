@@ -0,0 +1,106 @@
+package cfg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTagKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    []int
+		wantErr bool
+	}{
+		{key: "0", want: []int{0}},
+		{key: "101", want: []int{101}},
+		{key: "101-103", want: []int{101, 102, 103}},
+		{key: "101 - 102", want: []int{101, 102}},
+		{key: "5-5", want: []int{5}},
+		{key: "103-101", wantErr: true},
+		{key: "101-", wantErr: true},
+		{key: "a-b", wantErr: true},
+		{key: "TC_1", wantErr: true},
+		{key: "", wantErr: true},
+	}
+	for _, test := range tests {
+		idxs, err := parseTagKey(test.key)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseTagKey(%q) = %v, expected an error", test.key, idxs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTagKey(%q): %v", test.key, err)
+		} else if !reflect.DeepEqual(idxs, test.want) {
+			t.Errorf("parseTagKey(%q) = %v, expected %v", test.key, idxs, test.want)
+		}
+	}
+}
+
+func TestTagMapAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		tag     CfgTag
+		want    TagMap
+		wantErr bool
+	}{
+		{
+			name: "single index",
+			keys: []string{"1"},
+			tag:  CfgTag{Tag: "TC_1", OPCTag: "Channel_1"},
+			want: TagMap{1: {Tag: "TC_1", OPCTag: "Channel_1"}},
+		},
+		{
+			name: "range",
+			keys: []string{"101-102"},
+			tag:  CfgTag{Tag: "TC_{n}", OPCTag: "Channel_{n}", Type: "temperature"},
+			want: TagMap{
+				101: {Tag: "TC_101", OPCTag: "Channel_101", Type: "temperature"},
+				102: {Tag: "TC_102", OPCTag: "Channel_102", Type: "temperature"},
+			},
+		},
+		{
+			name: "range without placeholder",
+			keys: []string{"1-2"},
+			tag:  CfgTag{Tag: "TC"},
+			want: TagMap{1: {Tag: "TC"}, 2: {Tag: "TC"}},
+		},
+		{
+			name:    "index defined twice",
+			keys:    []string{"1-3", "3"},
+			tag:     CfgTag{Tag: "TC_{n}"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			keys:    []string{"one"},
+			tag:     CfgTag{Tag: "TC_1"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := make(TagMap)
+			var err error
+			for _, key := range test.keys {
+				if err = m.add(key, test.tag); err != nil {
+					break
+				}
+			}
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			if !reflect.DeepEqual(m, test.want) {
+				t.Fatalf("tag map %v, expected %v", m, test.want)
+			}
+		})
+	}
+}
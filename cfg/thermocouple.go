@@ -0,0 +1,52 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	ThermocoupleK = "K"
+	ThermocoupleJ = "J"
+	ThermocoupleT = "T"
+)
+
+// Thermocouple applies the cold junction compensation of a thermocouple channel read as EMF, in mV, using the
+// temperature of an external reference junction, such as an ice point reference, read on the Reference channel in
+// °C. The channel is sent in degC
+type Thermocouple struct {
+	Type      string `yaml:"Type" json:"Type"`
+	Reference string `yaml:"Reference" json:"Reference"`
+}
+
+// validateThermocouples returns the problems with the thermocouple channels. Their reference has to be another
+// channel which isn't itself a thermocouple
+func validateThermocouples(daqs []DAQConfig) []string {
+	var problems []string
+	channels := make(map[string]bool)
+	for _, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 {
+				channels[tag.Tag] = tag.Thermocouple != nil
+			}
+		}
+	}
+	for d, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i == 0 || tag.Thermocouple == nil {
+				continue
+			}
+			switch tag.Thermocouple.Type {
+			case ThermocoupleK, ThermocoupleJ, ThermocoupleT:
+			default:
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d Thermocouple has unknown Type %q", d, i, tag.Thermocouple.Type))
+			}
+			if thermocouple, ok := channels[tag.Thermocouple.Reference]; !ok || thermocouple {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d Thermocouple Reference %q isn't a channel other than a thermocouple", d, i, tag.Thermocouple.Reference))
+			}
+			if tag.ConvertTo != "" {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d cannot have both Thermocouple and ConvertTo", d, i))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,35 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+// Trigger holds back frames at the start of a recording until a channel crosses a threshold, e.g. until the chamber
+// pressure drops below 1e-3 Torr. Exactly one of Below and Above is set. The last PreTriggerScans scans polled
+// while waiting are sent once triggered
+type Trigger struct {
+	Channel         string   `yaml:"Channel" json:"Channel"`
+	Below           *float64 `yaml:"Below,omitempty" json:"Below"`
+	Above           *float64 `yaml:"Above,omitempty" json:"Above"`
+	DeferScanning   bool     `yaml:"DeferScanning" json:"DeferScanning"`
+	PreTriggerScans int64    `yaml:"PreTriggerScans" json:"PreTriggerScans"`
+}
+
+// validate returns the problems with the trigger. The channel has to be a channel of one of the DAQs
+func (t *Trigger) validate(daqs []DAQConfig) []string {
+	var problems []string
+	if (t.Below == nil) == (t.Above == nil) {
+		problems = append(problems, "Trigger must have exactly one of Below and Above")
+	}
+	if t.PreTriggerScans < 0 {
+		problems = append(problems, "Trigger PreTriggerScans cannot be negative")
+	}
+	for _, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 && tag.Tag == t.Channel {
+				return problems
+			}
+		}
+	}
+	return append(problems, fmt.Sprintf("Trigger Channel %q is not a channel in FlukeTags", t.Channel))
+}
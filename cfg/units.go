@@ -0,0 +1,57 @@
+package cfg
+
+import (
+	"fmt"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	ErrUnknownUnit       = bg.Error("unknown unit")
+	ErrIncompatibleUnits = bg.Error("units measure different quantities")
+)
+
+// unit converts a value to the base unit of its quantity, kelvin or pascal, as value * scale + offset
+type unit struct {
+	quantity string
+	scale    float64
+	offset   float64
+}
+
+// units are the units channels can be converted between
+var units = map[string]unit{
+	"K":     {quantity: "temperature", scale: 1},
+	"degC":  {quantity: "temperature", scale: 1, offset: 273.15},
+	"°C":    {quantity: "temperature", scale: 1, offset: 273.15},
+	"degF":  {quantity: "temperature", scale: 5.0 / 9, offset: 273.15 - 32*5.0/9},
+	"°F":    {quantity: "temperature", scale: 5.0 / 9, offset: 273.15 - 32*5.0/9},
+	"Pa":    {quantity: "pressure", scale: 1},
+	"kPa":   {quantity: "pressure", scale: 1e3},
+	"mbar":  {quantity: "pressure", scale: 100},
+	"bar":   {quantity: "pressure", scale: 1e5},
+	"Torr":  {quantity: "pressure", scale: 101325.0 / 760},
+	"mTorr": {quantity: "pressure", scale: 101325.0 / 760 / 1000},
+	"psi":   {quantity: "pressure", scale: 6894.757293168},
+	"atm":   {quantity: "pressure", scale: 101325},
+}
+
+// UnitConversion returns a function converting values from one unit to another, e.g. from degC to K or from Torr
+// to mbar
+func UnitConversion(from, to string) (func(float64) float64, error) {
+	f, ok := units[from]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownUnit, from)
+	}
+	t, ok := units[to]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownUnit, to)
+	}
+	if f.quantity != t.quantity {
+		return nil, fmt.Errorf("%w: %s and %s", ErrIncompatibleUnits, from, to)
+	}
+	// the offsets are combined first to keep the rounding error down
+	offset := f.offset - t.offset
+	return func(v float64) float64 {
+		return (v*f.scale + offset) / t.scale
+	}, nil
+}
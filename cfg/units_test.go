@@ -0,0 +1,46 @@
+package cfg
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestUnitConversion(t *testing.T) {
+	tests := []struct {
+		from, to string
+		value    float64
+		want     float64
+		wantErr  error
+	}{
+		{from: "degC", to: "K", value: 0, want: 273.15},
+		{from: "K", to: "degC", value: 0, want: -273.15},
+		{from: "°C", to: "degF", value: 100, want: 212},
+		{from: "degF", to: "°C", value: -40, want: -40},
+		{from: "degC", to: "degC", value: 21.5, want: 21.5},
+		{from: "Torr", to: "mbar", value: 760, want: 1013.25},
+		{from: "atm", to: "kPa", value: 1, want: 101.325},
+		{from: "psi", to: "Pa", value: 1, want: 6894.757293168},
+		{from: "mTorr", to: "Torr", value: 1500, want: 1.5},
+		{from: "bar", to: "atm", value: 1.01325, want: 1},
+		{from: "degC", to: "mbar", wantErr: ErrIncompatibleUnits},
+		{from: "degR", to: "K", wantErr: ErrUnknownUnit},
+		{from: "K", to: "C", wantErr: ErrUnknownUnit},
+	}
+	for _, test := range tests {
+		convert, err := UnitConversion(test.from, test.to)
+		if test.wantErr != nil {
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("UnitConversion(%q, %q) returned %v, expected %v", test.from, test.to, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("UnitConversion(%q, %q): %v", test.from, test.to, err)
+			continue
+		}
+		if got := convert(test.value); math.Abs(got-test.want) > 1e-9*math.Max(1, math.Abs(test.want)) {
+			t.Errorf("%v %s = %v %s, expected %v", test.value, test.from, got, test.to, test.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	AcquisitionModePoll               = "poll"
+	AcquisitionModeSubscription       = "subscription"
+	PayloadEncodingJSON               = "json"
+	PayloadEncodingProtobuf           = "protobuf"
+	PayloadEncodingCSV                = "csv"
+	BadValuePolicyDrop                = "drop"
+	BadValuePolicyNull                = "null"
+	BadValuePolicyLastGood            = "last-good"
+	BadValuePolicyFlag                = "flag"
+	ParquetPeriodHour                 = "hour"
+	ParquetPeriodDay                  = "day"
+	ModbusTypeFloat32                 = "float32"
+	ModbusTypeInt16                   = "int16"
+	KafkaSASLPlain                    = "plain"
+	KafkaSASLScramSHA256              = "scram-sha-256"
+	KafkaSASLScramSHA512              = "scram-sha-512"
+	InfluxVersion1              int64 = 1
+	InfluxVersion2              int64 = 2
+	MinPollingInterval          int64 = 1
+	MaxPollingInterval          int64 = 3600
+	MaxPrecision                int64 = 15
+)
+
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config:\n\t%s", strings.Join(e.Problems, "\n\t"))
+}
+
+// Validate checks the whole config and returns a ValidationError listing every problem found
+func (c *Config) Validate() error {
+	var problems []string
+	// the server settings aren't needed when points are exported to files
+	if c.Influx && c.InfluxExportDir == "" {
+		if u, err := url.Parse(c.InfluxURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("InfluxURL %q is not a valid http or https URL", c.InfluxURL))
+		}
+		if (c.InfluxCertFile == "") != (c.InfluxKeyFile == "") {
+			problems = append(problems, "InfluxCertFile and InfluxKeyFile must be set together")
+		}
+		switch c.InfluxVersion {
+		case 0, InfluxVersion2:
+			if c.InfluxAPIToken == "" {
+				problems = append(problems, "InfluxAPIToken cannot be blank when Influx is enabled")
+			}
+			if c.InfluxOrgName == "" {
+				problems = append(problems, "InfluxOrgName cannot be blank when Influx is enabled")
+			}
+			if c.InfluxBucketName == "" {
+				problems = append(problems, "InfluxBucketName cannot be blank when Influx is enabled")
+			}
+		case InfluxVersion1:
+			if c.InfluxDatabase == "" {
+				problems = append(problems, "InfluxDatabase cannot be blank when writing to InfluxDB 1.x")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("InfluxVersion must be %d or %d", InfluxVersion1, InfluxVersion2))
+		}
+	}
+	if c.PollingInterval != 0 && (c.PollingInterval < MinPollingInterval || c.PollingInterval > MaxPollingInterval) {
+		problems = append(problems, fmt.Sprintf("PollingInterval must be between %d and %d seconds, or 0 for the default", MinPollingInterval, MaxPollingInterval))
+	}
+	// checked in order of name so that the problems are reported in a stable order
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"ArchiveDays", c.ArchiveDays},
+		{"BurstDuration", c.BurstDuration},
+		{"BurstInterval", c.BurstInterval},
+		{"CSVLogMaxMB", c.CSVLogMaxMB},
+		{"ConnectAttempts", c.ConnectAttempts},
+		{"ConnectRetryDelay", c.ConnectRetryDelay},
+		{"HeartbeatInterval", c.HeartbeatInterval},
+		{"InfluxAggInterval", c.InfluxAggInterval},
+		{"InfluxBatchSize", c.InfluxBatchSize},
+		{"InfluxExportEvery", c.InfluxExportEvery},
+		{"InfluxExportMaxMB", c.InfluxExportMaxMB},
+		{"InfluxFlushPeriod", c.InfluxFlushPeriod},
+		{"InfluxInterval", c.InfluxInterval},
+		{"InfluxQueueMaxAge", c.InfluxQueueMaxAge},
+		{"InfluxQueueMaxMB", c.InfluxQueueMaxMB},
+		{"MaxDuration", c.MaxDuration},
+		{"MaxFrames", c.MaxFrames},
+		{"ReadTimeout", c.ReadTimeout},
+		{"ReadWorkers", c.ReadWorkers},
+		{"SampleInterval", c.SampleInterval},
+		{"SpillTimeout", c.SpillTimeout},
+		{"StaleAfter", c.StaleAfter},
+		{"TagCacheTTL", c.TagCacheTTL},
+		{"WarmupDelay", c.WarmupDelay},
+	} {
+		if field.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s cannot be negative", field.name))
+		}
+	}
+	if c.SampleInterval > 0 && c.PollingInterval != 0 && c.SampleInterval >= c.PollingInterval*1000 {
+		problems = append(problems, "SampleInterval must be shorter than PollingInterval")
+	}
+	switch c.AcquisitionMode {
+	case "", AcquisitionModePoll, AcquisitionModeSubscription:
+	default:
+		problems = append(problems, fmt.Sprintf("AcquisitionMode must be %q or %q", AcquisitionModePoll, AcquisitionModeSubscription))
+	}
+	// subscribed channels are only read once the DAQs push their changes, so nothing else can read them in between
+	// and channels which don't change aren't seen at all
+	if c.Subscribed() {
+		if c.WaitForGoodRead {
+			problems = append(problems, "WaitForGoodRead cannot be combined with AcquisitionMode subscription")
+		}
+		if c.SampleInterval > 0 {
+			problems = append(problems, "SampleInterval cannot be combined with AcquisitionMode subscription")
+		}
+		if c.StaleAfter > 0 {
+			problems = append(problems, "StaleAfter cannot be combined with AcquisitionMode subscription")
+		}
+		if c.GoldenDir != "" {
+			problems = append(problems, "GoldenDir cannot be combined with AcquisitionMode subscription")
+		}
+	}
+	switch c.PayloadEncoding {
+	case "", PayloadEncodingJSON, PayloadEncodingProtobuf, PayloadEncodingCSV:
+	default:
+		problems = append(problems, fmt.Sprintf("PayloadEncoding must be %q, %q or %q", PayloadEncodingJSON, PayloadEncodingProtobuf, PayloadEncodingCSV))
+	}
+	if len(c.FlukeTags) > 0 {
+		problems = append(problems, "FlukeTags must be defined for each DAQ under DAQs since config version 2")
+	}
+	switch c.ParquetPeriod {
+	case "", ParquetPeriodHour, ParquetPeriodDay:
+	default:
+		problems = append(problems, fmt.Sprintf("ParquetPeriod must be %q or %q", ParquetPeriodHour, ParquetPeriodDay))
+	}
+	switch c.BadValuePolicy {
+	case "", BadValuePolicyDrop, BadValuePolicyNull, BadValuePolicyLastGood, BadValuePolicyFlag:
+	default:
+		problems = append(problems, fmt.Sprintf("BadValuePolicy must be %q, %q, %q or %q", BadValuePolicyDrop, BadValuePolicyNull, BadValuePolicyLastGood, BadValuePolicyFlag))
+	}
+	for _, channelType := range sortedKeys(c.Precision) {
+		if p := c.Precision[channelType]; p < 0 || p > MaxPrecision {
+			problems = append(problems, fmt.Sprintf("Precision of %q must be between 0 and %d decimal places", channelType, MaxPrecision))
+		}
+	}
+	for i, w := range c.Schedule {
+		if _, _, _, err := w.parse(); err != nil {
+			problems = append(problems, fmt.Sprintf("Schedule window %d: %v", i, err))
+		}
+	}
+	if c.Trigger != nil {
+		problems = append(problems, c.Trigger.validate(c.DAQs)...)
+	}
+	if c.HTTPAddress != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("HTTPAddress %q is not a valid host:port address", c.HTTPAddress))
+		}
+	}
+	if c.UDPAddress != "" {
+		if _, _, err := net.SplitHostPort(c.UDPAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("UDPAddress %q is not a valid host:port address", c.UDPAddress))
+		}
+	}
+	if c.MQTT != nil {
+		problems = append(problems, c.MQTT.validate()...)
+	}
+	if c.Kafka != nil {
+		problems = append(problems, c.Kafka.validate()...)
+	}
+	if c.Modbus != nil {
+		problems = append(problems, c.Modbus.validate(c.DAQs)...)
+	}
+	if c.Webhook != nil {
+		problems = append(problems, c.Webhook.validate()...)
+	}
+	if c.Replay != nil {
+		problems = append(problems, c.Replay.validate(c.Simulate)...)
+	}
+	if c.FaultInjection != nil {
+		problems = append(problems, c.FaultInjection.validate()...)
+	}
+	if c.Decimation != nil {
+		problems = append(problems, c.Decimation.validate()...)
+	}
+	// reads taken between scans aren't replayed in step with them, so their frames couldn't be reproduced
+	if c.GoldenDir != "" && c.SampleInterval > 0 {
+		problems = append(problems, "GoldenDir cannot be combined with SampleInterval")
+	}
+	problems = append(problems, validateThermocouples(c.DAQs)...)
+	problems = append(problems, validateVirtualChannels(c.VirtualChannels, c.DAQs)...)
+	problems = append(problems, validateAlarmRules(c.AlarmRules, c.DAQs, c.VirtualChannels)...)
+	problems = append(problems, c.validateDAQNames()...)
+	names := make(map[string]bool)
+	for d, daq := range c.DAQs {
+		if len(daq.FlukeTags) == 0 {
+			problems = append(problems, fmt.Sprintf("DAQ %d has no FlukeTags", d))
+			continue
+		}
+		if _, ok := daq.FlukeTags[0]; !ok {
+			problems = append(problems, fmt.Sprintf("DAQ %d is missing the scan control tag at index 0", d))
+		}
+		for _, i := range daq.Pressure.Channels {
+			if _, ok := daq.FlukeTags[i]; !ok || i == 0 {
+				problems = append(problems, fmt.Sprintf("DAQ %d pressure channel %d is not a channel in FlukeTags", d, i))
+			}
+		}
+		for _, i := range sortedIndices(daq.FlukeTags) {
+			tag := daq.FlukeTags[i]
+			switch {
+			case i < 0:
+				problems = append(problems, fmt.Sprintf("DAQ %d tag index %d cannot be negative", d, i))
+			case tag.Tag == "":
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d has a blank name", d, i))
+			case i != 0 && names[tag.Tag]:
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d has duplicate name %q", d, i, tag.Tag))
+			}
+			if _, err := path.Match(tag.OPCTag, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d has an invalid OPCTag pattern %q", d, i, tag.OPCTag))
+			}
+			if tag.PollEvery < 0 {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d PollEvery cannot be negative", d, i))
+			}
+			if tag.High != nil && tag.Low != nil && *tag.Low >= *tag.High {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d Low must be below High", d, i))
+			}
+			if tag.MaxRate != nil && *tag.MaxRate <= 0 {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d MaxRate must be positive", d, i))
+			}
+			if tag.RateWindow < 0 {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d RateWindow cannot be negative", d, i))
+			}
+			if tag.Deadband < 0 || tag.RateDeadband < 0 {
+				problems = append(problems, fmt.Sprintf("DAQ %d tag %d Deadband and RateDeadband cannot be negative", d, i))
+			}
+			if tag.ConvertTo != "" {
+				if _, err := UnitConversion(tag.MeasuredUnit(), tag.ConvertTo); err != nil {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d cannot be converted from Unit %q to %q: %v", d, i, tag.MeasuredUnit(), tag.ConvertTo, err))
+				}
+			}
+			if tag.Kind != "" {
+				for _, problem := range tag.validateKind() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d %s", d, i, problem))
+				}
+			}
+			if tag.Sensor != "" {
+				for _, problem := range tag.validateSensor() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d %s", d, i, problem))
+				}
+			}
+			if tag.Spikes != nil {
+				for _, problem := range tag.Spikes.validate() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d Spikes %s", d, i, problem))
+				}
+			}
+			if tag.Smoothing != nil {
+				for _, problem := range tag.Smoothing.validate() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d Smoothing %s", d, i, problem))
+				}
+			}
+			if tag.Calibration != nil {
+				for _, problem := range tag.Calibration.validate() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d Calibration %s", d, i, problem))
+				}
+			}
+			if tag.Waveform != nil {
+				for _, problem := range tag.Waveform.validate() {
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d Waveform %s", d, i, problem))
+				}
+			}
+			for _, label := range sortedLabels(tag.Labels) {
+				switch label {
+				case "":
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d has a blank label", d, i))
+				case "id", "unit":
+					problems = append(problems, fmt.Sprintf("DAQ %d tag %d label %q is reserved", d, i, label))
+				}
+			}
+			// every DAQ has its own scan control tag which never appears in the payload
+			if i != 0 {
+				names[tag.Tag] = true
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateDAQNames checks that no two DAQs go by the same name, since their frames, status and alarms are told apart
+// by it. DAQs without a Name go by their server and host, except when simulated or replayed where they would all go by
+// the name of the stand in server
+func (c *Config) validateDAQNames() []string {
+	var problems []string
+	standIn := c.Simulate || c.Replay != nil
+	seen := make(map[string]int)
+	for d, daq := range c.DAQs {
+		name := daq.Name
+		if name == "" && !standIn {
+			name = daq.DefaultName()
+		}
+		other, ok := seen[name]
+		switch {
+		case !ok:
+			seen[name] = d
+		case name == "":
+			problems = append(problems, fmt.Sprintf("DAQ %d needs a Name to be told apart from DAQ %d when simulated or replayed", d, other))
+		case daq.Name == "":
+			problems = append(problems, fmt.Sprintf("DAQ %d has no Name and defaults to %q, the name of DAQ %d", d, name, other))
+		default:
+			problems = append(problems, fmt.Sprintf("DAQ %d has duplicate name %q", d, name))
+		}
+	}
+	return problems
+}
+
+// Subscribed returns true if the channels are subscribed to, so that the DAQs push the channels which changed rather
+// than every channel being polled
+func (c *Config) Subscribed() bool {
+	return c.AcquisitionMode == AcquisitionModeSubscription
+}
+
+// Warnings returns non-fatal oddities in the config, like gaps in the tag indices, which are worth logging
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.migratedFrom != 0 && c.migratedFrom < c.Version {
+		warnings = append(warnings, fmt.Sprintf("Config file is version %d and was upgraded to version %d when loaded. Update the file to the current layout to stop this warning", c.migratedFrom, c.Version))
+	}
+	if c.Subscribed() && c.GroupRead {
+		warnings = append(warnings, "GroupRead is ignored with AcquisitionMode subscription since the DAQs push their changes")
+	}
+	for d, daq := range c.DAQs {
+		idxs := sortedIndices(daq.FlukeTags)
+		for _, i := range idxs {
+			if i != 0 && daq.FlukeTags[i].Type == "" {
+				warnings = append(warnings, fmt.Sprintf("DAQ %d tag %d has no Type and won't be written to Influx", d, i))
+			}
+			if daq.FlukeTags[i].Waveform != nil && !c.Simulate {
+				warnings = append(warnings, fmt.Sprintf("DAQ %d tag %d has a Waveform which is only used with Simulate", d, i))
+			}
+		}
+		for j := 1; j < len(idxs); j++ {
+			if idxs[j] != idxs[j-1]+1 {
+				warnings = append(warnings, fmt.Sprintf("DAQ %d tag indices skip from %d to %d", d, idxs[j-1], idxs[j]))
+			}
+		}
+	}
+	return warnings
+}
+
+// sortedIndices returns the indices of the given tag map in ascending order
+func sortedIndices(tags map[int]CfgTag) []int {
+	idxs := make([]int, 0, len(tags))
+	for i := range tags {
+		idxs = append(idxs, i)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+// sortedKeys returns the keys of a map in order so that problems are reported in a stable order
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedLabels returns the keys of a channel's labels in order
+func sortedLabels(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
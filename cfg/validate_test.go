@@ -0,0 +1,134 @@
+package cfg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testDAQ returns a DAQ with a scan control tag and the given channel, which is valid on its own
+func testDAQ(name, host, channel string) DAQConfig {
+	return DAQConfig{
+		Name: name,
+		Host: host,
+		FlukeTags: TagMap{
+			0: {Tag: "Scan"},
+			1: {Tag: channel, Type: "temperature"},
+		},
+	}
+}
+
+// validationProblems returns the problems found by validating the config, or none if it's valid
+func validationProblems(t *testing.T, c *Config) []string {
+	t.Helper()
+	err := c.Validate()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate returned %v, expected a ValidationError", err)
+	}
+	return verr.Problems
+}
+
+func TestValidateDAQNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		simulate bool
+		daqs     []DAQConfig
+		problem  string
+	}{
+		{name: "distinct names", daqs: []DAQConfig{testDAQ("a", "", "TC_1"), testDAQ("b", "", "TC_2")}},
+		{name: "duplicate names", daqs: []DAQConfig{testDAQ("a", "", "TC_1"), testDAQ("a", "other", "TC_2")}, problem: `DAQ 1 has duplicate name "a"`},
+		{name: "defaulted names on different hosts", daqs: []DAQConfig{testDAQ("", "rig1", "TC_1"), testDAQ("", "rig2", "TC_2")}},
+		{name: "defaulted names on the same host", daqs: []DAQConfig{testDAQ("", "", "TC_1"), testDAQ("", "", "TC_2")}, problem: `DAQ 1 has no Name and defaults to "Fluke.DAQ.OPC@localhost"`},
+		{name: "name of a defaulted name", daqs: []DAQConfig{testDAQ("", "rig1", "TC_1"), testDAQ("Fluke.DAQ.OPC@rig1", "", "TC_2")}, problem: `DAQ 1 has duplicate name "Fluke.DAQ.OPC@rig1"`},
+		{name: "simulated without names", simulate: true, daqs: []DAQConfig{testDAQ("", "rig1", "TC_1"), testDAQ("", "rig2", "TC_2")}, problem: "DAQ 1 needs a Name"},
+		{name: "simulated with names", simulate: true, daqs: []DAQConfig{testDAQ("a", "", "TC_1"), testDAQ("b", "", "TC_2")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := validationProblems(t, &Config{Simulate: test.simulate, DAQs: test.daqs})
+			if test.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("unexpected problems %q", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], test.problem) {
+				t.Fatalf("problems %q, expected one starting with %q", problems, test.problem)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	daqs := []DAQConfig{testDAQ("a", "", "TC_1")}
+	tests := []struct {
+		name     string
+		config   Config
+		problems []string
+	}{
+		{name: "valid", config: Config{DAQs: daqs}},
+		{
+			name:     "negative value",
+			config:   Config{ReadTimeout: -1, DAQs: daqs},
+			problems: []string{"ReadTimeout cannot be negative"},
+		},
+		{
+			name:     "negative values in order of name",
+			config:   Config{WarmupDelay: -1, ArchiveDays: -1, MaxFrames: -1, DAQs: daqs},
+			problems: []string{"ArchiveDays cannot be negative", "MaxFrames cannot be negative", "WarmupDelay cannot be negative"},
+		},
+		{
+			name:     "polling interval out of range",
+			config:   Config{PollingInterval: MaxPollingInterval + 1, DAQs: daqs},
+			problems: []string{"PollingInterval must be between 1 and 3600 seconds, or 0 for the default"},
+		},
+		{
+			name:     "unknown acquisition mode",
+			config:   Config{AcquisitionMode: "push", DAQs: daqs},
+			problems: []string{`AcquisitionMode must be "poll" or "subscription"`},
+		},
+		{
+			name:     "subscription reading every tick",
+			config:   Config{AcquisitionMode: AcquisitionModeSubscription, WaitForGoodRead: true, StaleAfter: 60, DAQs: daqs},
+			problems: []string{"WaitForGoodRead cannot be combined with AcquisitionMode subscription", "StaleAfter cannot be combined with AcquisitionMode subscription"},
+		},
+		{
+			name:   "influx without settings",
+			config: Config{Influx: true, InfluxURL: "influx:8086", DAQs: daqs},
+			problems: []string{
+				`InfluxURL "influx:8086" is not a valid http or https URL`,
+				"InfluxAPIToken cannot be blank when Influx is enabled",
+				"InfluxOrgName cannot be blank when Influx is enabled",
+				"InfluxBucketName cannot be blank when Influx is enabled",
+			},
+		},
+		{
+			name:     "invalid HTTP address",
+			config:   Config{HTTPAddress: "8080", DAQs: daqs},
+			problems: []string{`HTTPAddress "8080" is not a valid host:port address`},
+		},
+		{
+			name:     "missing scan control tag",
+			config:   Config{DAQs: []DAQConfig{{Name: "a", FlukeTags: TagMap{1: {Tag: "TC_1"}}}}},
+			problems: []string{"DAQ 0 is missing the scan control tag at index 0"},
+		},
+		{
+			name:     "channel names shared by DAQs",
+			config:   Config{DAQs: []DAQConfig{testDAQ("a", "", "TC_1"), testDAQ("b", "", "TC_1")}},
+			problems: []string{`DAQ 1 tag 1 has duplicate name "TC_1"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := validationProblems(t, &test.config)
+			if !reflect.DeepEqual(problems, test.problems) {
+				t.Fatalf("problems %q, expected %q", problems, test.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package cfg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Knetic/govaluate"
+)
+
+var (
+	// ExpressionFuncs are the functions available to the expressions of virtual channels, e.g. avg(TC_1, TC_2)
+	ExpressionFuncs = map[string]govaluate.ExpressionFunction{
+		"avg": func(args ...interface{}) (interface{}, error) {
+			sum, err := sumArgs("avg", args)
+			return sum / float64(len(args)), err
+		},
+		"sum": func(args ...interface{}) (interface{}, error) {
+			return sumArgs("sum", args)
+		},
+		"min": func(args ...interface{}) (interface{}, error) {
+			return foldArgs("min", args, math.Min)
+		},
+		"max": func(args ...interface{}) (interface{}, error) {
+			return foldArgs("max", args, math.Max)
+		},
+		"abs":  unaryFunc("abs", math.Abs),
+		"sqrt": unaryFunc("sqrt", math.Sqrt),
+	}
+)
+
+// VirtualChannel is a channel computed from an expression over other channels, e.g. TC_12 - TC_07. Channel names
+// which aren't plain identifiers are written in brackets, e.g. [customer channel 1]. The functions avg, sum, min,
+// max, abs and sqrt are available
+type VirtualChannel struct {
+	Name       string `yaml:"Name" json:"Name"`
+	Expression string `yaml:"Expression" json:"Expression"`
+	Type       string `yaml:"Type" json:"Type"`
+	Unit       string `yaml:"Unit" json:"Unit"`
+}
+
+// unaryFunc returns an expression function applying f to its single numeric argument
+func unaryFunc(name string, f func(float64) float64) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s takes a single argument", name)
+		}
+		v, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s only takes numbers", name)
+		}
+		return f(v), nil
+	}
+}
+
+// foldArgs folds the numeric arguments of an expression function with f
+func foldArgs(name string, args []interface{}, f func(float64, float64) float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("%s needs at least one argument", name)
+	}
+	var result float64
+	for i, arg := range args {
+		v, ok := arg.(float64)
+		if !ok {
+			return 0, fmt.Errorf("%s only takes numbers", name)
+		}
+		if i == 0 {
+			result = f(v, v)
+			continue
+		}
+		result = f(result, v)
+	}
+	return result, nil
+}
+
+// sumArgs returns the sum of the numeric arguments of an expression function
+func sumArgs(name string, args []interface{}) (float64, error) {
+	return foldArgs(name, args, func(a, b float64) float64 { return a + b })
+}
+
+// validateVirtualChannels returns the problems with the virtual channels. Their names have to be unique among all
+// channels and their expressions can only refer to channels of the DAQs and virtual channels defined before them
+func validateVirtualChannels(virtuals []VirtualChannel, daqs []DAQConfig) []string {
+	var problems []string
+	channels := make(map[string]bool)
+	for _, daq := range daqs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 {
+				channels[tag.Tag] = true
+			}
+		}
+	}
+	for v, virtual := range virtuals {
+		switch {
+		case virtual.Name == "":
+			problems = append(problems, fmt.Sprintf("VirtualChannel %d has a blank Name", v))
+		case channels[virtual.Name]:
+			problems = append(problems, fmt.Sprintf("VirtualChannel %d has duplicate name %q", v, virtual.Name))
+		}
+		expr, err := govaluate.NewEvaluableExpressionWithFunctions(virtual.Expression, ExpressionFuncs)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("VirtualChannel %q has an invalid Expression: %v", virtual.Name, err))
+		} else {
+			for _, name := range expr.Vars() {
+				if !channels[name] {
+					problems = append(problems, fmt.Sprintf("VirtualChannel %q Expression refers to unknown channel %q", virtual.Name, name))
+				}
+			}
+		}
+		channels[virtual.Name] = true
+	}
+	return problems
+}
@@ -0,0 +1,70 @@
+package cfg
+
+import (
+	"fmt"
+)
+
+var (
+	WaveformConstant = "constant"
+	WaveformSine     = "sine"
+	WaveformRamp     = "ramp"
+	WaveformStep     = "step"
+	WaveformTrace    = "trace"
+)
+
+// Waveform is the signal read from a channel in simulation mode in place of the default for its kind, given in the
+// unit the channel is measured in with times in seconds since the DAQ was connected. A constant reads Level. A sine
+// reads Level plus a sine wave of the given Amplitude and Period. A ramp starts at Level and changes by Rate every
+// second, starting over every Period if set. A step reads Level until At and Level plus Amplitude after, repeating
+// every Period if set. A trace plays back Values, each held for Interval (default 1), or the Channel (default the
+// channel's Tag) of a recording File, over and over. Noise is the standard deviation of gaussian noise added to every
+// reading, drawn from a generator seeded with Seed so that it's the same from one run to the next
+type Waveform struct {
+	Shape     string    `yaml:"Shape" json:"Shape"`
+	Level     float64   `yaml:"Level" json:"Level"`
+	Amplitude float64   `yaml:"Amplitude,omitempty" json:"Amplitude"`
+	Period    float64   `yaml:"Period,omitempty" json:"Period"`
+	Rate      float64   `yaml:"Rate,omitempty" json:"Rate"`
+	At        float64   `yaml:"At,omitempty" json:"At"`
+	Values    []float64 `yaml:"Values,omitempty" json:"Values"`
+	Interval  float64   `yaml:"Interval,omitempty" json:"Interval"`
+	File      string    `yaml:"File,omitempty" json:"File"`
+	Channel   string    `yaml:"Channel,omitempty" json:"Channel"`
+	Noise     float64   `yaml:"Noise,omitempty" json:"Noise"`
+	Seed      int64     `yaml:"Seed,omitempty" json:"Seed"`
+}
+
+// validate returns the problems with the waveform
+func (w *Waveform) validate() []string {
+	var problems []string
+	switch w.Shape {
+	case WaveformConstant, WaveformRamp:
+	case WaveformSine:
+		if w.Period <= 0 {
+			problems = append(problems, fmt.Sprintf("Period %v must be greater than 0 for a sine", w.Period))
+		}
+	case WaveformStep:
+		if w.Period > 0 && (w.At < 0 || w.At >= w.Period) {
+			problems = append(problems, fmt.Sprintf("At %v must be within the Period %v of a repeating step", w.At, w.Period))
+		}
+	case WaveformTrace:
+		if len(w.Values) == 0 && w.File == "" {
+			problems = append(problems, "trace needs either Values or a File")
+		}
+		if len(w.Values) != 0 && w.File != "" {
+			problems = append(problems, "trace cannot have both Values and a File")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown Shape %q, expected one of constant, sine, ramp, step or trace", w.Shape))
+	}
+	if w.Period < 0 {
+		problems = append(problems, "Period cannot be negative")
+	}
+	if w.Interval < 0 {
+		problems = append(problems, "Interval cannot be negative")
+	}
+	if w.Noise < 0 {
+		problems = append(problems, "Noise cannot be negative")
+	}
+	return problems
+}
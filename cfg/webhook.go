@@ -0,0 +1,49 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+var (
+	// WebhookFuncs are the functions available to the webhook Body template. json quotes and escapes a value so
+	// that it can be embedded in the body, e.g. {"text": {{printf "%s is %v" .Channel .Value | json}}}
+	WebhookFuncs = template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+)
+
+// Webhook posts every alarm raised or cleared to a URL, e.g. a Slack or Teams incoming webhook. Body is a Go
+// template of the JSON body, executed with the alarm. Without it, the alarm is posted as is
+type Webhook struct {
+	URL        string            `yaml:"URL" json:"URL"`
+	Body       string            `yaml:"Body,omitempty" json:"Body"`
+	Headers    map[string]string `yaml:"Headers,omitempty" json:"Headers"`
+	Retries    int64             `yaml:"Retries" json:"Retries"`
+	RetryDelay int64             `yaml:"RetryDelay" json:"RetryDelay"`
+}
+
+// validate returns the problems with the webhook settings
+func (w *Webhook) validate() []string {
+	var problems []string
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("Webhook URL %q is not a valid http or https URL", w.URL))
+	}
+	if w.Body != "" {
+		if _, err := template.New("").Funcs(WebhookFuncs).Parse(w.Body); err != nil {
+			problems = append(problems, fmt.Sprintf("Webhook Body is not a valid template: %v", err))
+		}
+	}
+	if w.Retries < 0 {
+		problems = append(problems, "Webhook Retries cannot be negative")
+	}
+	if w.RetryDelay < 0 {
+		problems = append(problems, "Webhook RetryDelay cannot be negative")
+	}
+	return problems
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	sdk "github.com/SSSOC-CAN/laniakea-plugin-sdk"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	commandFrameType          = "application/json"
+	commandSetPollingInterval = "set_polling_interval"
+	commandPause              = "pause"
+	commandResume             = "resume"
+	commandBurst              = "burst"
+	commandMask               = "mask"
+	commandUnmask             = "unmask"
+	commandTrigger            = "trigger"
+	commandAcknowledge        = "acknowledge"
+	commandShelve             = "shelve"
+	commandUnshelve           = "unshelve"
+	commandRefreshTags        = "refresh_tags"
+	commandSetProfile         = "set_profile"
+	commandStatus             = "status"
+	ErrUnknownCommand         = bg.Error("unknown command")
+	ErrInvalidCommandType     = bg.Error("commands must be of type application/json")
+)
+
+// Compile time check to ensure FlukeDatasource satisfies the Controller interface so commands can be sent to it
+var _ sdk.Controller = (*FlukeDatasource)(nil)
+
+type Command struct {
+	Command         string   `json:"command"`
+	PollingInterval int64    `json:"polling_interval"`
+	BurstInterval   int64    `json:"burst_interval_ms"`
+	BurstDuration   int64    `json:"burst_duration"`
+	Channels        []string `json:"channels"`
+	ShelveMinutes   int64    `json:"shelve_minutes"`
+	Profile         string   `json:"profile"`
+}
+
+type CommandResult struct {
+	PollingInterval int64    `json:"polling_interval"`
+	Recording       bool     `json:"recording"`
+	Paused          bool     `json:"paused"`
+	Burst           bool     `json:"burst"`
+	Masked          []string `json:"masked"`
+	Shelved         []string `json:"shelved"`
+	Profile         string   `json:"profile,omitempty"`
+	Status          *Status  `json:"status,omitempty"`
+}
+
+// Implements the Controller interface function Command. Commands are JSON objects naming the command along with its
+// arguments, e.g. {"command": "set_polling_interval", "polling_interval": 10}. A single frame with the resulting
+// state of the plugin is sent back
+func (e *FlukeDatasource) Command(f *proto.Frame) (chan *proto.Frame, error) {
+	if f.Type != commandFrameType {
+		return nil, ErrInvalidCommandType
+	}
+	var cmd Command
+	if err := json.Unmarshal(f.Payload, &cmd); err != nil {
+		return nil, err
+	}
+	var err error
+	switch cmd.Command {
+	case commandSetPollingInterval:
+		err = e.SetPollingInterval(cmd.PollingInterval)
+	case commandPause:
+		err = e.Pause()
+	case commandResume:
+		err = e.Resume()
+	case commandBurst:
+		err = e.StartBurst(time.Duration(cmd.BurstInterval)*time.Millisecond, time.Duration(cmd.BurstDuration)*time.Second)
+	case commandMask:
+		err = e.MaskChannels(cmd.Channels)
+	case commandUnmask:
+		err = e.UnmaskChannels(cmd.Channels)
+	case commandTrigger:
+		err = e.Trigger()
+	case commandAcknowledge:
+		err = e.AcknowledgeAlarms(cmd.Channels)
+	case commandShelve:
+		err = e.ShelveAlarms(cmd.Channels, cmd.ShelveMinutes)
+	case commandUnshelve:
+		err = e.UnshelveAlarms(cmd.Channels)
+	case commandRefreshTags:
+		err = e.RefreshTags()
+	case commandSetProfile:
+		err = e.SetProfile(cmd.Profile)
+	case commandStatus:
+		// the status of the latest health check is only added to the result below
+	default:
+		err = fmt.Errorf("%w %q", ErrUnknownCommand, cmd.Command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, bursting := e.currentPollingInterval()
+	result := &CommandResult{
+		PollingInterval: int64(pollingInterval(e.getConfig()) / time.Second),
+		Recording:       atomic.LoadInt32(&e.recording) == 1,
+		Paused:          e.isPaused(),
+		Burst:           bursting,
+		Masked:          e.maskedChannels(),
+		Shelved:         e.shelvedChannels(),
+		Profile:         e.getConfig().Profile,
+	}
+	// status frames only reach Laniakea while recording, so the status command is how it's read while idle
+	if cmd.Command == commandStatus {
+		result.Status = e.getStatus()
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	frameChan := make(chan *proto.Frame, 1)
+	frameChan <- &proto.Frame{
+		Source:    e.frameSource(),
+		Type:      commandFrameType,
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   b,
+	}
+	close(frameChan)
+	return frameChan, nil
+}
+
+// SetPollingInterval changes the polling interval, in seconds, without interrupting the recording. It lasts until
+// the config file is reloaded
+func (e *FlukeDatasource) SetPollingInterval(seconds int64) error {
+	if seconds < cfg.MinPollingInterval || seconds > cfg.MaxPollingInterval {
+		return fmt.Errorf("polling interval must be between %d and %d seconds", cfg.MinPollingInterval, cfg.MaxPollingInterval)
+	}
+	e.configMu.Lock()
+	config := *e.config
+	config.PollingInterval = seconds
+	e.config = &config
+	e.configMu.Unlock()
+	// let the recording goroutine pick up the new polling interval
+	select {
+	case e.reloadChan <- struct{}{}:
+	default:
+	}
+	log.Printf("Polling interval set to %d seconds", seconds)
+	return nil
+}
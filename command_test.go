@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+// sendCommand sends the given command to the datasource, returning the command result
+func sendCommand(e *FlukeDatasource, cmd Command) (*CommandResult, error) {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := e.Command(&proto.Frame{Source: "test", Type: commandFrameType, Timestamp: time.Now().UnixMilli(), Payload: b})
+	if err != nil {
+		return nil, err
+	}
+	var result CommandResult
+	if err := json.Unmarshal((<-frames).Payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func TestRefreshTagsCommand(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1"}}
+	e := newTestDatasource(nil, daq)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	if _, err := sendCommand(e, Command{Command: commandRefreshTags}); !errors.Is(err, ErrRefreshWhileRecording) {
+		t.Fatalf("refresh_tags while recording returned %v, expected %v", err, ErrRefreshWhileRecording)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+	if _, err := sendCommand(e, Command{Command: commandRefreshTags}); err != nil {
+		t.Fatalf("refresh_tags: %v", err)
+	}
+	// the DAQs are reconnected on the next recording
+	if d := atomic.LoadInt32(&daq.closed); d != 1 {
+		t.Fatalf("DAQ closed %d times, expected 1", d)
+	}
+}
+
+func TestSetProfileCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fluke.yaml")
+	config := `Version: 2
+Simulate: true
+PollingInterval: 5
+DAQs:
+  - Name: "simulated"
+    FlukeTags:
+      0: "Scan"
+      1: {Tag: "TC_1", Type: "temperature"}
+Profiles:
+  bakeout:
+    PollingInterval: 30
+  leak-check:
+    PollingInterval: 1
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(profileEnv, "")
+	e := newTestDatasource(&cfg.Config{})
+	e.configPath = path
+	defer e.Stop()
+	result, err := sendCommand(e, Command{Command: commandSetProfile, Profile: "bakeout"})
+	if err != nil {
+		t.Fatalf("set_profile: %v", err)
+	}
+	if result.Profile != "bakeout" || result.PollingInterval != 30 {
+		t.Fatalf("profile %q polls every %ds, expected bakeout every 30s", result.Profile, result.PollingInterval)
+	}
+	if _, err := sendCommand(e, Command{Command: commandSetProfile, Profile: "unknown"}); !errors.Is(err, cfg.ErrUnknownProfile) {
+		t.Fatalf("set_profile to an unknown profile returned %v, expected %v", err, cfg.ErrUnknownProfile)
+	}
+	// the profile is kept for later reloads, and isn't changed by a failed switch
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if config := e.getConfig(); config.Profile != "bakeout" || config.PollingInterval != 30 {
+		t.Fatalf("profile %q polls every %ds once reloaded, expected bakeout every 30s", config.Profile, config.PollingInterval)
+	}
+	// the environment takes precedence over the profile
+	t.Setenv("FLUKE_POLLING_INTERVAL", "10")
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if interval := e.getConfig().PollingInterval; interval != 10 {
+		t.Fatalf("polling every %ds with FLUKE_POLLING_INTERVAL set, expected 10s", interval)
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	e := newTestDatasource(nil, &fakeDAQ{channels: []string{"TC_1"}})
+	defer e.Stop()
+	result, err := sendCommand(e, Command{Command: commandStatus})
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if result.Status == nil || result.Status.Recording {
+		t.Fatalf("status command returned %+v, expected the status of an idle plugin", result.Status)
+	}
+	// other commands leave the status out of their result
+	result, err = sendCommand(e, Command{Command: commandSetPollingInterval, PollingInterval: 10})
+	if err != nil {
+		t.Fatalf("set_polling_interval: %v", err)
+	}
+	if result.Status != nil {
+		t.Fatalf("set_polling_interval returned status %+v", result.Status)
+	}
+}
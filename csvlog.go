@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// csvLogger appends a CSV row per scan to log files on disk, independently of Laniakea and Influx. A new file is
+// started every day, when the file reaches its size limit and when recording is started. Every file starts with a
+// header row of the channels, which is repeated whenever the channels change
+type csvLogger struct {
+	dir      string
+	maxBytes int64
+	encoder  *payloadEncoder
+	file     *os.File
+	size     int64
+	day      string
+}
+
+func init() {
+	RegisterSink("csv", func(e *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.CSVLogDir == "" {
+			return nil, nil
+		}
+		return newCSVLogger(e.configRelativePath(config.CSVLogDir), config.CSVLogMaxMB*1024*1024)
+	})
+}
+
+// newCSVLogger returns a csvLogger writing to the given directory, creating it if needed
+func newCSVLogger(dir string, maxBytes int64) (*csvLogger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &csvLogger{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Write appends a row for the frame, starting a new file first if needed
+func (l *csvLogger) Write(frame *SinkFrame) error {
+	t := frame.Time
+	day := t.Format("2006-01-02")
+	if l.file != nil && (day != l.day || (l.maxBytes > 0 && l.size >= l.maxBytes)) {
+		if err := l.Close(); err != nil {
+			return err
+		}
+	}
+	if l.file == nil {
+		name := fmt.Sprintf("%s-%s.csv", pluginName, t.Format("20060102T150405.000"))
+		file, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		l.file = file
+		l.size = 0
+		l.day = day
+		l.encoder = newPayloadEncoder(cfg.PayloadEncodingCSV, false)
+	}
+	rows, err := l.encoder.encodeCSV(frame.Frame, frame.Channels, t)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		n, err := l.file.Write(row)
+		l.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush commits the current file to disk
+func (l *csvLogger) Flush() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+// Close closes the current file
+func (l *csvLogger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
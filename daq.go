@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// DAQ is a DAQ which can be scanned and read while recording. DAQConnection is the implementation talking to the
+// OPC server of the Fluke DAQ software
+type DAQ interface {
+	StartScanning() error
+	StopScanning() error
+	ReadItems(tick int64) []Reading
+	Close()
+}
+
+// DAQConnector connects to the DAQs of a config, in the order they're defined
+type DAQConnector func(config *cfg.Config) ([]DAQ, error)
+
+// Compile time check to ensure DAQConnection satisfies the DAQ interface
+var _ DAQ = (*DAQConnection)(nil)
+
+// connectionsOf returns the DAQs which are connections to an OPC server, or a stand in for one, as only those have
+// the tag maps, names and health checks needed for metadata, remapping and status frames
+func connectionsOf(daqs []DAQ) []*DAQConnection {
+	var conns []*DAQConnection
+	for _, daq := range daqs {
+		if conn, ok := daq.(*DAQConnection); ok {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// daqName returns the name of the DAQ at the given position for logs and metrics
+func daqName(daq DAQ, i int) string {
+	if conn, ok := daq.(*DAQConnection); ok {
+		return conn.Name
+	}
+	return fmt.Sprintf("DAQ %d", i)
+}
+
+// dialDAQs connects to the OPC servers of the DAQs of the config, or replays a recording in their place, injecting
+// faults into their reads if configured. It is the DAQConnector used unless another one is given
+func (e *FlukeDatasource) dialDAQs(config *cfg.Config) ([]DAQ, error) {
+	var (
+		conns []*DAQConnection
+		err   error
+	)
+	if config.Replay != nil {
+		conns, err = e.connectReplay(config)
+	} else {
+		conns, err = ConnectToDAQs(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	daqs := make([]DAQ, len(conns))
+	for i, conn := range conns {
+		if config.FaultInjection != nil {
+			conn.injectFaults(config.FaultInjection)
+		}
+		daqs[i] = conn
+	}
+	return daqs, nil
+}
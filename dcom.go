@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// dcomCredentials are the credentials DCOM connections to the OPC server of a DAQ are authenticated with. Without a
+// username, connections are made with the account running the plugin
+type dcomCredentials struct {
+	domain   string
+	username string
+	password string
+}
+
+// daqCredentials returns the DCOM credentials of the given DAQ
+func daqCredentials(daqCfg cfg.DAQConfig) dcomCredentials {
+	return dcomCredentials{domain: daqCfg.Domain, username: daqCfg.Username, password: daqCfg.Password}
+}
+
+// String returns the user the credentials are for, without the password
+func (c dcomCredentials) String() string {
+	if c.domain != "" {
+		return fmt.Sprintf(`%s\%s`, c.domain, c.username)
+	}
+	return c.username
+}
+
+// run calls fn with the DCOM connections it makes authenticated with the credentials
+func (c dcomCredentials) run(fn func() error) error {
+	if c.username == "" {
+		return fn()
+	}
+	return withDCOMCredentials(c, fn)
+}
@@ -0,0 +1,109 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	logon32LogonNewCredentials = 9
+	logon32ProviderWinNT50     = 3
+	rpcCAuthnLevelConnect      = 2
+	rpcCImpLevelImpersonate    = 3
+	eoacStaticCloaking         = 0x20
+	rpcETooLate                = 0x80010119
+)
+
+var (
+	modadvapi32                 = windows.NewLazySystemDLL("advapi32.dll")
+	modole32                    = windows.NewLazySystemDLL("ole32.dll")
+	procLogonUserW              = modadvapi32.NewProc("LogonUserW")
+	procImpersonateLoggedOnUser = modadvapi32.NewProc("ImpersonateLoggedOnUser")
+	procCoInitializeSecurity    = modole32.NewProc("CoInitializeSecurity")
+	dcomSecurityOnce            sync.Once
+	dcomSecurityErr             error
+)
+
+// initDCOMSecurity sets the COM security of the process so that proxies to remote servers are authenticated with
+// the identity of the thread creating them rather than that of the process. With static cloaking the identity is
+// kept by the proxy, so calls made later from other threads still use it. It only has an effect if called before
+// the process makes any other COM call which needs security, in which case the defaults are kept
+func initDCOMSecurity() error {
+	dcomSecurityOnce.Do(func() {
+		hr, _, _ := procCoInitializeSecurity.Call(
+			0,
+			^uintptr(0), // -1, let COM choose the authentication services
+			0,
+			0,
+			rpcCAuthnLevelConnect,
+			rpcCImpLevelImpersonate,
+			0,
+			eoacStaticCloaking,
+			0,
+		)
+		if hr != 0 && uint32(hr) != rpcETooLate {
+			dcomSecurityErr = fmt.Errorf("could not initialize DCOM security: %v", ole.NewError(hr))
+		}
+	})
+	return dcomSecurityErr
+}
+
+// withDCOMCredentials calls fn with its thread impersonating the given user for outbound connections only, so that
+// the DCOM connections it makes to remote OPC servers are authenticated with those credentials while local calls
+// keep the account running the plugin. The credentials are never exposed outside of the process and the sessions
+// of other programs are left alone
+func withDCOMCredentials(creds dcomCredentials, fn func() error) error {
+	username, err := windows.UTF16PtrFromString(creds.username)
+	if err != nil {
+		return err
+	}
+	domain, err := windows.UTF16PtrFromString(creds.domain)
+	if err != nil {
+		return err
+	}
+	password, err := windows.UTF16PtrFromString(creds.password)
+	if err != nil {
+		return err
+	}
+	// impersonation applies to the thread, so the goroutine has to stay on it until it's reverted
+	runtime.LockOSThread()
+	// COM has to be initialized on the thread before its security can be set. It's already initialized if this
+	// fails, which is all that matters here
+	_ = ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
+	if err := initDCOMSecurity(); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	var token windows.Token
+	r, _, callErr := procLogonUserW.Call(
+		uintptr(unsafe.Pointer(username)),
+		uintptr(unsafe.Pointer(domain)),
+		uintptr(unsafe.Pointer(password)),
+		logon32LogonNewCredentials,
+		logon32ProviderWinNT50,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	if r == 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not log on as %s: %v", creds, callErr)
+	}
+	defer token.Close()
+	if r, _, callErr = procImpersonateLoggedOnUser.Call(uintptr(token)); r == 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not impersonate %s: %v", creds, callErr)
+	}
+	err = fn()
+	if revertErr := windows.RevertToSelf(); revertErr != nil {
+		// the thread is left locked so that it's discarded with the goroutine instead of running others as the user
+		return fmt.Errorf("could not stop impersonating %s: %v", creds, revertErr)
+	}
+	runtime.UnlockOSThread()
+	return err
+}
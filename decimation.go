@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// decimator picks the frames of a recording which are forwarded to Laniakea, aggregating the channels of the scans
+// in between when configured
+type decimator struct {
+	config *cfg.Config
+	scans  int64
+	stats  map[string]*runningStats
+}
+
+// newDecimator returns a decimator for the configured decimation, or nil if every frame is to be forwarded
+func newDecimator(config *cfg.Config) *decimator {
+	if config.Decimation == nil || config.Decimation.Every <= 1 {
+		return nil
+	}
+	return &decimator{config: config, stats: make(map[string]*runningStats)}
+}
+
+// add adds the frame of a scan and returns the frame to forward once every Decimation.Every scans, or nil otherwise.
+// With an aggregate, the good numeric values of the forwarded frame are replaced by the aggregate of the scans since
+// the previous forwarded frame, rounded to the precision of the channel
+func (d *decimator) add(f *Frame, readings []Reading) *Frame {
+	if d == nil {
+		return f
+	}
+	d.scans++
+	aggregate := d.config.Decimation.Aggregate
+	if aggregate != "" {
+		for _, payload := range f.Data {
+			if payload.Bad {
+				continue
+			}
+			var v float64
+			switch value := payload.Value.(type) {
+			case float64:
+				v = value
+			case int64:
+				v = float64(value)
+			default:
+				continue
+			}
+			s, ok := d.stats[payload.Name]
+			if !ok {
+				s = &runningStats{}
+				d.stats[payload.Name] = s
+			}
+			s.add(v)
+		}
+	}
+	if d.scans < d.config.Decimation.Every {
+		return nil
+	}
+	d.scans = 0
+	if aggregate == "" {
+		return f
+	}
+	byName := readingsByName(readings)
+	out := *f
+	out.Data = make([]Payload, len(f.Data))
+	for i, payload := range f.Data {
+		if s, ok := d.stats[payload.Name]; ok && s.count > 0 && !payload.Bad {
+			var v float64
+			switch aggregate {
+			case cfg.AggregateMin:
+				v = s.min
+			case cfg.AggregateMax:
+				v = s.max
+			default:
+				v = s.mean
+			}
+			payload.Value = v
+			if places, ok := precision(d.config, byName[payload.Name]); ok {
+				payload.Value = roundValue(v, places)
+			}
+		}
+		out.Data[i] = payload
+	}
+	d.stats = make(map[string]*runningStats)
+	return &out
+}
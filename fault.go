@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+var (
+	alarmTypeFault                = "fault"
+	faultOverRange                = "over-range"
+	faultUnderRange               = "under-range"
+	faultNotConnected             = "not-connected"
+	faultDeviceFailure            = "device-failure"
+	faultSensorFailure            = "sensor-failure"
+	faultOutOfService             = "out-of-service"
+	opcQualityStatusMask    int16 = 0xC0
+	opcQualitySubstatusMask int16 = 0x3C
+	opcQualityBad           int16 = 0x00
+)
+
+// opcBadSubstatusFaults are the faults given by the substatus of bad OPC quality
+var opcBadSubstatusFaults = map[int16]string{
+	0x08: faultNotConnected,
+	0x0C: faultDeviceFailure,
+	0x10: faultSensorFailure,
+	0x1C: faultOutOfService,
+}
+
+// sensorFault returns the fault of a reading, or a blank string if it isn't faulted. An open thermocouple is
+// reported by the 2638A as +OVER, which the Fluke DAQ software reads as an over-range value
+func sensorFault(reading Reading) string {
+	if reading.Item.Quality&opcQualityStatusMask == opcQualityBad {
+		if fault, ok := opcBadSubstatusFaults[reading.Item.Quality&opcQualitySubstatusMask]; ok {
+			return fault
+		}
+	}
+	switch v, _ := payloadValue(reading.Item.Value); v := v.(type) {
+	case float64:
+		if v >= overRangeValue {
+			return faultOverRange
+		}
+		if v <= -overRangeValue {
+			return faultUnderRange
+		}
+	case string:
+		// some OPC servers pass the overload on as text
+		switch strings.ToUpper(strings.TrimSpace(v)) {
+		case "+OVER", "OVER":
+			return faultOverRange
+		case "-OVER":
+			return faultUnderRange
+		}
+	}
+	return ""
+}
+
+// faultDetector keeps track of the faulted channels over a recording
+type faultDetector struct {
+	faults map[string]string
+}
+
+// newFaultDetector returns a faultDetector with no faulted channels
+func newFaultDetector() *faultDetector {
+	return &faultDetector{faults: make(map[string]string)}
+}
+
+// check updates the channels with a scan and returns the fault alarms raised and cleared. Readings which failed
+// without a fault, e.g. because they timed out, leave their channel unchanged
+func (d *faultDetector) check(readings []Reading, t time.Time) []Alarm {
+	var alarms []Alarm
+	for _, reading := range readings {
+		fault := sensorFault(reading)
+		if fault == "" && !reading.Item.Good() {
+			continue
+		}
+		prev := d.faults[reading.Name]
+		if fault == prev {
+			continue
+		}
+		alarm := Alarm{Channel: reading.Name, Type: alarmTypeFault, Timestamp: t.UnixMilli()}
+		if v, ok := reading.Item.Value.(float64); ok && !isBadValue(v) {
+			alarm.Value = v
+		}
+		if prev != "" {
+			alarm.State, alarm.Fault = alarmStateCleared, prev
+			alarms = append(alarms, alarm)
+		}
+		if fault != "" {
+			alarm.State, alarm.Fault = alarmStateActive, fault
+			alarms = append(alarms, alarm)
+			d.faults[reading.Name] = fault
+		} else {
+			delete(d.faults, reading.Name)
+		}
+	}
+	return alarms
+}
+
+// fault returns the fault of a channel, or a blank string if it isn't faulted
+func (d *faultDetector) fault(channel string) string {
+	return d.faults[channel]
+}
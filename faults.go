@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	defaultInjectedDisconnect time.Duration = 10 * time.Second
+	ErrInjectedDisconnect                   = bg.Error("injected connection loss")
+)
+
+// faultyConnection injects faults into the reads of an OPC connection
+type faultyConnection struct {
+	opc.Connection
+	faults    *cfg.FaultInjection
+	rng       *rand.Rand
+	lostUntil time.Time
+	mu        sync.Mutex
+}
+
+// injectFaults wraps the OPC connections of the DAQ, including those of its read workers and the heartbeat, so that
+// faults are injected into their reads
+func (d *DAQConnection) injectFaults(faults *cfg.FaultInjection) {
+	wrap := func(c opc.Connection) opc.Connection {
+		return &faultyConnection{
+			Connection: c,
+			faults:     faults,
+			rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+	}
+	d.Connection = wrap(d.Connection)
+	for i, worker := range d.workers {
+		d.workers[i] = wrap(worker)
+	}
+	if d.health != nil {
+		d.health = wrap(d.health)
+	}
+	log.Printf("%s: injecting faults into OPC reads", d.Name)
+}
+
+// disconnected returns true while an injected connection loss lasts, and starts one at the disconnect rate
+func (c *faultyConnection) disconnected() bool {
+	now := time.Now()
+	if now.Before(c.lostUntil) {
+		return true
+	}
+	if c.rng.Float64() >= c.faults.DisconnectRate {
+		return false
+	}
+	lost := defaultInjectedDisconnect
+	if c.faults.DisconnectSeconds != 0 {
+		lost = time.Duration(c.faults.DisconnectSeconds) * time.Second
+	}
+	c.lostUntil = now.Add(lost)
+	log.Printf("Injecting connection loss for %s", lost)
+	return true
+}
+
+// inject returns the item with a fault injected at the configured rates
+func (c *faultyConnection) inject(item opc.Item) opc.Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.disconnected(), c.rng.Float64() < c.faults.DropRate:
+		return opc.Item{Quality: opc.OPCQualityBad, Timestamp: item.Timestamp}
+	case c.rng.Float64() < c.faults.NaNRate:
+		item.Value = math.NaN()
+	case c.rng.Float64() < c.faults.BadQualityRate:
+		item.Quality = opc.OPCQualityBad
+	}
+	return item
+}
+
+// Read implements the opc.Connection interface
+func (c *faultyConnection) Read() map[string]opc.Item {
+	items := c.Connection.Read()
+	for tag, item := range items {
+		items[tag] = c.inject(item)
+	}
+	return items
+}
+
+// ReadItem implements the opc.Connection interface
+func (c *faultyConnection) ReadItem(tag string) opc.Item {
+	return c.inject(c.Connection.ReadItem(tag))
+}
+
+// Write implements the opc.Connection interface. Writes fail while an injected connection loss lasts
+func (c *faultyConnection) Write(tag string, value interface{}) error {
+	c.mu.Lock()
+	lost := time.Now().Before(c.lostUntil)
+	c.mu.Unlock()
+	if lost {
+		return ErrInjectedDisconnect
+	}
+	return c.Connection.Write(tag, value)
+}
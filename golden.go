@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	goldenServerName        = "Golden"
+	goldenConfigFile        = "config.json"
+	goldenScansFile         = "scans.jsonl"
+	goldenFramesFile        = "frames.jsonl"
+	goldenDirLayout         = "20060102-150405"
+	goldenReplayInterval    = 10 * time.Millisecond
+	goldenStopTimeout       = 10 * time.Second
+	ErrEmptyGolden          = bg.Error("golden bundle has no scans or no frames")
+	ErrGoldenMismatch       = bg.Error("frame differs from the golden bundle")
+	ErrGoldenScansExhausted = bg.Error("golden bundle ran out of scans")
+	ErrGoldenValueType      = bg.Error("unsupported value type in golden bundle")
+	// goldenIntTypes are the integer types values can be read back as
+	goldenIntTypes = map[string]reflect.Type{
+		"int": reflect.TypeOf(int(0)), "int8": reflect.TypeOf(int8(0)), "int16": reflect.TypeOf(int16(0)),
+		"int32": reflect.TypeOf(int32(0)), "int64": reflect.TypeOf(int64(0)), "uint": reflect.TypeOf(uint(0)),
+		"uint8": reflect.TypeOf(uint8(0)), "uint16": reflect.TypeOf(uint16(0)), "uint32": reflect.TypeOf(uint32(0)),
+		"uint64": reflect.TypeOf(uint64(0)),
+	}
+)
+
+// goldenSession captures or replays the reads and data frames of a recording
+type goldenSession interface {
+	// scanned is given the readings of each DAQ read for a scan and returns the time of the scan
+	scanned(reads [][]Reading) time.Time
+	// sent is given every data frame sent
+	sent(frame *proto.Frame)
+}
+
+// goldenReading is a reading of a golden bundle. Values are kept as text along with their type so that they're read
+// back exactly, NaN and infinities included. Times are in nanoseconds, 0 being no time
+type goldenReading struct {
+	Index     int    `json:"index"`
+	Tag       string `json:"tag"`
+	Type      string `json:"type,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Quality   int16  `json:"quality"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// goldenScan is the readings of every DAQ read for a scan
+type goldenScan struct {
+	Time int64             `json:"time"`
+	DAQs [][]goldenReading `json:"daqs"`
+}
+
+// goldenFrame is a data frame of a golden bundle
+type goldenFrame struct {
+	Source    string `json:"source"`
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"`
+	Payload   []byte `json:"payload"`
+}
+
+// newGoldenReading returns the golden reading of a reading
+func newGoldenReading(reading Reading) goldenReading {
+	r := goldenReading{Index: reading.Index, Tag: reading.OPCTag, Quality: reading.Item.Quality}
+	if !reading.Item.Timestamp.IsZero() {
+		r.Timestamp = reading.Item.Timestamp.UnixNano()
+	}
+	switch v := reading.Item.Value.(type) {
+	case nil:
+	case float64:
+		r.Type, r.Value = "float64", strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		r.Type, r.Value = "float32", strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case string:
+		r.Type, r.Value = "string", v
+	default:
+		r.Type, r.Value = fmt.Sprintf("%T", v), fmt.Sprint(v)
+	}
+	return r
+}
+
+// item returns the OPC item of the reading as it was read
+func (r goldenReading) item() (opc.Item, error) {
+	item := opc.Item{Quality: r.Quality}
+	if r.Timestamp != 0 {
+		item.Timestamp = time.Unix(0, r.Timestamp)
+	}
+	var err error
+	switch r.Type {
+	case "":
+	case "float64":
+		item.Value, err = strconv.ParseFloat(r.Value, 64)
+	case "float32":
+		var v float64
+		v, err = strconv.ParseFloat(r.Value, 32)
+		item.Value = float32(v)
+	case "string":
+		item.Value = r.Value
+	case "bool":
+		item.Value, err = strconv.ParseBool(r.Value)
+	case "int", "int8", "int16", "int32", "int64":
+		var v int64
+		v, err = strconv.ParseInt(r.Value, 10, 64)
+		item.Value = reflect.ValueOf(v).Convert(goldenIntTypes[r.Type]).Interface()
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		var v uint64
+		v, err = strconv.ParseUint(r.Value, 10, 64)
+		item.Value = reflect.ValueOf(v).Convert(goldenIntTypes[r.Type]).Interface()
+	default:
+		err = fmt.Errorf("%w: %s", ErrGoldenValueType, r.Type)
+	}
+	return item, err
+}
+
+// goldenCapture writes the reads and data frames of a recording to a golden bundle, a directory holding the config
+// of the recording, its scans and its data frames
+type goldenCapture struct {
+	dir     string
+	files   []*os.File
+	writers []*bufio.Writer
+}
+
+// newGoldenCapture starts a golden bundle for a recording with the given config in a new directory of dir named after
+// the current time
+func newGoldenCapture(dir string, config *cfg.Config) (*goldenCapture, error) {
+	dir = filepath.Join(dir, time.Now().Format(goldenDirLayout))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(config.Golden(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, goldenConfigFile), b, 0644); err != nil {
+		return nil, err
+	}
+	c := &goldenCapture{dir: dir}
+	for _, name := range []string{goldenScansFile, goldenFramesFile} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		c.files = append(c.files, f)
+		c.writers = append(c.writers, bufio.NewWriter(f))
+	}
+	log.Printf("Capturing golden bundle in %s", dir)
+	return c, nil
+}
+
+// write writes a line of JSON to the given file of the bundle
+func (c *goldenCapture) write(w *bufio.Writer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		_, err = w.Write(append(b, '\n'))
+	}
+	if err != nil {
+		log.Printf("Could not write to golden bundle: %v", err)
+	}
+}
+
+// scanned implements the goldenSession interface. The scan is given the current time
+func (c *goldenCapture) scanned(reads [][]Reading) time.Time {
+	now := time.Now()
+	scan := goldenScan{Time: now.UnixNano(), DAQs: make([][]goldenReading, len(reads))}
+	for d, readings := range reads {
+		scan.DAQs[d] = make([]goldenReading, len(readings))
+		for i, reading := range readings {
+			scan.DAQs[d][i] = newGoldenReading(reading)
+		}
+	}
+	c.write(c.writers[0], scan)
+	return now
+}
+
+// sent implements the goldenSession interface
+func (c *goldenCapture) sent(frame *proto.Frame) {
+	c.write(c.writers[1], goldenFrame{Source: frame.Source, Type: frame.Type, Timestamp: frame.Timestamp, Payload: frame.Payload})
+}
+
+// close flushes and closes the files of the bundle
+func (c *goldenCapture) close() {
+	for i, f := range c.files {
+		if err := c.writers[i].Flush(); err != nil {
+			log.Printf("Could not write to golden bundle: %v", err)
+		}
+		f.Close()
+	}
+	log.Printf("Golden bundle written to %s", c.dir)
+}
+
+// goldenReplay replays the scans of a golden bundle through the recording and compares the data frames sent with
+// those of the bundle. It's done once every frame of the bundle has been compared or a frame differs
+type goldenReplay struct {
+	scans    []goldenScan
+	frames   []goldenFrame
+	position int
+	compared int
+	err      error
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// readGoldenLines decodes every line of a file of the bundle with the given function
+func readGoldenLines(path string, decode func(*json.Decoder) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		if err := decode(dec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// loadGoldenReplay reads the scans and frames of the golden bundle in the given directory
+func loadGoldenReplay(dir string) (*goldenReplay, error) {
+	r := &goldenReplay{done: make(chan struct{})}
+	err := readGoldenLines(filepath.Join(dir, goldenScansFile), func(dec *json.Decoder) error {
+		var scan goldenScan
+		err := dec.Decode(&scan)
+		if err == nil {
+			r.scans = append(r.scans, scan)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readGoldenLines(filepath.Join(dir, goldenFramesFile), func(dec *json.Decoder) error {
+		var frame goldenFrame
+		err := dec.Decode(&frame)
+		if err == nil {
+			r.frames = append(r.frames, frame)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.scans) == 0 || len(r.frames) == 0 {
+		return nil, ErrEmptyGolden
+	}
+	return r, nil
+}
+
+// finish ends the replay with the given result, unless it has already ended
+func (r *goldenReplay) finish(err error) {
+	select {
+	case <-r.done:
+	default:
+		r.err = err
+		close(r.done)
+	}
+}
+
+// result returns the result of the replay
+func (r *goldenReplay) result() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil && r.compared < len(r.frames) {
+		return fmt.Errorf("%w: only %d of %d frames were sent", ErrGoldenMismatch, r.compared, len(r.frames))
+	}
+	return r.err
+}
+
+// scanned implements the goldenSession interface. The scan is given the time it was captured at. Running out of
+// scans ends the replay, as every frame after that would differ
+func (r *goldenReplay) scanned([][]Reading) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.position >= len(r.scans) {
+		r.finish(fmt.Errorf("%w after %d of %d frames", ErrGoldenScansExhausted, r.compared, len(r.frames)))
+		return time.Unix(0, r.scans[len(r.scans)-1].Time)
+	}
+	t := time.Unix(0, r.scans[r.position].Time)
+	r.position++
+	return t
+}
+
+// sent implements the goldenSession interface
+func (r *goldenReplay) sent(frame *proto.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.compared >= len(r.frames) {
+		return
+	}
+	want := r.frames[r.compared]
+	r.compared++
+	switch {
+	case frame.Source != want.Source, frame.Type != want.Type, frame.Timestamp != want.Timestamp:
+		r.finish(fmt.Errorf("%w: frame %d is from %q of type %q at %d, expected %q of type %q at %d", ErrGoldenMismatch,
+			r.compared, frame.Source, frame.Type, frame.Timestamp, want.Source, want.Type, want.Timestamp))
+	case !bytes.Equal(frame.Payload, want.Payload):
+		r.finish(fmt.Errorf("%w: payload of frame %d differs from byte %d:\n got: %s\nwant: %s", ErrGoldenMismatch,
+			r.compared, firstDifference(frame.Payload, want.Payload), frame.Payload, want.Payload))
+	case r.compared == len(r.frames):
+		r.finish(nil)
+	}
+}
+
+// firstDifference returns the position of the first byte which differs between a and b
+func firstDifference(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// goldenConnection plays back the items read from a DAQ in a golden bundle in place of its OPC server. Items are
+// played back in the order they were read, tag by tag or scan by scan with group reads. Once they run out the
+// items read are empty
+type goldenConnection struct {
+	tags  []string
+	items map[string][]opc.Item
+	scans []map[string]opc.Item
+	mu    sync.Mutex
+}
+
+// connect is the DAQConnector of a replay, connecting to a stand in for every DAQ of the config which plays back
+// its readings. The readings were captured in engineering units so no conversions are applied to them
+func (r *goldenReplay) connect(config *cfg.Config) ([]DAQ, error) {
+	daqs := make([]DAQ, 0, len(config.DAQs))
+	for d, daqCfg := range config.DAQs {
+		c := &goldenConnection{tags: simulatedTags(daqCfg), items: make(map[string][]opc.Item)}
+		for _, scan := range r.scans {
+			if d >= len(scan.DAQs) {
+				continue
+			}
+			items := make(map[string]opc.Item, len(scan.DAQs[d]))
+			for _, reading := range scan.DAQs[d] {
+				item, err := reading.item()
+				if err != nil {
+					return nil, err
+				}
+				// the channels are given the tags they had so that they're sent with the same OPC tags
+				if reading.Index < len(c.tags) {
+					c.tags[reading.Index] = reading.Tag
+				}
+				c.items[reading.Tag] = append(c.items[reading.Tag], item)
+				items[reading.Tag] = item
+			}
+			c.scans = append(c.scans, items)
+		}
+		conn := newStandInConnection(daqCfg, config, goldenServerName, c, c.tags)
+		for i, tag := range conn.TagMap {
+			tag.scale, tag.offset, tag.calib, tag.linearize, tag.toUnit = 0, 0, nil, nil, nil
+			conn.TagMap[i] = tag
+		}
+		daqs = append(daqs, conn)
+	}
+	return daqs, nil
+}
+
+// Add implements the opc.Connection interface
+func (c *goldenConnection) Add(...string) error {
+	return nil
+}
+
+// Remove implements the opc.Connection interface
+func (c *goldenConnection) Remove(string) {}
+
+// Read implements the opc.Connection interface
+func (c *goldenConnection) Read() map[string]opc.Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.scans) == 0 {
+		return map[string]opc.Item{}
+	}
+	items := c.scans[0]
+	c.scans = c.scans[1:]
+	return items
+}
+
+// ReadItem implements the opc.Connection interface
+func (c *goldenConnection) ReadItem(tag string) opc.Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items := c.items[tag]
+	if len(items) == 0 {
+		return opc.Item{}
+	}
+	c.items[tag] = items[1:]
+	return items[0]
+}
+
+// Tags implements the opc.Connection interface
+func (c *goldenConnection) Tags() []string {
+	return c.tags
+}
+
+// Write implements the opc.Connection interface. Writes, like those to the scan control tag, are accepted and ignored
+func (c *goldenConnection) Write(string, interface{}) error {
+	return nil
+}
+
+// Close implements the opc.Connection interface
+func (c *goldenConnection) Close() {}
+
+// verifyGolden replays the golden bundle in the given directory through a recording and checks that every data frame
+// sent is identical to the one in the bundle, byte for byte
+func verifyGolden(dir string) error {
+	replay, err := loadGoldenReplay(dir)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, goldenConfigFile)
+	config, err := cfg.InitConfig(path)
+	if err != nil {
+		return err
+	}
+	// the warmup doesn't change the frames
+	config.WarmupDelay = 0
+	e := &FlukeDatasource{
+		stopChan:         make(chan struct{}),
+		statusChan:       make(chan *proto.Frame, 1),
+		reloadChan:       make(chan struct{}, 1),
+		ackChan:          make(chan struct{}, 1),
+		config:           config,
+		configPath:       path,
+		golden:           replay,
+		intervalOverride: goldenReplayInterval,
+	}
+	e.connectDAQs = replay.connect
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		return err
+	}
+	log.Printf("Replaying %d scans of %s", len(replay.scans), dir)
+	for {
+		select {
+		case _, ok := <-frames:
+			// the recording can stop by itself, e.g. with MaxFrames
+			if !ok {
+				return replay.result()
+			}
+		case <-replay.done:
+			_ = e.StopRecord()
+			timeout := time.After(goldenStopTimeout)
+			for frames != nil {
+				select {
+				case _, ok := <-frames:
+					if !ok {
+						frames = nil
+					}
+				case <-timeout:
+					frames = nil
+				}
+			}
+			return replay.result()
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var testGoldenBundle = filepath.Join("testdata", "golden", "simulated")
+
+func TestVerifyGolden(t *testing.T) {
+	if err := verifyGolden(testGoldenBundle); err != nil {
+		t.Fatalf("golden bundle wasn't reproduced: %v", err)
+	}
+}
+
+func TestVerifyGoldenMismatch(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{goldenConfigFile, goldenScansFile, goldenFramesFile} {
+		b, err := ioutil.ReadFile(filepath.Join(testGoldenBundle, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a different timestamp in the last frame is enough to tell it apart
+		if name == goldenFramesFile {
+			i := bytes.LastIndex(b, []byte(`"timestamp":`)) + len(`"timestamp":`)
+			b[i] = '2'
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := verifyGolden(dir); err == nil {
+		t.Fatal("a frame which differs from the golden bundle was verified")
+	}
+}
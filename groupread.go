@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/konimarti/opc"
+)
+
+// channelTags returns the OPC tags of the channels in the tag map in index order, leaving out the scan tag
+func channelTags(tagMap map[int]Tag) []string {
+	idxs := make([]int, 0, len(tagMap))
+	for i := range tagMap {
+		if i != 0 {
+			idxs = append(idxs, i)
+		}
+	}
+	sort.Ints(idxs)
+	tags := make([]string, len(idxs))
+	for pos, i := range idxs {
+		tags[pos] = tagMap[i].tag
+	}
+	return tags
+}
+
+// groupReader reads the items of several tags in a single read of the OPC server, so that they come from the same
+// scan. The items are returned in the order of the tags, those which couldn't be read without a value
+type groupReader interface {
+	SyncRead(tags []string) ([]opc.Item, error)
+	Close()
+}
+
+// connectionGroup stands in for the OPC group of a connection which has none, e.g. to a simulated or replayed DAQ,
+// by reading every tag of the connection at once
+type connectionGroup struct {
+	conn opc.Connection
+}
+
+// SyncRead implements the groupReader interface
+func (g connectionGroup) SyncRead(tags []string) ([]opc.Item, error) {
+	all := g.conn.Read()
+	items := make([]opc.Item, len(tags))
+	for pos, tag := range tags {
+		items[pos] = all[tag]
+	}
+	return items, nil
+}
+
+// Close does nothing since the connection is closed along with its DAQ
+func (g connectionGroup) Close() {}
+
+// readGroup reads the items due this tick in a single SyncRead of the OPC group of the DAQ, which only holds the
+// configured channels, so that the channels of a frame all come from the same scan of the server cache. The
+// readings are filled in place and true is returned if any item couldn't be read
+func (d *DAQConnection) readGroup(tagMap map[int]Tag, idxs []int, readings []Reading) (bool, error) {
+	// like the reads of a connection, a group read which timed out is waited on before the group is read again
+	if !atomic.CompareAndSwapInt32(&d.groupBusy, 0, 1) {
+		return true, ErrConnectionBusy
+	}
+	d.tagMu.Lock()
+	group, retired := d.group, d.retired
+	d.retired = nil
+	d.tagMu.Unlock()
+	// no read is left on the groups replaced by a reload
+	for _, g := range retired {
+		g.Close()
+	}
+	if group == nil {
+		group = connectionGroup{conn: d.Connection}
+	}
+	tags := make([]string, len(idxs))
+	for pos, i := range idxs {
+		tags[pos] = tagMap[i].tag
+	}
+	type syncRead struct {
+		items []opc.Item
+		err   error
+	}
+	readChan := make(chan syncRead, 1)
+	go func() {
+		defer atomic.StoreInt32(&d.groupBusy, 0)
+		items, err := group.SyncRead(tags)
+		readChan <- syncRead{items: items, err: err}
+	}()
+	var read syncRead
+	select {
+	case read = <-readChan:
+	case <-time.After(d.ReadTimeout):
+		return true, ErrReadTimeout
+	}
+	if read.err != nil {
+		return true, read.err
+	}
+	var failed bool
+	for pos, i := range idxs {
+		item := read.items[pos]
+		if item.Value == nil {
+			failed = true
+			continue
+		}
+		item.Value = tagMap[i].convert(item.Value)
+		readings[pos].Item = item
+	}
+	return failed, nil
+}
+
+// scanTime returns the time of the scan the readings come from, which is the latest OPC timestamp among them, along
+// with how far apart their timestamps are. The readings of a group read come from the same scan, so a spread means
+// that some channels weren't updated with it. The current time is returned if none of the readings have a timestamp
+func scanTime(readings []Reading) (time.Time, time.Duration) {
+	var latest, earliest time.Time
+	for _, reading := range readings {
+		t := reading.Item.Timestamp
+		if t.IsZero() {
+			continue
+		}
+		if t.After(latest) {
+			latest = t
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	if latest.IsZero() {
+		return time.Now(), 0
+	}
+	return latest, latest.Sub(earliest)
+}
+
+// regroup returns a new OPC group holding the channels of the given tag map, or nil if the DAQ has no group or its
+// channels are the same. It's made before the tag map is replaced so that group reads don't ask for tags which
+// aren't in the group
+func (d *DAQConnection) regroup(tagMap map[int]Tag, rate time.Duration) (groupReader, error) {
+	d.tagMu.RLock()
+	group, current := d.group, d.TagMap
+	d.tagMu.RUnlock()
+	tags := channelTags(tagMap)
+	if group == nil || reflect.DeepEqual(channelTags(current), tags) {
+		return nil, nil
+	}
+	var regrouped groupReader
+	err := d.credentials.run(func() (err error) {
+		regrouped, err = newOPCGroupReader(d.ServerName, d.Host, tags, rate)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not regroup the channels: %w", d.Name, err)
+	}
+	return regrouped, nil
+}
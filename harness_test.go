@@ -0,0 +1,142 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	sdk "github.com/SSSOC-CAN/laniakea-plugin-sdk"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	"github.com/hashicorp/go-plugin"
+)
+
+var (
+	harnessCycles   = 3
+	laniakeaVersion = "0.2.0"
+	harnessFrames   = 5
+	// harnessConfig simulates a DAQ with a channel of each kind
+	harnessConfig = `Simulate: true
+PollingInterval: 1
+WarmupDelay: 1
+DAQs:
+  - Name: "simulated"
+    FlukeTags:
+      0: "Scan"
+      1: {Tag: "TC_1", Type: "temperature", Kind: "temperature"}
+      2: {Tag: "Chamber", Type: "pressure", Kind: "pressure"}
+      3: {Tag: "Supply", Type: "voltage", Kind: "voltage"}
+`
+	harnessChannels = 3
+)
+
+// harness drives the plugin through the go-plugin gRPC layer, the way Laniakea does
+type harness struct {
+	t            *testing.T
+	datasource   sdk.Datasource
+	controller   sdk.Controller
+	lastSequence uint64
+}
+
+// newHarness serves the plugin in process against simulated DAQs and dispenses its datasource and controller
+func newHarness(t *testing.T) *harness {
+	path := filepath.Join(t.TempDir(), "fluke.yaml")
+	if err := ioutil.WriteFile(path, []byte(harnessConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := cfg.InitConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := &FlukeDatasource{
+		stopChan:         make(chan struct{}),
+		statusChan:       make(chan *proto.Frame, 1),
+		reloadChan:       make(chan struct{}, 1),
+		ackChan:          make(chan struct{}, 1),
+		config:           config,
+		configPath:       path,
+		intervalOverride: testInterval,
+	}
+	impl.SetPluginVersion(pluginVersion)
+	impl.SetVersionConstraints(laniVersionConstraint)
+	client, server := plugin.TestPluginGRPCConn(t, map[string]plugin.Plugin{
+		pluginName:           &sdk.DatasourcePlugin{Impl: impl},
+		controllerPluginName: &sdk.ControllerPlugin{Impl: impl},
+	})
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	h := &harness{t: t}
+	raw, err := client.Dispense(pluginName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.datasource = raw.(sdk.Datasource)
+	if raw, err = client.Dispense(controllerPluginName); err != nil {
+		t.Fatal(err)
+	}
+	h.controller = raw.(sdk.Controller)
+	return h
+}
+
+// command sends a command to the plugin through the controller
+func (h *harness) command(name string) {
+	h.t.Helper()
+	b, _ := json.Marshal(map[string]string{"command": name})
+	if _, err := h.controller.Command(&proto.Frame{Source: "fluke-harness", Type: commandFrameType, Timestamp: time.Now().UnixMilli(), Payload: b}); err != nil {
+		h.t.Fatalf("%s command: %v", name, err)
+	}
+}
+
+// check checks the next data frame of the recording
+func (h *harness) check(frames chan *proto.Frame) {
+	h.t.Helper()
+	f := nextDataFrame(h.t, frames)
+	if f.Sequence <= h.lastSequence {
+		h.t.Errorf("sequence %d follows %d", f.Sequence, h.lastSequence)
+	}
+	h.lastSequence = f.Sequence
+	if len(f.Data) != harnessChannels {
+		h.t.Errorf("frame %d has %d channels, expected %d", f.Sequence, len(f.Data), harnessChannels)
+	}
+}
+
+// cycle starts a recording, pauses and resumes it halfway through and stops it once enough data frames are checked
+func (h *harness) cycle() {
+	h.t.Helper()
+	frames, err := h.datasource.StartRecord()
+	if err != nil {
+		h.t.Fatalf("StartRecord: %v", err)
+	}
+	for i := 0; i < harnessFrames; i++ {
+		if i == harnessFrames/2 {
+			h.command(commandPause)
+			h.command(commandResume)
+		}
+		h.check(frames)
+	}
+	if err := h.datasource.StopRecord(); err != nil {
+		h.t.Fatalf("StopRecord: %v", err)
+	}
+	// the stream is closed once the recording has finished
+	waitClosed(h.t, frames)
+}
+
+func TestHarnessRecordingCycles(t *testing.T) {
+	h := newHarness(t)
+	if err := h.datasource.PushVersion(laniakeaVersion); err != nil {
+		t.Fatalf("PushVersion: %v", err)
+	}
+	for n := 1; n <= harnessCycles; n++ {
+		t.Logf("cycle %d", n)
+		h.cycle()
+	}
+	if err := h.datasource.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
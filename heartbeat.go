@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	"github.com/konimarti/opc"
+)
+
+var (
+	defaultHeartbeatInterval time.Duration = 10 * time.Second
+	statusFrameType                        = "application/x-fluke-status"
+)
+
+type Status struct {
+	Connected    bool            `json:"connected"`
+	Recording    bool            `json:"recording"`
+	Paused       bool            `json:"paused"`
+	LastGoodRead int64           `json:"last_good_read"`
+	DAQs         map[string]bool `json:"daqs"`
+	Influx       *InfluxStatus   `json:"influx,omitempty"`
+}
+
+// CheckHealth reads the scan control tag to confirm the OPC server is still responding. The tag is read on the
+// heartbeat's own connection without taking the busy slot of the channel reads, so that a health check never fails
+// the reads of a recording or fails itself because of them. Stand in connections have no heartbeat connection and
+// are read directly. A health check fails if the one before it is still waiting on its read
+func (d *DAQConnection) CheckHealth() bool {
+	if !atomic.CompareAndSwapInt32(&d.checking, 0, 1) {
+		return false
+	}
+	conn := d.health
+	if conn == nil {
+		conn = d.Connection
+	}
+	tag := d.GetTagMap()[0].tag
+	itemChan := make(chan opc.Item, 1)
+	go func() {
+		defer atomic.StoreInt32(&d.checking, 0)
+		itemChan <- conn.ReadItem(tag)
+	}()
+	select {
+	case item := <-itemChan:
+		return item.Good()
+	case <-time.After(d.ReadTimeout):
+		return false
+	}
+}
+
+// startHeartbeat starts the background goroutine which periodically checks the health of the DAQ connection
+func (e *FlukeDatasource) startHeartbeat() {
+	interval := defaultHeartbeatInterval
+	config := e.getConfig()
+	if config.HeartbeatInterval != 0 {
+		interval = time.Duration(config.HeartbeatInterval) * time.Second
+	}
+	e.Add(1)
+	go e.heartbeat(interval)
+}
+
+// heartbeat checks the DAQ connection on every tick and publishes the result as a status frame. The DAQs are
+// connected to on the first tick if no recording has done so yet, so that their health is known while idle. The
+// recording goroutine forwards status frames to Laniakea, otherwise the latest status is returned by the status
+// command and changes in health are logged
+func (e *FlukeDatasource) heartbeat(interval time.Duration) {
+	defer e.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastGoodRead time.Time
+	healthy := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			if e.getDAQs() == nil {
+				if err := e.tryConnect(); err != nil {
+					log.Printf("Could not connect to DAQ for the health check: %v", err)
+				}
+			}
+			conns := e.getConnections()
+			status := Status{
+				Connected: len(conns) > 0,
+				Recording: atomic.LoadInt32(&e.recording) == 1,
+				Paused:    e.isPaused(),
+				DAQs:      make(map[string]bool),
+				Influx:    e.influxStat.get(),
+			}
+			for _, conn := range conns {
+				ok := conn.CheckHealth()
+				wasOk, seen := healthy[conn.Name]
+				if !seen {
+					wasOk = true
+				}
+				if ok != wasOk {
+					if ok {
+						log.Printf("%s: DAQ connection restored", conn.Name)
+					} else {
+						log.Printf("%s: DAQ connection health check failed", conn.Name)
+					}
+				}
+				healthy[conn.Name] = ok
+				status.DAQs[conn.Name] = ok
+				status.Connected = status.Connected && ok
+			}
+			if status.Connected {
+				lastGoodRead = now
+			}
+			if !lastGoodRead.IsZero() {
+				status.LastGoodRead = lastGoodRead.UnixMilli()
+			}
+			e.setStatus(&status)
+			b, err := json.Marshal(&status)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			// only keep the most recent status frame if the previous one hasn't been forwarded yet
+			select {
+			case <-e.statusChan:
+			default:
+			}
+			e.statusChan <- &proto.Frame{
+				Source:    e.frameSource(),
+				Type:      statusFrameType,
+				Timestamp: now.UnixMilli(),
+				Payload:   b,
+			}
+		case <-e.stopChan:
+			return
+		}
+	}
+}
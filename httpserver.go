@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	httpShutdownTimeout time.Duration = 5 * time.Second
+)
+
+// setLatest keeps the most recent payload of each channel for the HTTP server
+func (e *FlukeDatasource) setLatest(data []Payload) {
+	e.latestMu.Lock()
+	defer e.latestMu.Unlock()
+	if e.latest == nil {
+		e.latest = make(map[string]Payload)
+	}
+	for _, payload := range data {
+		e.latest[payload.Name] = payload
+	}
+}
+
+// getLatest returns a copy of the most recent payload of each channel
+func (e *FlukeDatasource) getLatest() map[string]Payload {
+	e.latestMu.RLock()
+	defer e.latestMu.RUnlock()
+	latest := make(map[string]Payload, len(e.latest))
+	for name, payload := range e.latest {
+		latest[name] = payload
+	}
+	return latest
+}
+
+// setStatus keeps the status of the latest health check for the HTTP server
+func (e *FlukeDatasource) setStatus(status *Status) {
+	e.latestMu.Lock()
+	defer e.latestMu.Unlock()
+	e.status = status
+}
+
+// getStatus returns the status of the latest health check, or the recording state if there hasn't been one yet
+func (e *FlukeDatasource) getStatus() *Status {
+	e.latestMu.RLock()
+	defer e.latestMu.RUnlock()
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{
+		Connected: len(e.getConnections()) > 0,
+		Recording: atomic.LoadInt32(&e.recording) == 1,
+		Paused:    e.isPaused(),
+		Influx:    e.influxStat.get(),
+	}
+}
+
+// startHTTPServer starts serving the latest readings on /readings, the status on /status, the frames sent to
+// Laniakea as a WebSocket stream on /stream and Prometheus metrics on /metrics at the given address, so that
+// operators can check on the rig without Laniakea. The server is shut down when the plugin stops
+func (e *FlukeDatasource) startHTTPServer(addr string) {
+	e.streams = newFrameHub()
+	e.metrics = e.newMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/stream", e.streams)
+	mux.Handle("/metrics", e.metrics.handler())
+	mux.HandleFunc("/readings", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, e.getLatest())
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, e.getStatus())
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+	e.Add(1)
+	go func() {
+		defer e.Done()
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server stopped: %v", err)
+		}
+	}()
+	e.Add(1)
+	go func() {
+		defer e.Done()
+		<-e.stopChan
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+// writeJSON writes v as the JSON body of the response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// influxWriter writes readings to Influx as points in the measurement of their channel type, tagged with the
+// channel name, unit and the configured static tags, e.g. the rig. Points are written asynchronously so writes
+// don't hold up frames
+type influxWriter struct {
+	writeAPI api.WriteAPI
+	tags     map[string]string
+	queue    *influxQueue
+	export   *influxExport
+	agg      *influxAggregator
+	health   *influxHealth
+	// points are only written with the first frame of every interval if set, next being the start of the next one
+	interval time.Duration
+	next     time.Time
+}
+
+func init() {
+	RegisterSink("influx", openInfluxSink)
+}
+
+// openInfluxSink opens the Influx writer of a recording, or the line protocol export if InfluxExportDir is set
+func openInfluxSink(e *FlukeDatasource, config *cfg.Config) (Sink, error) {
+	if !config.Influx {
+		return nil, nil
+	}
+	var (
+		w   *influxWriter
+		err error
+	)
+	if config.InfluxExportDir != "" {
+		w, err = newInfluxExportWriter(config, e.configRelativePath(config.InfluxExportDir), e.influxStat)
+	} else {
+		var queueDir string
+		if config.InfluxQueueDir != "" {
+			queueDir = e.configRelativePath(config.InfluxQueueDir)
+		}
+		w, err = newInfluxWriter(e.client, config, queueDir, e.influxStat)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.interval = time.Duration(config.InfluxInterval) * time.Second
+	return w, nil
+}
+
+// newInfluxClient returns an Influx client for the configured server. Points are written in batches of
+// InfluxBatchSize, or every InfluxFlushPeriod milliseconds if that comes first
+func (e *FlukeDatasource) newInfluxClient(config *cfg.Config) (influx.Client, error) {
+	tlsConfig, err := e.influxTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	options := influx.DefaultOptions().SetTLSConfig(tlsConfig)
+	if config.InfluxBatchSize != 0 {
+		options.SetBatchSize(uint(config.InfluxBatchSize))
+	}
+	if config.InfluxFlushPeriod != 0 {
+		options.SetFlushInterval(uint(config.InfluxFlushPeriod))
+	}
+	return influx.NewClientWithOptions(config.InfluxURL, config.InfluxToken(), options), nil
+}
+
+// influxTLSConfig returns the TLS config of the Influx client. The server certificate is verified against
+// InfluxCAFile as well as the system roots, and InfluxCertFile and InfluxKeyFile are presented as the client
+// certificate if set
+func (e *FlukeDatasource) influxTLSConfig(config *cfg.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InfluxSkipTLS}
+	if config.InfluxSkipTLS {
+		log.Println("WARNING: InfluxSkipTLS is set, the certificate of the Influx server is NOT verified and the connection is open to man-in-the-middle attacks. Use InfluxCAFile instead to trust an internal CA")
+	}
+	if config.InfluxCAFile != "" {
+		pem, err := ioutil.ReadFile(e.configRelativePath(config.InfluxCAFile))
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidInfluxCA
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.InfluxCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.configRelativePath(config.InfluxCertFile), e.configRelativePath(config.InfluxKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newInfluxWriter returns an influxWriter for the configured bucket, creating the bucket if it doesn't exist. Points
+// which can't be written are queued in queueDir, if set
+func newInfluxWriter(client influx.Client, config *cfg.Config, queueDir string, health *influxHealth) (*influxWriter, error) {
+	// InfluxDB 1.x has no organizations or buckets, the database must already exist
+	if !config.InfluxV1() {
+		if err := ensureBucket(client, config.InfluxOrgName, config.InfluxBucketName); err != nil {
+			return nil, err
+		}
+		if config.InfluxAggBucket != "" {
+			if err := ensureBucket(client, config.InfluxOrgName, config.InfluxAggBucket); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w := &influxWriter{
+		writeAPI: client.WriteAPI(config.InfluxOrg(), config.InfluxBucket()),
+		tags:     config.InfluxTags,
+		health:   health,
+	}
+	w.writeAPI.SetWriteFailedCallback(w.onWriteFailed)
+	// aggregates are only retried in memory, the queue only holds batches of the main bucket
+	if config.InfluxAggBucket != "" {
+		aggAPI := client.WriteAPI(config.InfluxOrg(), config.InfluxAggBucket)
+		aggAPI.SetWriteFailedCallback(func(_ string, err http.Error, _ uint) bool {
+			health.failed(&err, retryable(err))
+			return true
+		})
+		w.agg = newInfluxAggregator(aggAPI, time.Duration(config.InfluxAggInterval)*time.Second)
+	}
+	// points which can't be written while Influx is unreachable are queued on disk rather than lost
+	if queueDir != "" {
+		var err error
+		w.queue, err = newInfluxQueue(
+			queueDir,
+			config.InfluxQueueMaxMB*1024*1024,
+			time.Duration(config.InfluxQueueMaxAge)*time.Hour,
+			client.WriteAPIBlocking(config.InfluxOrg(), config.InfluxBucket()),
+			health,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// onWriteFailed is the write failed callback of the Influx write API. Batches which can be retried are either
+// queued on disk or retried in memory
+func (w *influxWriter) onWriteFailed(batch string, err http.Error, retryAttempts uint) bool {
+	w.health.failed(&err, retryable(err))
+	if w.queue != nil {
+		return w.queue.onWriteFailed(batch, err, retryAttempts)
+	}
+	return true
+}
+
+// newInfluxExportWriter returns an influxWriter writing line protocol files to the given directory instead of Influx
+func newInfluxExportWriter(config *cfg.Config, dir string, health *influxHealth) (*influxWriter, error) {
+	export, err := newInfluxExport(
+		dir,
+		config.InfluxExportMaxMB*1024*1024,
+		time.Duration(config.InfluxExportEvery)*time.Minute,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &influxWriter{tags: config.InfluxTags, export: export, health: health}, nil
+}
+
+// ensureBucket creates the given bucket if it doesn't exist
+func ensureBucket(client influx.Client, orgName, bucketName string) error {
+	if orgName == "" || bucketName == "" {
+		return ErrBlankInfluxOrgOrBucket
+	}
+	orgAPI := client.OrganizationsAPI()
+	org, err := orgAPI.FindOrganizationByName(context.Background(), orgName)
+	if err != nil {
+		return ErrInvalidOrg
+	}
+	bucketAPI := client.BucketsAPI()
+	buckets, err := bucketAPI.FindBucketsByOrgName(context.Background(), orgName)
+	if err != nil {
+		return ErrInvalidOrg
+	}
+	var found bool
+	for _, bucket := range *buckets {
+		if bucket.Name == bucketName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		log.Printf("Creating %s bucket...", bucketName)
+		_, err := bucketAPI.CreateBucketWithName(context.Background(), org, bucketName, domain.RetentionRule{EverySeconds: 0})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readingTags returns the Influx tags of a reading. The unit is only included if the channel has one. The labels of
+// the channel take precedence over the static tags
+func (w *influxWriter) readingTags(reading Reading) map[string]string {
+	tags := make(map[string]string, len(w.tags)+len(reading.Labels)+2)
+	for k, v := range w.tags {
+		tags[k] = v
+	}
+	for k, v := range reading.Labels {
+		tags[k] = v
+	}
+	tags["id"] = reading.Name
+	if reading.Unit != "" {
+		tags["unit"] = reading.Unit
+	}
+	return tags
+}
+
+// write writes a reading, unless its channel has no type or is ignored
+func (w *influxWriter) write(reading Reading, value interface{}, sequence uint64, t time.Time) {
+	if reading.Type == "ignore" || reading.Type == "" {
+		return
+	}
+	tags := w.readingTags(reading)
+	p := influx.NewPoint(
+		reading.Type,
+		tags,
+		map[string]interface{}{
+			reading.Type: value,
+			// InfluxDB 1.x rejects unsigned fields
+			"sequence": int64(sequence),
+		},
+		t,
+	)
+	if w.export != nil {
+		if err := w.export.write(p); err != nil {
+			w.health.failed(err, false)
+			return
+		}
+		w.health.enqueued()
+		return
+	}
+	// write asynchronously
+	w.writeAPI.WritePoint(p)
+	w.health.enqueued()
+	if w.agg != nil {
+		switch v := value.(type) {
+		case float64:
+			w.agg.add(reading.Name, reading.Type, tags, v, t)
+		case int64:
+			w.agg.add(reading.Name, reading.Type, tags, float64(v), t)
+		}
+	}
+}
+
+// Write writes the readings of a frame which aren't bad. With an interval, only the first frame of every interval is
+// written. Intervals are aligned to multiples of the interval rather than started by the last frame written, so that
+// points don't drift later by the jitter of every frame
+func (w *influxWriter) Write(frame *SinkFrame) error {
+	if w.interval > 0 {
+		if frame.Time.Before(w.next) {
+			return nil
+		}
+		w.next = frame.Time.Truncate(w.interval).Add(w.interval)
+	}
+	for _, payload := range frame.Frame.Data {
+		if payload.Bad {
+			continue
+		}
+		if reading, ok := frame.Readings[payload.Name]; ok {
+			w.write(reading, payload.Value, frame.Frame.Sequence, frame.Time)
+		}
+	}
+	return nil
+}
+
+// Flush writes any points which haven't been written yet
+func (w *influxWriter) Flush() error {
+	if w.export == nil {
+		w.writeAPI.Flush()
+	}
+	return nil
+}
+
+// Close writes any points which haven't been written yet and stops replaying queued points
+func (w *influxWriter) Close() error {
+	if w.export != nil {
+		w.export.close()
+		return nil
+	}
+	if w.agg != nil {
+		w.agg.close()
+	}
+	w.writeAPI.Flush()
+	if w.queue != nil {
+		w.queue.close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+var (
+	defaultAggInterval time.Duration = 1 * time.Minute
+)
+
+// aggChannel is the running aggregate of a channel over the current window
+type aggChannel struct {
+	measurement string
+	tags        map[string]string
+	stats       runningStats
+}
+
+// influxAggregator writes the mean, min and max of every channel over fixed windows to a second, long retention
+// bucket, so that rigs don't need continuous queries on the server to downsample their data
+type influxAggregator struct {
+	writeAPI api.WriteAPI
+	interval time.Duration
+	window   time.Time
+	channels map[string]*aggChannel
+}
+
+// newInfluxAggregator returns an influxAggregator writing windows of the given interval with writeAPI
+func newInfluxAggregator(writeAPI api.WriteAPI, interval time.Duration) *influxAggregator {
+	if interval <= 0 {
+		interval = defaultAggInterval
+	}
+	return &influxAggregator{writeAPI: writeAPI, interval: interval, channels: make(map[string]*aggChannel)}
+}
+
+// add adds a numeric value of a channel, writing the previous window first if the value starts a new one
+func (a *influxAggregator) add(name, measurement string, tags map[string]string, v float64, t time.Time) {
+	window := t.Truncate(a.interval)
+	if window.After(a.window) {
+		a.flush()
+		a.window = window
+	}
+	c, ok := a.channels[name]
+	if !ok {
+		c = &aggChannel{measurement: measurement, tags: tags}
+		a.channels[name] = c
+	}
+	c.stats.add(v)
+}
+
+// flush writes the aggregates of the current window, timestamped with its start, and starts an empty one
+func (a *influxAggregator) flush() {
+	for _, c := range a.channels {
+		stats := c.stats.stats()
+		a.writeAPI.WritePoint(influx.NewPoint(
+			c.measurement,
+			c.tags,
+			map[string]interface{}{
+				"mean":  stats.Mean,
+				"min":   stats.Min,
+				"max":   stats.Max,
+				"count": stats.Count,
+			},
+			a.window,
+		))
+	}
+	a.channels = make(map[string]*aggChannel)
+}
+
+// close writes the last, partial window
+func (a *influxAggregator) close() {
+	a.flush()
+	a.writeAPI.Flush()
+}
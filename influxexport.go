@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+var (
+	defaultExportMaxMB  int64         = 100
+	defaultExportPeriod time.Duration = 1 * time.Hour
+	influxExportPartExt               = ".part"
+)
+
+// influxExport writes points to line protocol files instead of Influx so that they can be bulk imported later, e.g.
+// with influx write. The file being written has a .part suffix which is dropped once it's rotated, so that only
+// complete files are imported
+type influxExport struct {
+	dir      string
+	maxBytes int64
+	period   time.Duration
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	opened   time.Time
+}
+
+// newInfluxExport returns an influxExport writing files to the given directory, creating it if needed. Files are
+// rotated once they reach maxBytes or are older than period. Files left with a .part suffix by a previous recording
+// are completed first
+func newInfluxExport(dir string, maxBytes int64, period time.Duration) (*influxExport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	parts, err := filepath.Glob(filepath.Join(dir, "*"+influxQueueExt+influxExportPartExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		if err := os.Rename(part, strings.TrimSuffix(part, influxExportPartExt)); err != nil {
+			return nil, err
+		}
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultExportMaxMB * 1024 * 1024
+	}
+	if period <= 0 {
+		period = defaultExportPeriod
+	}
+	return &influxExport{dir: dir, maxBytes: maxBytes, period: period}, nil
+}
+
+// write appends a point to the current file, rotating it first if it's full or too old
+func (e *influxExport) write(p *write.Point) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	line := write.PointToLineProtocol(p, time.Nanosecond)
+	if e.file != nil && (e.size+int64(len(line)) > e.maxBytes || time.Since(e.opened) > e.period) {
+		if err := e.rotate(); err != nil {
+			log.Printf("Could not rotate Influx export file: %v", err)
+		}
+	}
+	if e.file == nil {
+		if err := e.open(); err != nil {
+			return err
+		}
+	}
+	n, err := e.file.WriteString(line)
+	e.size += int64(n)
+	return err
+}
+
+// open starts a new file named after the current time
+func (e *influxExport) open() error {
+	now := time.Now()
+	name := fmt.Sprintf("%s-%09d%s%s", now.UTC().Format("20060102T150405"), now.Nanosecond(), influxQueueExt, influxExportPartExt)
+	file, err := os.OpenFile(filepath.Join(e.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	e.file = file
+	e.size = 0
+	e.opened = now
+	return nil
+}
+
+// rotate completes the current file by closing and renaming it without the .part suffix
+func (e *influxExport) rotate() error {
+	name := e.file.Name()
+	err := e.file.Close()
+	e.file = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(name, strings.TrimSuffix(name, influxExportPartExt))
+}
+
+// close completes the current file
+func (e *influxExport) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file != nil {
+		if err := e.rotate(); err != nil {
+			log.Printf("Could not complete Influx export file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// InfluxStatus reports how writing to Influx is going in status frames, so that failing writes are noticed before
+// a test campaign ends
+type InfluxStatus struct {
+	Enqueued    uint64 `json:"points_enqueued"`
+	Failures    uint64 `json:"failures"`
+	Retries     uint64 `json:"retries"`
+	Queued      int    `json:"queued_batches"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+}
+
+// influxHealth counts the points enqueued for Influx and the failed writes since the plugin started
+type influxHealth struct {
+	mu     sync.Mutex
+	status InfluxStatus
+}
+
+// enqueued counts a point handed to the Influx write API or the export file. The write API writes points in the
+// background and only reports the batches which fail, so the points aren't known to be written yet
+func (h *influxHealth) enqueued() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.Enqueued++
+}
+
+// failed records a failed write, which is retried if retry is set
+func (h *influxHealth) failed(err error, retry bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if retry {
+		h.status.Retries++
+	} else {
+		h.status.Failures++
+	}
+	log.Printf("Writing to Influx failed: %v", err)
+	h.status.LastError = err.Error()
+	h.status.LastErrorAt = time.Now().UnixMilli()
+}
+
+// setQueued sets the number of batches queued on disk
+func (h *influxHealth) setQueued(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.Queued = n
+}
+
+// get returns a copy of the current status, or nil if Influx is disabled
+func (h *influxHealth) get() *InfluxStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := h.status
+	return &status
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+var (
+	influxReplayInterval time.Duration = 30 * time.Second
+	influxQueueExt                     = ".lp"
+)
+
+// influxQueue keeps the batches Influx couldn't be reached for on disk, one line protocol file per batch named after
+// the time it was queued, and replays them in order once Influx is reachable again. The oldest batches are dropped
+// to stay within the size and age limits
+type influxQueue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	writeAPI api.WriteAPIBlocking
+	health   *influxHealth
+	mu       sync.Mutex
+	seq      int64
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newInfluxQueue returns an influxQueue storing batches in the given directory, creating it if needed, and starts
+// replaying any batches already in it
+func newInfluxQueue(dir string, maxBytes int64, maxAge time.Duration, writeAPI api.WriteAPIBlocking, health *influxHealth) (*influxQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &influxQueue{dir: dir, maxBytes: maxBytes, maxAge: maxAge, writeAPI: writeAPI, health: health, stopChan: make(chan struct{})}
+	q.wg.Add(1)
+	go q.replayLoop()
+	return q, nil
+}
+
+// retryable returns true if a failed write is worth retrying later, i.e. Influx couldn't be reached or was
+// unavailable rather than rejecting the points
+func retryable(err http.Error) bool {
+	return err.StatusCode == 0 || err.StatusCode == 429 || err.StatusCode >= 500
+}
+
+// onWriteFailed is the write failed callback of the Influx write API. Batches which can be retried are queued on
+// disk rather than retried in memory, where they'd be lost when the plugin stops
+func (q *influxQueue) onWriteFailed(batch string, err http.Error, _ uint) bool {
+	if !retryable(err) {
+		return false
+	}
+	if err := q.push(batch); err != nil {
+		log.Printf("Could not queue points for Influx: %v", err)
+	}
+	return false
+}
+
+// push queues a batch
+func (q *influxQueue) push(batch string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), q.seq%1000000, influxQueueExt)
+	if err := ioutil.WriteFile(filepath.Join(q.dir, name), []byte(batch), 0644); err != nil {
+		return err
+	}
+	return q.trim()
+}
+
+// batches returns the queued batch files, oldest first
+func (q *influxQueue) batches() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	batches := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), influxQueueExt) {
+			batches = append(batches, info)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].Name() < batches[j].Name() })
+	return batches, nil
+}
+
+// queuedAt returns the time a batch was queued from its file name
+func queuedAt(name string) time.Time {
+	nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// trim drops the oldest batches which are past the age limit or don't fit within the size limit
+func (q *influxQueue) trim() error {
+	batches, err := q.batches()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, info := range batches {
+		total += info.Size()
+	}
+	var dropped int
+	for _, info := range batches {
+		tooOld := q.maxAge > 0 && time.Since(queuedAt(info.Name())) > q.maxAge
+		tooBig := q.maxBytes > 0 && total > q.maxBytes
+		if !tooOld && !tooBig {
+			break
+		}
+		if err := os.Remove(filepath.Join(q.dir, info.Name())); err != nil {
+			return err
+		}
+		total -= info.Size()
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d queued Influx batches to stay within the queue limits", dropped)
+	}
+	q.health.setQueued(len(batches) - dropped)
+	return nil
+}
+
+// replay writes the queued batches in order, stopping at the first one which fails so that order is kept
+func (q *influxQueue) replay() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.trim(); err != nil {
+		log.Println(err)
+	}
+	batches, err := q.batches()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for i, info := range batches {
+		path := filepath.Join(q.dir, info.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := q.writeAPI.WriteRecord(context.Background(), string(b)); err != nil {
+			if i > 0 {
+				log.Printf("Replayed %d queued Influx batches, %d left: %v", i, len(batches)-i, err)
+			}
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+			return
+		}
+		q.health.setQueued(len(batches) - i - 1)
+		if i == len(batches)-1 {
+			log.Printf("Replayed %d queued Influx batches", len(batches))
+		}
+	}
+}
+
+// replayLoop replays the queued batches periodically until the queue is closed
+func (q *influxQueue) replayLoop() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(influxReplayInterval)
+	defer ticker.Stop()
+	q.replay()
+	for {
+		select {
+		case <-ticker.C:
+			q.replay()
+		case <-q.stopChan:
+			return
+		}
+	}
+}
+
+// close stops replaying. Batches left in the queue are replayed during the next recording
+func (q *influxQueue) close() {
+	close(q.stopChan)
+	q.wg.Wait()
+}
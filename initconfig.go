@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	defaultChannelType = "temperature"
+)
+
+// channelName infers a channel name from an OPC tag by taking the last part of its dotted path
+func channelName(tag string) string {
+	parts := strings.Split(tag, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] != "" {
+			return parts[i]
+		}
+	}
+	return tag
+}
+
+// starterConfig returns a config for the given OPC server with every field set to its default and a channel for
+// each of the given tags. The first tag is the scan control tag and isn't recorded
+func starterConfig(serverName, host string, tags []string) *cfg.Config {
+	flukeTags := make(cfg.TagMap, len(tags))
+	config := &cfg.Config{
+		Version:           cfg.CurrentConfigVersion,
+		PollingInterval:   int64(defaultPolInterval.Seconds()),
+		HeartbeatInterval: int64(defaultHeartbeatInterval.Seconds()),
+		ReadTimeout:       defaultReadTimeout.Milliseconds(),
+		ReadWorkers:       1,
+		ConnectAttempts:   int64(defaultConnectAttempts),
+		ConnectRetryDelay: int64(defaultConnectRetryDelay.Seconds()),
+		TagCacheTTL:       int64(defaultTagCacheTTL.Seconds()),
+		AcquisitionMode:   cfg.AcquisitionModePoll,
+		DAQs:              []cfg.DAQConfig{{ServerName: serverName, Host: host, FlukeTags: flukeTags}},
+	}
+	// channel names must be unique so duplicates fall back to the full OPC tag name
+	counts := make(map[string]int, len(tags))
+	for _, tag := range tags {
+		counts[channelName(tag)]++
+	}
+	for i, tag := range tags {
+		name, tagType := channelName(tag), defaultChannelType
+		if counts[name] > 1 {
+			name = tag
+		}
+		if i == 0 {
+			tagType = "ignore"
+		}
+		flukeTags[i] = cfg.CfgTag{Tag: name, Type: tagType, OPCTag: tag}
+	}
+	return config
+}
+
+// writeStarterConfig browses the given OPC server and writes a starter config file with a channel for every tag
+// to path. An existing file is never overwritten
+func writeStarterConfig(path, serverName, host string) error {
+	tags, err := getTags(serverName, host, 0, true)
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(starterConfig(serverName, host, tags))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := fmt.Sprintf("# Generated from %s on %s. Review the channel names and types before recording\n", serverName, host)
+	if _, err := f.WriteString(header); err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return err
+}
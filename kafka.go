@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaSink publishes every frame sent to Laniakea to a Kafka topic. The frame type and source are sent as the
+// message headers and the source as its key, so that the frames of a plugin stay in order within their partition
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func init() {
+	RegisterSink("kafka", openKafkaSink)
+}
+
+// openKafkaSink opens the Kafka producer of a recording
+func openKafkaSink(e *FlukeDatasource, config *cfg.Config) (Sink, error) {
+	if config.Kafka == nil {
+		return nil, nil
+	}
+	return newKafkaSink(config.Kafka)
+}
+
+// newKafkaSink returns a kafkaSink for the configured brokers. Frames are published asynchronously so a slow or
+// unreachable broker doesn't hold up the recording
+func newKafkaSink(config *cfg.Kafka) (*kafkaSink, error) {
+	transport := &kafka.Transport{}
+	if config.TLS {
+		transport.TLS = &tls.Config{}
+	}
+	if config.SASLMechanism != "" {
+		mechanism, err := kafkaSASL(config)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	return &kafkaSink{writer: &kafka.Writer{
+		Addr:      kafka.TCP(config.Brokers...),
+		Topic:     config.Topic,
+		Balancer:  &kafka.Hash{},
+		Async:     true,
+		Transport: transport,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				log.Printf("Could not publish %d frames to Kafka: %v", len(messages), err)
+			}
+		},
+	}}, nil
+}
+
+// kafkaSASL returns the configured SASL mechanism
+func kafkaSASL(config *cfg.Kafka) (sasl.Mechanism, error) {
+	switch config.SASLMechanism {
+	case cfg.KafkaSASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.Username, config.Password)
+	case cfg.KafkaSASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.Username, config.Password)
+	default:
+		return plain.Mechanism{Username: config.Username, Password: config.Password}, nil
+	}
+}
+
+// Publish publishes a frame
+func (s *kafkaSink) Publish(frame *proto.Frame) {
+	err := s.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(frame.Source),
+		Value: frame.Payload,
+		Headers: []kafka.Header{
+			{Key: "type", Value: []byte(frame.Type)},
+			{Key: "source", Value: []byte(frame.Source)},
+		},
+		Time: time.UnixMilli(frame.Timestamp),
+	})
+	if err != nil {
+		log.Printf("Could not publish frame to Kafka: %v", err)
+	}
+}
+
+// Write does nothing since data frames are published along with every other frame
+func (s *kafkaSink) Write(*SinkFrame) error {
+	return nil
+}
+
+// Flush does nothing since frames are published as they're sent
+func (s *kafkaSink) Flush() error {
+	return nil
+}
+
+// Close publishes the frames still pending
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,82 +21,370 @@ import (
 	bg "github.com/SSSOCPaulCote/blunderguard"
 	"github.com/hashicorp/go-plugin"
 	influx "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
-	"github.com/influxdata/influxdb-client-go/v2/domain"
 	"github.com/konimarti/opc"
 )
 
 type Tag struct {
-	name    string
-	tag     string
-	tagType string
+	name      string
+	tag       string
+	tagType   string
+	pollEvery int64
+	unit      string
+	scale     float64
+	offset    float64
+	kind      string
+	labels    map[string]string
+	calib     *cfg.Calibration
+	toUnit    func(float64) float64
+	sensor    string
+	linearize func(float64) float64
 }
 
 var (
 	pluginName                               = "fluke-plugin"
+	controllerPluginName                     = "fluke-plugin-controller"
 	pluginVersion                            = "1.0.0"
 	laniVersionConstraint                    = ">= 0.2.0"
-	flukeOPCServerName                       = "Fluke.DAQ.OPC"
-	flukeOPCServerHost                       = "localhost"
+	profileEnv                               = "FLUKE_PROFILE"
 	defaultPolInterval         time.Duration = 5 * time.Second
+	defaultWarmupDelay         time.Duration = 1 * time.Second
+	goodReadRetryInterval      time.Duration = 1 * time.Second
+	defaultReadTimeout         time.Duration = 2 * time.Second
+	defaultConnectAttempts                   = 3
+	defaultConnectRetryDelay   time.Duration = 5 * time.Second
 	ErrAlreadyRecording                      = bg.Error("already recording")
 	ErrAlreadyStoppedRecording               = bg.Error("already stopped recording")
 	ErrBlankInfluxOrgOrBucket                = bg.Error("influx organization or bucket cannot be blank")
 	ErrInvalidOrg                            = bg.Error("invalid influx organization")
 	ErrInvalidBucket                         = bg.Error("invalid influx bucket")
+	ErrInvalidInfluxCA                       = bg.Error("no certificates found in the influx CA file")
+	ErrReadTimeout                           = bg.Error("timed out reading OPC item")
+	ErrConnectionBusy                        = bg.Error("OPC connection still waiting on a timed out read")
+	ErrRefreshWhileRecording                 = bg.Error("cannot refresh tags while recording")
+	ErrScanTagNotFound                       = bg.Error("scan control tag not found on OPC server")
+	ErrInvalidTagIndex                       = bg.Error("invalid tag indices")
+	ErrTagsShifted                           = bg.Error("channels matched by index now read other OPC tags")
+	ErrReloadWhileRecording                  = bg.Error("cannot change DAQ connection settings while recording")
+	ErrNotRecording                          = bg.Error("not recording")
+	ErrAlreadyPaused                         = bg.Error("recording already paused")
+	ErrNotPaused                             = bg.Error("recording not paused")
 )
 
 type DAQConnection struct {
 	opc.Connection
-	Tags   []string
-	TagMap map[int]Tag
+	Name        string
+	ServerName  string
+	Host        string
+	Tags        []string
+	TagMap      map[int]Tag
+	ReadTimeout time.Duration
+	GroupRead   bool
+	credentials dcomCredentials
+	workers     []opc.Connection
+	group       groupReader    // only holds the configured channels, used with GroupRead
+	retired     []groupReader  // groups replaced by a reload, closed by the next group read
+	groupBusy   int32          // used atomically, set while a group read is waiting on the server
+	health      opc.Connection // only holds the scan control tag, used by the heartbeat
+	checking    int32          // used atomically, set while a health check is waiting on a read
+	standIn     bool           // the connection stands in for the OPC server, e.g. when simulated or replayed
+	busy        map[opc.Connection]bool
+	busyMu      sync.Mutex
+	tagMu       sync.RWMutex
+	remapping   int32 // used atomically
+	lastRemap   time.Time
+	missingChan chan []string
+
+	// set while the channels are subscribed to, along with the items it pushed since they were last read by OPC tag
+	subscription opcSubscription
+	changes      map[string]opc.Item
+	changesMu    sync.Mutex
 }
 
-// GetAllTags returns a slice of all detected tags
-func GetAllTags() ([]string, error) {
-	b, err := opc.CreateBrowser(
-		flukeOPCServerName,
-		[]string{flukeOPCServerHost},
-	)
-	if err != nil {
-		return []string{}, err
+// validateTagIndices returns an error listing every configured tag index which is out of range of the browsed tags.
+// Channels which are given an OPC tag name aren't mapped by index and so aren't checked
+func validateTagIndices(tags []string, cfgTagMap map[int]cfg.CfgTag) error {
+	var idxs []int
+	for i, cfgTag := range cfgTagMap {
+		if cfgTag.OPCTag == "" && (i < 0 || i >= len(tags)) {
+			idxs = append(idxs, i)
+		}
 	}
-	return opc.CollectTags(b), nil
+	if len(idxs) == 0 {
+		return nil
+	}
+	sort.Ints(idxs)
+	invalid := make([]string, 0, len(idxs))
+	for _, i := range idxs {
+		invalid = append(invalid, fmt.Sprintf("%d (%s)", i, cfgTagMap[i].Tag))
+	}
+	return fmt.Errorf("%w: %s. %d tags were found on the OPC server", ErrInvalidTagIndex, strings.Join(invalid, ", "), len(tags))
 }
 
-// createTagMap takes the tag map given in the config file and creates a proper tag map from it
-func createTagMap(tags []string, cfgTagMap map[int]cfg.CfgTag) map[int]Tag {
+// createTagMap takes the tag map given in the config file and creates a proper tag map from it. Channels are matched
+// by their OPC tag name if one is given, otherwise by their index in the browsed tags. The names of the channels which
+// could not be found on the OPC server are also returned
+func createTagMap(tags []string, cfgTagMap map[int]cfg.CfgTag) (map[int]Tag, []string) {
+	browsed := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		browsed[tag] = true
+	}
+	matched := matchTagPatterns(tags, cfgTagMap)
 	tagMap := make(map[int]Tag)
+	var missing []string
 	for i, cfgTag := range cfgTagMap {
-		tagMap[i] = Tag{name: cfgTag.Tag, tag: tags[i], tagType: cfgTag.Type}
+		tag := cfgTag.OPCTag
+		if cfg.IsTagPattern(tag) {
+			tag = matched[i]
+		} else if tag == "" {
+			tag = tags[i]
+		}
+		if !browsed[tag] {
+			missing = append(missing, cfgTag.Tag)
+			continue
+		}
+		unit := cfgTag.MeasuredUnit()
+		// the conversion was checked when the config was validated
+		toUnit, err := cfg.UnitConversion(unit, cfgTag.ConvertTo)
+		if err == nil {
+			unit = cfgTag.ConvertTo
+		}
+		// thermocouples are read as EMF but sent as temperatures once compensated
+		// and are linearized by the cold junction compensation
+		var linearize func(float64) float64
+		if cfgTag.Thermocouple != nil {
+			unit = "degC"
+		} else {
+			linearize = sensorLinearization(cfgTag.Sensor)
+		}
+		tagMap[i] = Tag{
+			name:      cfgTag.Tag,
+			tag:       tag,
+			tagType:   cfgTag.Type,
+			pollEvery: cfgTag.PollEvery,
+			unit:      unit,
+			scale:     cfgTag.Scale,
+			offset:    cfgTag.Offset,
+			kind:      cfgTag.Kind,
+			labels:    cfgTag.Labels,
+			calib:     cfgTag.Calibration,
+			toUnit:    toUnit,
+			sensor:    cfgTag.Sensor,
+			linearize: linearize,
+		}
 	}
-	return tagMap
+	sort.Strings(missing)
+	return tagMap, missing
 }
 
-// ConnectToDAQ establishes a connection with the OPC server of the Fluke DAQ software and the FMTD
-func ConnectToDAQ(cfgTags map[int]cfg.CfgTag) (*DAQConnection, error) {
-	tags, err := GetAllTags()
+// matchTagPatterns assigns the browsed tags matching each wildcard OPC tag pattern, in browse order, to the channels
+// given that pattern in index order. Channels left over once the matching tags run out aren't assigned a tag
+func matchTagPatterns(tags []string, cfgTagMap map[int]cfg.CfgTag) map[int]string {
+	patterns := make(map[string][]int)
+	for i, cfgTag := range cfgTagMap {
+		if cfg.IsTagPattern(cfgTag.OPCTag) {
+			patterns[cfgTag.OPCTag] = append(patterns[cfgTag.OPCTag], i)
+		}
+	}
+	matched := make(map[int]string)
+	for pattern, idxs := range patterns {
+		sort.Ints(idxs)
+		j := 0
+		for _, tag := range tags {
+			if j == len(idxs) {
+				break
+			}
+			if ok, _ := path.Match(pattern, tag); ok {
+				matched[idxs[j]] = tag
+				j++
+			}
+		}
+	}
+	return matched
+}
+
+// connectToServer browses the given OPC server, using the tag cache when possible, and connects to it
+func connectToServer(serverName, host string, config *cfg.Config) (opc.Connection, []string, error) {
+	tags, err := getTags(serverName, host, tagCacheTTL(config), false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	c, err := newOPCConnection(serverName, host, tags)
+	if err == nil {
+		return c, tags, nil
+	}
+	// the cached tags may be out of date so browse the server again before giving up
+	tags, err = getTags(serverName, host, tagCacheTTL(config), true)
+	if err != nil {
+		return nil, nil, err
 	}
-	c, err := opc.NewConnection(
-		flukeOPCServerName,
-		[]string{flukeOPCServerHost},
-		tags,
+	c, err = newOPCConnection(serverName, host, tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, tags, nil
+}
+
+// readTimeout returns the configured OPC item read timeout
+func readTimeout(config *cfg.Config) time.Duration {
+	if config.ReadTimeout != 0 {
+		return time.Duration(config.ReadTimeout) * time.Millisecond
+	}
+	return defaultReadTimeout
+}
+
+// readWorkers returns the configured number of concurrent OPC read workers per DAQ
+func readWorkers(config *cfg.Config) int {
+	if config.ReadWorkers > 1 {
+		return int(config.ReadWorkers)
+	}
+	return 1
+}
+
+// ConnectToDAQ establishes a connection with the OPC server of the Fluke DAQ software and the FMTD
+func ConnectToDAQ(daqCfg cfg.DAQConfig, config *cfg.Config) (*DAQConnection, error) {
+	if config.Simulate {
+		return connectSimulated(daqCfg, config)
+	}
+	serverNames, host := daqCfg.Server()
+	name := daqCfg.Name
+	if name == "" {
+		name = daqCfg.DefaultName()
+	}
+	// different versions of the Fluke DAQ software register different ProgIDs so try each one in order
+	var (
+		c          opc.Connection
+		tags       []string
+		serverName string
+		workers    []opc.Connection
+		group      groupReader
+		health     opc.Connection
 	)
+	credentials := daqCredentials(daqCfg)
+	err := credentials.run(func() (err error) {
+		for _, serverName = range serverNames {
+			c, tags, err = connectToServer(serverName, host, config)
+			if err == nil {
+				break
+			}
+			log.Printf("%s: could not connect to %s: %v", name, serverName, err)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: connected to %s on %s", name, serverName, host)
+		// the connections made so far are closed if any of the others can't be made
+		defer func() {
+			if err != nil {
+				c.Close()
+				for _, other := range append([]opc.Connection{health}, workers...) {
+					if other != nil {
+						other.Close()
+					}
+				}
+				if group != nil {
+					group.Close()
+				}
+			}
+		}()
+		if err := validateTagIndices(tags, daqCfg.FlukeTags); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		tagMap, _ := createTagMap(tags, daqCfg.FlukeTags)
+		if err := checkTagShifts(serverName, host, tagMap, daqCfg.FlukeTags); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		// the heartbeat reads the scan control tag on its own connection so that it neither waits on nor holds up
+		// the reads of the channels
+		if scanTag, ok := tagMap[0]; ok {
+			if health, err = newOPCConnection(serverName, host, []string{scanTag.tag}); err != nil {
+				return err
+			}
+		}
+		// group reads go through an OPC group of their own holding only the configured channels, whose cache the
+		// server updates every polling interval
+		if config.GroupRead {
+			group, err = newOPCGroupReader(serverName, host, channelTags(tagMap), pollingInterval(config))
+			return err
+		}
+		// every additional read worker gets its own connection since reads on a single connection are serialized
+		for w := 1; w < readWorkers(config); w++ {
+			wc, err := newOPCConnection(serverName, host, tags)
+			if err != nil {
+				return err
+			}
+			workers = append(workers, wc)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &DAQConnection{
-		Connection: c,
-		Tags:       tags,
-		TagMap:     createTagMap(tags, cfgTags),
-	}, nil
+	tagMap, missing := createTagMap(tags, daqCfg.FlukeTags)
+	conn := &DAQConnection{
+		Connection:  c,
+		Name:        name,
+		ServerName:  serverName,
+		Host:        host,
+		Tags:        tags,
+		TagMap:      tagMap,
+		ReadTimeout: readTimeout(config),
+		GroupRead:   config.GroupRead,
+		credentials: credentials,
+		workers:     workers,
+		group:       group,
+		health:      health,
+		missingChan: make(chan []string, 1),
+	}
+	if len(missing) > 0 {
+		log.Printf("%s: channels not found on OPC server: %s", name, strings.Join(missing, ", "))
+		conn.missingChan <- missing
+	}
+	return conn, nil
+}
+
+// ConnectToDAQs establishes a connection with every DAQ defined in the config
+func ConnectToDAQs(config *cfg.Config) ([]*DAQConnection, error) {
+	conns := make([]*DAQConnection, 0, len(config.DAQs))
+	for _, daqCfg := range config.DAQs {
+		conn, err := ConnectToDAQ(daqCfg, config)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// Close closes the connection to the OPC server along with those of the read workers and the heartbeat
+func (d *DAQConnection) Close() {
+	d.Connection.Close()
+	for _, worker := range d.workers {
+		worker.Close()
+	}
+	if d.group != nil {
+		d.group.Close()
+	}
+	for _, g := range d.retired {
+		g.Close()
+	}
+	if d.health != nil {
+		d.health.Close()
+	}
+}
+
+// GetTagMap returns the current TagMap, which can be replaced if channels go missing while recording
+func (d *DAQConnection) GetTagMap() map[int]Tag {
+	d.tagMu.RLock()
+	defer d.tagMu.RUnlock()
+	return d.TagMap
 }
 
 // StartScanning starts the scanning process on the DAQ
 func (d *DAQConnection) StartScanning() error {
-	err := d.Write(d.TagMap[0].tag, true)
+	err := d.writeScanTag(true)
 	if err != nil {
 		return err
 	}
@@ -100,7 +393,7 @@ func (d *DAQConnection) StartScanning() error {
 
 // StopScanning stops the scanning process on the DAQ
 func (d *DAQConnection) StopScanning() error {
-	err := d.Write(d.TagMap[0].tag, false)
+	err := d.writeScanTag(false)
 	if err != nil {
 		return err
 	}
@@ -109,63 +402,265 @@ func (d *DAQConnection) StopScanning() error {
 
 // GetTagMapNames returns a slice of all the TagMap names
 func (d *DAQConnection) GetTagMapNames() []string {
-	idxs := make([]int, 0, len(d.TagMap))
-	for idx := range d.TagMap {
+	tagMap := d.GetTagMap()
+	idxs := make([]int, 0, len(tagMap))
+	for idx := range tagMap {
 		idxs = append(idxs, idx)
 	}
 	sort.Ints(idxs)
-	names := make([]string, 0, len(idxs)-1)
+	names := make([]string, 0, len(idxs))
 	for _, i := range idxs {
 		if i != 0 {
-			names = append(names, d.TagMap[i].name)
+			names = append(names, tagMap[i].name)
 		}
 	}
 	return names
 }
 
 type Reading struct {
-	Item opc.Item
-	Name string
-	Type string
+	Item   opc.Item
+	Name   string
+	Type   string
+	Unit   string
+	Kind   string
+	Index  int
+	OPCTag string
+	Labels map[string]string
 }
 
-// ReadItems returns a slice of all readings
-func (d *DAQConnection) ReadItems() []Reading {
-	idxs := make([]int, 0, len(d.TagMap))
-	for idx := range d.TagMap {
-		idxs = append(idxs, idx)
+// beginRead marks the given connection as reading, returning false if it's still waiting on an earlier read
+func (d *DAQConnection) beginRead(conn opc.Connection) bool {
+	d.busyMu.Lock()
+	defer d.busyMu.Unlock()
+	if d.busy[conn] {
+		return false
+	}
+	if d.busy == nil {
+		d.busy = make(map[opc.Connection]bool)
+	}
+	d.busy[conn] = true
+	return true
+}
+
+// endRead marks the given connection as free to read again
+func (d *DAQConnection) endRead(conn opc.Connection) {
+	d.busyMu.Lock()
+	defer d.busyMu.Unlock()
+	delete(d.busy, conn)
+}
+
+// isBusy returns true if the given connection is still waiting on a read
+func (d *DAQConnection) isBusy(conn opc.Connection) bool {
+	d.busyMu.Lock()
+	defer d.busyMu.Unlock()
+	return d.busy[conn]
+}
+
+// readItem reads a single OPC item using the given connection, giving up once the read timeout has elapsed. The
+// connection is left busy until a read which timed out returns, since reads on a connection are serialized and any
+// other read would only queue up behind it
+func (d *DAQConnection) readItem(conn opc.Connection, tag string) (opc.Item, error) {
+	if !d.beginRead(conn) {
+		return opc.Item{}, ErrConnectionBusy
+	}
+	itemChan := make(chan opc.Item, 1)
+	go func() {
+		defer d.endRead(conn)
+		itemChan <- conn.ReadItem(tag)
+	}()
+	select {
+	case item := <-itemChan:
+		return item, nil
+	case <-time.After(d.ReadTimeout):
+		return opc.Item{}, ErrReadTimeout
+	}
+}
+
+// convert applies the scale and offset of the tag to a raw OPC value to get it in engineering units, followed by the
+// linearization of its sensor, the calibration of the channel and the conversion to the unit it's sent in. A scale
+// of 0 is treated as unset. Integers become floats once converted and non numeric values are returned unchanged
+func (t Tag) convert(value interface{}) interface{} {
+	if t.scale == 0 && t.offset == 0 {
+		if v, ok := payloadValue(value); ok && (t.calib != nil || t.linearize != nil || t.toUnit != nil) {
+			return t.convertUnit(t.calibrate(t.linearizeValue(v)))
+		}
+		return value
+	}
+	scale := t.scale
+	if scale == 0 {
+		scale = 1
+	}
+	v, _ := payloadValue(value)
+	switch v := v.(type) {
+	case float64:
+		// overloads are passed on as they are, since scaling them down would make them look like good values
+		if isBadValue(v) {
+			return v
+		}
+		return t.convertUnit(t.calibrate(t.linearizeValue(v*scale + t.offset)))
+	case int64:
+		return t.convertUnit(t.calibrate(t.linearizeValue(float64(v)*scale + t.offset)))
+	}
+	return value
+}
+
+// ReadItems returns a slice of all readings in tag order. Items are read concurrently by one worker per OPC
+// connection. Each connection serializes its reads, so once an item times out the worker stops taking items and
+// leaves the remaining ones to the others rather than queueing them up behind the stalled read. Connections still
+// waiting on a read from an earlier tick aren't used, and items no worker could read are left out like any failed
+// read. Tags which are only polled
+// every few ticks are skipped unless the tick is a multiple of their PollEvery. With GroupRead the items are read
+// in a single read of the OPC group of the DAQ instead. While the channels are subscribed to, only those which
+// changed are returned
+func (d *DAQConnection) ReadItems(tick int64) []Reading {
+	if d.isSubscribed() {
+		return d.readChanges()
+	}
+	tagMap := d.GetTagMap()
+	idxs := make([]int, 0, len(tagMap))
+	for idx, tag := range tagMap {
+		if idx != 0 && (tag.pollEvery <= 1 || tick%tag.pollEvery == 0) {
+			idxs = append(idxs, idx)
+		}
 	}
 	sort.Ints(idxs)
-	readings := make([]Reading, 0, len(idxs)-1)
-	for _, i := range idxs {
-		if i != 0 {
-			readings = append(readings, Reading{
-				Item: d.ReadItem(d.TagMap[i].tag),
-				Name: d.TagMap[i].name,
-				Type: d.TagMap[i].tagType,
-			})
+	readings := make([]Reading, len(idxs))
+	jobs := make(chan int, len(idxs))
+	for pos, i := range idxs {
+		readings[pos] = Reading{
+			Name:   tagMap[i].name,
+			Type:   tagMap[i].tagType,
+			Unit:   tagMap[i].unit,
+			Kind:   tagMap[i].kind,
+			Index:  i,
+			OPCTag: tagMap[i].tag,
+			Labels: tagMap[i].labels,
 		}
+		jobs <- pos
+	}
+	close(jobs)
+	if d.GroupRead {
+		failed, err := d.readGroup(tagMap, idxs, readings)
+		if err != nil {
+			log.Printf("%s: %v", d.Name, err)
+		}
+		if failed {
+			d.checkForMissingTags()
+		}
+		return readings
+	}
+	var failed int32
+	var wg sync.WaitGroup
+	for _, conn := range append([]opc.Connection{d.Connection}, d.workers...) {
+		if d.isBusy(conn) {
+			continue
+		}
+		wg.Add(1)
+		go func(conn opc.Connection) {
+			defer wg.Done()
+			for pos := range jobs {
+				tag := tagMap[idxs[pos]]
+				item, err := d.readItem(conn, tag.tag)
+				if err != nil {
+					log.Printf("%s: %s: %v", d.Name, tag.name, err)
+					atomic.StoreInt32(&failed, 1)
+					if d.isBusy(conn) {
+						return
+					}
+					continue
+				}
+				if item.Value == nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+				item.Value = tag.convert(item.Value)
+				readings[pos].Item = item
+			}
+		}(conn)
+	}
+	wg.Wait()
+	// the items left over once every connection stalled weren't read
+	var unread []string
+	for pos := range jobs {
+		unread = append(unread, tagMap[idxs[pos]].name)
+	}
+	if len(unread) > 0 {
+		log.Printf("%s: %s: %v", d.Name, strings.Join(unread, ", "), ErrConnectionBusy)
+		atomic.StoreInt32(&failed, 1)
+	}
+	// a failed read can mean the channel was removed from the DAQ software
+	if atomic.LoadInt32(&failed) == 1 {
+		d.checkForMissingTags()
 	}
 	return readings
 }
 
 type FlukeDatasource struct {
+	sequence uint64 // used atomically, kept first for 64-bit alignment
 	sdk.DatasourceBase
-	recording  int32 // used atomically
-	quitChan   chan struct{}
-	connection *DAQConnection
-	config     *cfg.Config
-	client     influx.Client
+	recording   int32 // used atomically
+	paused      int32 // used atomically
+	unscheduled int32 // used atomically
+	cancel      context.CancelFunc
+	cancelMu    sync.Mutex
+	recordDone  chan struct{} // closed once the last recording has finished cleaning up
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+	statusChan  chan *proto.Frame
+	reloadChan  chan struct{}
+	burst       *burst
+	burstMu     sync.Mutex
+	masked      map[string]bool
+	maskMu      sync.RWMutex
+	triggerNow  int32 // used atomically
+	// the active alarms, marked once they're acknowledged, and the acknowledged alarms whose frames are yet to be sent
+	alarms      map[alarmKey]Alarm
+	acked       []Alarm
+	ackChan     chan struct{}
+	shelved     map[string]time.Time
+	alarmMu     sync.Mutex
+	daqs        []DAQ
+	connectDAQs DAQConnector // dialDAQs unless set, e.g. to a mock
+	connMu      sync.RWMutex
+	config      *cfg.Config
+	configPath  string
+	configMu    sync.RWMutex
+	client      influx.Client
+	influxStat  *influxHealth
+	latest      map[string]Payload
+	status      *Status
+	latestMu    sync.RWMutex
+	streams     *frameHub
+	metrics     *metrics
+	// captures or replays the recording, only used by the recording goroutine
+	golden goldenSession
+	// replaces the polling interval, e.g. to replay golden bundles without waiting
+	intervalOverride time.Duration
 	sync.WaitGroup
 }
 
 type Payload struct {
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
+	Name      string        `json:"name"`
+	Value     interface{}   `json:"value"`
+	Unit      string        `json:"unit,omitempty"`
+	Kind      string        `json:"kind,omitempty"`
+	Quality   int16         `json:"quality"`
+	Timestamp int64         `json:"timestamp"`
+	ID        int           `json:"id"`
+	OPCTag    string        `json:"opc_tag,omitempty"`
+	Bad       bool          `json:"bad,omitempty"`
+	Suspect   bool          `json:"suspect,omitempty"`
+	Fault     string        `json:"fault,omitempty"`
+	Raw       *float64      `json:"raw,omitempty"`
+	Spike     bool          `json:"spike,omitempty"`
+	Rejected  int64         `json:"rejected,omitempty"`
+	Stats     *ChannelStats `json:"stats,omitempty"`
 }
 
 type Frame struct {
-	Data []Payload `json:"data"`
+	Sequence   uint64    `json:"sequence"`
+	Burst      bool      `json:"burst,omitempty"`
+	PreTrigger bool      `json:"pre_trigger,omitempty"`
+	Data       []Payload `json:"data"`
 }
 
 // Compile time check to ensure DemoDatasource satisfies the Datasource interface
@@ -176,129 +671,409 @@ func (e *FlukeDatasource) StartRecord() (chan *proto.Frame, error) {
 	if atomic.LoadInt32(&e.recording) == 1 {
 		return nil, ErrAlreadyRecording
 	}
-	// start connection
-	err := e.connection.StartScanning()
-	if err != nil {
-		return nil, err
+	// a stopped recording may still be stopping the DAQs from scanning, which must not happen after they're started
+	// again below
+	e.cancelMu.Lock()
+	done := e.recordDone
+	e.cancelMu.Unlock()
+	if done != nil {
+		<-done
 	}
-	var ticker *time.Ticker
-	if e.config.PollingInterval != 0 {
-		ticker = time.NewTicker(time.Duration(e.config.PollingInterval) * time.Second)
-	} else {
-		ticker = time.NewTicker(defaultPolInterval)
+	// connect to the DAQs if it hasn't been done yet
+	if _, err := e.connect(); err != nil {
+		return nil, err
 	}
+	// the Influx settings and acquisition mode are fixed for the duration of the recording
+	config := e.getConfig()
 	frameChan := make(chan *proto.Frame)
-	var writeAPI api.WriteAPI
-	if e.config.Influx {
-		if e.config.InfluxOrgName == "" || e.config.InfluxBucketName == "" {
-			return nil, ErrBlankInfluxOrgOrBucket
-		}
-		orgAPI := e.client.OrganizationsAPI()
-		org, err := orgAPI.FindOrganizationByName(context.Background(), e.config.InfluxOrgName)
-		if err != nil {
-			return nil, ErrInvalidOrg
-		}
-		bucketAPI := e.client.BucketsAPI()
-		buckets, err := bucketAPI.FindBucketsByOrgName(context.Background(), e.config.InfluxOrgName)
-		if err != nil {
-			return nil, ErrInvalidOrg
-		}
-		var found bool
-		for _, bucket := range *buckets {
-			if bucket.Name == e.config.InfluxBucketName {
-				found = true
-				break
-			}
+	sinks, err := e.openSinks(config)
+	if err != nil {
+		return nil, err
+	}
+	var spill *spillFile
+	if config.SpillFile != "" {
+		if spill, err = openSpillFile(e.configRelativePath(config.SpillFile)); err != nil {
+			sinks.close()
+			return nil, err
 		}
-		if !found {
-			log.Printf("Creating %s bucket...", e.config.InfluxBucketName)
-			_, err := bucketAPI.CreateBucketWithName(context.Background(), org, e.config.InfluxBucketName, domain.RetentionRule{EverySeconds: 0})
-			if err != nil {
-				return nil, err
+	}
+	// start connection, unless scanning waits for the trigger. This is done last so that a failed start doesn't
+	// leave the DAQs scanning
+	if config.Trigger == nil || !config.Trigger.DeferScanning {
+		if err := e.startScanning(); err != nil {
+			if spill != nil {
+				spill.close()
 			}
+			sinks.close()
+			return nil, err
 		}
-		writeAPI = e.client.WriteAPI(e.config.InfluxOrgName, e.config.InfluxBucketName)
 	}
+	// the recording flag and its cancel func are changed together so StopRecord always cancels this recording
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancelMu.Lock()
 	if ok := atomic.CompareAndSwapInt32(&e.recording, 0, 1); !ok {
+		e.cancelMu.Unlock()
+		cancel()
+		if spill != nil {
+			spill.close()
+		}
+		sinks.close()
 		return nil, ErrAlreadyRecording
 	}
+	e.cancel = cancel
+	done = make(chan struct{})
+	e.recordDone = done
+	e.cancelMu.Unlock()
+	interval, _ := e.currentPollingInterval()
+	ticker := time.NewTicker(interval)
+	// send gives up on a frame once the recording is stopped so that the goroutine can't be left blocked on a
+	// frame nobody will receive. With a spill file, frames are spilled rather than waiting on a stalled consumer
+	send := func(frame *proto.Frame) bool {
+		sinks.publish(frame)
+		e.streams.broadcast(frame)
+		if spill != nil {
+			return sendOrSpill(ctx, frameChan, spill, spillTimeout(config), frame)
+		}
+		select {
+		case frameChan <- frame:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	e.Add(1)
 	go func() {
 		defer e.Done()
+		defer close(done)
+		defer cancel()
 		defer close(frameChan)
+		if spill != nil {
+			defer spill.close()
+		}
+		// the Influx client is kept open for the next recording and only closed when the plugin stops
 		defer func() {
 			ticker.Stop()
-			err := e.connection.StopScanning()
+			e.stopScanning()
+			sinks.close()
+		}()
+		if config.GoldenDir != "" {
+			capture, err := newGoldenCapture(e.configRelativePath(config.GoldenDir), config)
 			if err != nil {
 				log.Println(err)
+				return
 			}
-			if e.config.Influx {
-				writeAPI.Flush()
-				e.client.Close()
+			e.golden = capture
+			defer func() {
+				capture.close()
+				e.golden = nil
+			}()
+		}
+		// with subscriptions the DAQs push the channels which changed and they're read whenever there are changes
+		// rather than on every tick
+		polls := ticker.C
+		if config.Subscribed() {
+			changed := make(chan time.Time, 1)
+			if err := e.subscribe(interval, changed); err != nil {
+				log.Println(err)
+				return
 			}
-		}()
-		time.Sleep(1 * time.Second) // sleep for a second while laniakea sets up the plugin
+			defer e.unsubscribe()
+			polls = changed
+		}
+		badValues := newBadValueFilter(config.BadValuePolicy)
+		junctions := newColdJunctions(config)
+		virtual, err := newVirtualChannels(config)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		spikes := e.newSpikeFilter(config)
+		smoothing := newSmoother(config)
+		decimation := newDecimator(config)
+		alarms := newAlarmChecker(config)
+		rules := newRuleChecker(config)
+		e.resetAlarms()
+		stale := newStaleDetector(time.Duration(config.StaleAfter) * time.Second)
+		faults := newFaultDetector()
+		encoder := newPayloadEncoder(config.PayloadEncoding, config.CompressPayload)
+		var notifier *webhookNotifier
+		if config.Webhook != nil {
+			var err error
+			if notifier, err = newWebhookNotifier(config.Webhook); err != nil {
+				log.Println(err)
+				return
+			}
+			defer notifier.close()
+		}
+		// sendAlarms sends the frames of alarms raised or cleared following the frame of the given sequence
+		sendAlarms := func(raised []Alarm, sequence uint64) bool {
+			for _, alarm := range raised {
+				alarm.Sequence = sequence
+				alarm, publish := e.trackAlarm(alarm)
+				logAlarm(alarm)
+				if !publish {
+					continue
+				}
+				notifier.notify(alarm)
+				frame, err := e.alarmFrame(alarm)
+				if err != nil {
+					log.Println(err)
+					return false
+				}
+				if !send(frame) {
+					return false
+				}
+			}
+			return true
+		}
+		// channels are sampled between frames when window statistics are enabled
+		var (
+			stats   *windowStats
+			samples <-chan time.Time
+		)
+		if config.SampleInterval > 0 {
+			sampleTicker := time.NewTicker(time.Duration(config.SampleInterval) * time.Millisecond)
+			defer sampleTicker.Stop()
+			stats = newWindowStats()
+			samples = sampleTicker.C
+		}
+		// buildPayloads turns the readings of a scan into payloads, leaving out those without a value. Good readings go
+		// through the spike filter and smoothing, and every payload is flagged with the stale and fault state of its
+		// channel
+		buildPayloads := func(readings []Reading) []Payload {
+			data := []Payload{}
+			for _, reading := range readings {
+				value, bad, ok := readingValue(config, badValues, reading)
+				if !ok {
+					continue
+				}
+				var raw *float64
+				var spike bool
+				if !bad {
+					value, spike = spikes.apply(reading, value)
+					value, raw = smoothing.apply(reading, value)
+				}
+				data = append(data, Payload{
+					Name:      reading.Name,
+					Value:     value,
+					Unit:      reading.Unit,
+					Kind:      reading.Kind,
+					Quality:   reading.Item.Quality,
+					Timestamp: reading.Item.Timestamp.UnixMilli(),
+					ID:        reading.Index,
+					OPCTag:    opcTag(config, reading),
+					Bad:       bad,
+					Suspect:   stale.isStale(reading.Name),
+					Fault:     faults.fault(reading.Name),
+					Raw:       raw,
+					Spike:     spike,
+					Rejected:  spikes.rejected(reading.Name),
+					Stats:     stats.get(reading.Name),
+				})
+			}
+			if config.GroupByKind {
+				groupByKind(data)
+			}
+			return data
+		}
+		// counts the polls so that slower tags can be read every few ticks
+		var tick int64
+		var idle bool
+		// set while the channels of group reads have differing timestamps, so that it's only logged when it starts
+		var skewed bool
+		triggered := config.Trigger == nil
+		var preTrigger *scanRing
+		if config.Trigger != nil {
+			preTrigger = newScanRing(config.Trigger.PreTriggerScans)
+		}
+		// counts the data frames sent, for the recording limits
+		var frames int64
+		started := time.Now()
+		// wait while laniakea sets up the plugin and the DAQs complete their first scans
+		select {
+		case <-time.After(warmupDelay(config)):
+		case <-ctx.Done():
+			return
+		}
+		if config.WaitForGoodRead && !e.waitForGoodRead(ctx) {
+			return
+		}
+		frame, err := e.metadataFrame()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !send(frame) {
+			return
+		}
 		for {
 			select {
-			case <-ticker.C:
-				data := []Payload{}
-				df := Frame{}
-				readings := e.connection.ReadItems()
-				current_time := time.Now()
-				for _, reading := range readings {
-					switch v := reading.Item.Value.(type) {
-					case float64:
-						data = append(data, Payload{Name: reading.Name, Value: v})
-						if e.config.Influx {
-							if reading.Type != "ignore" {
-								p := influx.NewPoint(
-									reading.Type,
-									map[string]string{
-										"id": reading.Name,
-									},
-									map[string]interface{}{
-										reading.Type: v,
-									},
-									current_time,
-								)
-								// write asynchronously
-								writeAPI.WritePoint(p)
-							}
+			case <-polls:
+				// return to the normal polling interval once a burst is over
+				current, bursting := e.currentPollingInterval()
+				if current != interval {
+					interval = current
+					ticker.Reset(interval)
+				}
+				// the DAQs keep scanning while paused or outside the schedule but nothing is read or sent. Sinks are
+				// flushed once so that nothing is left buffered while idle
+				if e.isPaused() || !e.inSchedule() {
+					if !idle {
+						sinks.flush()
+						// the samples taken so far would otherwise end up in the window of the first frame once resumed
+						if stats != nil {
+							stats.reset()
+						}
+						idle = true
+					}
+					continue
+				}
+				idle = false
+				readings, readTime := e.readItems(tick)
+				tick++
+				// the changes were already read with those of an earlier notification
+				if config.Subscribed() && len(readings) == 0 {
+					continue
+				}
+				readings = e.unmasked(virtual.add(junctions.apply(readings)))
+				// nothing is sent until the trigger channel crosses its threshold
+				if !triggered {
+					manual := e.manualTrigger()
+					if !manual && !triggerCrossed(config.Trigger, readings) {
+						preTrigger.add(scan{readings: readings, time: readTime, preTrigger: true})
+						continue
+					}
+					triggered = true
+					if manual {
+						log.Println("Recording triggered manually")
+					} else {
+						log.Printf("Recording triggered by %s", config.Trigger.Channel)
+					}
+					if config.Trigger.DeferScanning {
+						if err := e.startScanning(); err != nil {
+							log.Println(err)
+							return
 						}
-					case float32:
-						data = append(data, Payload{Name: reading.Name, Value: float64(v)})
-						if e.config.Influx {
-							if reading.Type != "ignore" {
-								p := influx.NewPoint(
-									reading.Type,
-									map[string]string{
-										"id": reading.Name,
-									},
-									map[string]interface{}{
-										reading.Type: float64(v),
-									},
-									current_time,
-								)
-								// write asynchronously
-								writeAPI.WritePoint(p)
+					}
+				}
+				for _, frame := range e.missingTagFrames() {
+					if !send(frame) {
+						return
+					}
+				}
+				// scans buffered before the trigger are sent first so the lead up to the event is captured
+				for _, polled := range append(preTrigger.drain(), scan{readings: readings, time: readTime}) {
+					readings := polled.readings
+					df := Frame{}
+					if stats != nil {
+						stats.add(readings)
+					}
+					scanAlarms := append(stale.check(readings, polled.time), faults.check(readings, polled.time)...)
+					current_time := polled.time
+					if config.GroupRead {
+						var skew time.Duration
+						current_time, skew = scanTime(readings)
+						if skew > 0 && !skewed {
+							log.Printf("The timestamps of the channels read at %v are up to %v apart, some channels weren't updated with the latest scan", current_time, skew)
+						}
+						skewed = skew > 0
+					}
+					df.Sequence = atomic.AddUint64(&e.sequence, 1)
+					df.Burst = bursting
+					df.PreTrigger = polled.preTrigger
+					df.Data = buildPayloads(readings)
+					e.setLatest(df.Data)
+					// sinks are written before sending so that they're kept even if Laniakea isn't receiving
+					sinks.write(&SinkFrame{
+						Frame:    &df,
+						Time:     current_time,
+						Channels: e.channelNames(),
+						Readings: readingsByName(readings),
+					})
+					if stats != nil {
+						stats.reset()
+					}
+					// only the decimated frames are forwarded while the sinks keep every scan
+					forward := decimation.add(&df, readings)
+					if forward != nil {
+						payloads, frameType, err := encoder.encode(forward, e.channelNames(), current_time)
+						if err != nil {
+							log.Println(err)
+							return
+						}
+						if config.FrameType != "" {
+							frameType = config.FrameType
+						}
+						for _, b := range payloads {
+							frame := &proto.Frame{
+								Source:    e.frameSource(),
+								Type:      frameType,
+								Timestamp: current_time.UnixMilli(),
+								Payload:   b,
+							}
+							if e.golden != nil {
+								e.golden.sent(frame)
+							}
+							if !send(frame) {
+								return
 							}
 						}
 					}
+					// alarms are checked on every scan so that none are missed between forwarded frames
+					scanAlarms = append(scanAlarms, alarms.check(&df, current_time)...)
+					if !sendAlarms(append(scanAlarms, rules.check(&df, current_time)...), df.Sequence) {
+						return
+					}
+					if forward == nil {
+						continue
+					}
+					frames++
+					e.metrics.frameSent()
+					reason := recordingLimit(config, started, frames)
+					if reason == "" && e.replayFinished() {
+						reason = "end of replay reached"
+					}
+					if reason != "" {
+						log.Printf("Stopping recording: %s", reason)
+						// StopRecord got there first
+						if !atomic.CompareAndSwapInt32(&e.recording, 1, 0) {
+							return
+						}
+						frame, err := e.summaryFrame(reason, started, frames)
+						if err != nil {
+							log.Println(err)
+							return
+						}
+						send(frame)
+						return
+					}
 				}
-				df.Data = data[:]
-				// transform to json string
-				b, err := json.Marshal(&df)
-				if err != nil {
-					log.Println(err)
-					return
+			case <-samples:
+				// nothing is sampled while no frames are being sent
+				if e.isPaused() || !e.inSchedule() {
+					continue
 				}
-				frameChan <- &proto.Frame{
-					Source:    pluginName,
-					Type:      "application/json",
-					Timestamp: current_time.UnixMilli(),
-					Payload:   b,
+				readings, _ := e.readItems(tick)
+				stats.add(e.unmasked(virtual.add(junctions.apply(readings))))
+			case <-e.reloadChan:
+				interval, _ = e.currentPollingInterval()
+				ticker.Reset(interval)
+			case <-e.ackChan:
+				for _, alarm := range e.takeAcked() {
+					frame, err := e.alarmFrame(alarm)
+					if err != nil {
+						log.Println(err)
+						return
+					}
+					if !send(frame) {
+						return
+					}
+				}
+			case frame := <-e.statusChan:
+				if !send(frame) {
+					return
 				}
-			case <-e.quitChan:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -306,39 +1081,365 @@ func (e *FlukeDatasource) StartRecord() (chan *proto.Frame, error) {
 	return frameChan, nil
 }
 
-// Implements the Datasource interface funciton StopRecord
+// warmupDelay returns the configured time to wait after starting a recording before the first frame
+func warmupDelay(config *cfg.Config) time.Duration {
+	if config.WarmupDelay != 0 {
+		return time.Duration(config.WarmupDelay) * time.Second
+	}
+	return defaultWarmupDelay
+}
+
+// waitForGoodRead reads every channel until all of them have good quality, so that the first frame doesn't carry
+// the values of a scan still in progress. False is returned if the recording was stopped in the meantime
+func (e *FlukeDatasource) waitForGoodRead(ctx context.Context) bool {
+	log.Println("Waiting for every channel to read with good quality")
+	for {
+		good := true
+		readings, _ := e.readItems(0)
+		for _, reading := range readings {
+			if reading.Item.Value == nil || !reading.Item.Good() {
+				good = false
+				break
+			}
+		}
+		if good {
+			return true
+		}
+		select {
+		case <-time.After(goodReadRetryInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// readingsByName returns the given readings by channel name
+func readingsByName(readings []Reading) map[string]Reading {
+	byName := make(map[string]Reading, len(readings))
+	for _, reading := range readings {
+		byName[reading.Name] = reading
+	}
+	return byName
+}
+
+// readingValue returns the value of a reading after applying the bad value policy and the precision of its type,
+// whether it's bad and false if the reading is to be left out
+func readingValue(config *cfg.Config, badValues *badValueFilter, reading Reading) (interface{}, bool, bool) {
+	value, ok := payloadValue(reading.Item.Value)
+	if !ok {
+		return nil, false, false
+	}
+	value, bad, ok := badValues.apply(reading, value)
+	if !ok {
+		return nil, false, false
+	}
+	if reading.Kind == cfg.KindDigital {
+		return digitalValue(value), bad, true
+	}
+	if places, ok := precision(config, reading); ok {
+		value = roundValue(value, places)
+	}
+	return value, bad, true
+}
+
+// precision returns the number of decimal places the value of a reading is rounded to, set by channel Type or
+// otherwise by Kind
+func precision(config *cfg.Config, reading Reading) (int64, bool) {
+	if places, ok := config.Precision[reading.Type]; ok {
+		return places, true
+	}
+	places, ok := config.Precision[reading.Kind]
+	return places, ok
+}
+
+// digitalValue returns the state of a digital channel, which is on for any non zero value. Other values are
+// returned unchanged
+func digitalValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return v != 0
+	case int64:
+		return v != 0
+	}
+	return value
+}
+
+// groupByKind orders the payloads of a frame by the kind of their channel, keeping the order of channels of the same
+// kind. Channels without a kind come last
+func groupByKind(data []Payload) {
+	rank := func(kind string) int {
+		for i, k := range cfg.Kinds {
+			if k == kind {
+				return i
+			}
+		}
+		return len(cfg.Kinds)
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return rank(data[i].Kind) < rank(data[j].Kind)
+	})
+}
+
+// opcTag returns the OPC tag of a reading if OPC tags are to be included in the payload
+func opcTag(config *cfg.Config, reading Reading) string {
+	if config.PayloadOPCTags {
+		return reading.OPCTag
+	}
+	return ""
+}
+
+// pollingInterval returns the configured amount of time between readings
+func pollingInterval(config *cfg.Config) time.Duration {
+	if config.PollingInterval != 0 {
+		return time.Duration(config.PollingInterval) * time.Second
+	}
+	return defaultPolInterval
+}
+
+// frameSource returns the source name given to every frame so that consumers can tell instances of the plugin apart
+func (e *FlukeDatasource) frameSource() string {
+	if source := e.getConfig().FrameSource; source != "" {
+		return source
+	}
+	return pluginName
+}
+
+// configRelativePath returns the given path relative to the directory of the config file, unless it's absolute
+func (e *FlukeDatasource) configRelativePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(e.configPath), path)
+}
+
+// getConfig returns the current config, which can be replaced when the config file is reloaded
+func (e *FlukeDatasource) getConfig() *cfg.Config {
+	e.configMu.RLock()
+	defer e.configMu.RUnlock()
+	return e.config
+}
+
+// getDAQs returns the current DAQs. It is nil until a connection has been established
+func (e *FlukeDatasource) getDAQs() []DAQ {
+	e.connMu.RLock()
+	defer e.connMu.RUnlock()
+	return e.daqs
+}
+
+// getConnections returns the current DAQ connections. It is nil until a connection has been established
+func (e *FlukeDatasource) getConnections() []*DAQConnection {
+	return connectionsOf(e.getDAQs())
+}
+
+// connect establishes the DAQ connections if they don't already exist, retrying a configurable number of times
+// so that the plugin can be registered with Laniakea before the DAQ hardware is available
+func (e *FlukeDatasource) connect() ([]DAQ, error) {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	if e.daqs != nil {
+		return e.daqs, nil
+	}
+	config := e.getConfig()
+	attempts := defaultConnectAttempts
+	if config.ConnectAttempts != 0 {
+		attempts = int(config.ConnectAttempts)
+	}
+	retryDelay := defaultConnectRetryDelay
+	if config.ConnectRetryDelay != 0 {
+		retryDelay = time.Duration(config.ConnectRetryDelay) * time.Second
+	}
+	var err error
+	for i := 1; i <= attempts; i++ {
+		var daqs []DAQ
+		daqs, err = e.daqConnector()(config)
+		if err == nil {
+			e.daqs = daqs
+			return daqs, nil
+		}
+		// retrying won't fix an invalid config
+		if errors.Is(err, ErrInvalidTagIndex) {
+			return nil, err
+		}
+		log.Printf("Could not connect to DAQ (attempt %d of %d): %v", i, attempts, err)
+		if i < attempts {
+			time.Sleep(retryDelay)
+		}
+	}
+	return nil, err
+}
+
+// tryConnect makes a single attempt at establishing the DAQ connections if they don't already exist
+func (e *FlukeDatasource) tryConnect() error {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	if e.daqs != nil {
+		return nil
+	}
+	daqs, err := e.daqConnector()(e.getConfig())
+	if err != nil {
+		return err
+	}
+	e.daqs = daqs
+	return nil
+}
+
+// daqConnector returns the DAQConnector used to connect to the DAQs
+func (e *FlukeDatasource) daqConnector() DAQConnector {
+	if e.connectDAQs != nil {
+		return e.connectDAQs
+	}
+	return e.dialDAQs
+}
+
+// startScanning starts the scanning process on every DAQ. If one fails, those already started are stopped
+func (e *FlukeDatasource) startScanning() error {
+	daqs := e.getDAQs()
+	for i, daq := range daqs {
+		if err := daq.StartScanning(); err != nil {
+			for j, started := range daqs[:i] {
+				if err := started.StopScanning(); err != nil {
+					log.Printf("%s: %v", daqName(started, j), err)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stopScanning stops the scanning process on every DAQ
+func (e *FlukeDatasource) stopScanning() {
+	for i, daq := range e.getDAQs() {
+		if err := daq.StopScanning(); err != nil {
+			log.Printf("%s: %v", daqName(daq, i), err)
+		}
+	}
+}
+
+// readItems combines the readings of every DAQ in the order they are defined in the config
+func (e *FlukeDatasource) readItems(tick int64) ([]Reading, time.Time) {
+	var readings []Reading
+	daqs := e.getDAQs()
+	reads := make([][]Reading, 0, len(daqs))
+	for i, daq := range daqs {
+		start := time.Now()
+		read := daq.ReadItems(tick)
+		e.metrics.observeRead(daqName(daq, i), time.Since(start), read)
+		readings = append(readings, read...)
+		reads = append(reads, read)
+	}
+	if e.golden != nil {
+		return readings, e.golden.scanned(reads)
+	}
+	return readings, time.Now()
+}
+
+// Implements the Datasource interface funciton StopRecord. It only signals the recording goroutine to stop, so it
+// never blocks and can be called any number of times
 func (e *FlukeDatasource) StopRecord() error {
+	e.cancelMu.Lock()
+	defer e.cancelMu.Unlock()
 	if ok := atomic.CompareAndSwapInt32(&e.recording, 1, 0); !ok {
 		return ErrAlreadyStoppedRecording
 	}
-	e.quitChan <- struct{}{}
+	atomic.StoreInt32(&e.paused, 0)
+	// a burst doesn't carry over to the next recording
+	e.burstMu.Lock()
+	e.burst = nil
+	e.burstMu.Unlock()
+	e.cancel()
 	return nil
 }
 
-// Implements the Datasource interface funciton Stop
+// Implements the Datasource interface funciton Stop. Any recording is stopped first, and calling Stop again only
+// waits for the background goroutines to finish
 func (e *FlukeDatasource) Stop() error {
-	close(e.quitChan)
+	e.stopOnce.Do(func() {
+		_ = e.StopRecord()
+		close(e.stopChan)
+		e.Wait()
+		if e.client != nil {
+			e.client.Close()
+		}
+	})
 	e.Wait()
 	return nil
 }
 
 func main() {
-	config, err := cfg.InitConfig()
+	configPath := flag.String("config", "", "path to the plugin config file")
+	initConfig := flag.String("init-config", "", "browse the OPC server and write a starter config file to the given path")
+	serverName := flag.String("server", cfg.DefaultServerName, "OPC server browsed by -init-config")
+	host := flag.String("host", cfg.DefaultServerHost, "OPC server host browsed by -init-config")
+	profile := flag.String("profile", "", "name of the config profile to use, overriding Profile in the config file")
+	golden := flag.String("verify-golden", "", "replay the golden bundle in the given directory and check that the same frames are sent")
+	flag.Parse()
+	// the profile is applied through its environment override so that it's kept when the config is reloaded
+	if *profile != "" {
+		os.Setenv(profileEnv, *profile)
+	}
+	if *golden != "" {
+		if err := verifyGolden(*golden); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		log.Printf("Every frame of %s was reproduced", *golden)
+		return
+	}
+	if *initConfig != "" {
+		if err := writeStarterConfig(*initConfig, *serverName, *host); err != nil {
+			log.Println(err)
+			return
+		}
+		log.Printf("Wrote starter config to %s", *initConfig)
+		return
+	}
+	path, err := cfg.FindConfigFile(*configPath)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	conn, err := ConnectToDAQ(config.FlukeTags)
+	config, err := cfg.InitConfig(path)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	impl := &FlukeDatasource{quitChan: make(chan struct{}), connection: conn, config: config}
+	for _, warning := range config.Warnings() {
+		log.Println(warning)
+	}
+	impl := &FlukeDatasource{
+		stopChan:   make(chan struct{}),
+		statusChan: make(chan *proto.Frame, 1),
+		reloadChan: make(chan struct{}, 1),
+		ackChan:    make(chan struct{}, 1),
+		config:     config,
+		configPath: path,
+	}
 	if config.Influx {
-		if config.InfluxURL == "" || config.InfuxAPIToken == "" {
-			log.Println("Influx URL or API Token config parameters cannot be blank")
+		impl.influxStat = &influxHealth{}
+	}
+	// the Influx server isn't needed when points are exported to files
+	if config.Influx && config.InfluxExportDir == "" {
+		if impl.client, err = impl.newInfluxClient(config); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	impl.startHeartbeat()
+	if config.WatchConfig {
+		impl.startConfigWatcher()
+	}
+	if config.PauseFile != "" {
+		impl.startPauseWatcher(config.PauseFile)
+	}
+	if config.HTTPAddress != "" {
+		impl.startHTTPServer(config.HTTPAddress)
+	}
+	if config.Modbus != nil {
+		if err := impl.startModbusServer(config.Modbus); err != nil {
+			log.Println(err)
+			return
 		}
-		impl.client = influx.NewClientWithOptions(config.InfluxURL, config.InfuxAPIToken, influx.DefaultOptions().SetTLSConfig(&tls.Config{InsecureSkipVerify: config.InfluxSkipTLS}))
 	}
 	impl.SetPluginVersion(pluginVersion)              // set the plugin version before serving
 	impl.SetVersionConstraints(laniVersionConstraint) // set required laniakea version before serving
@@ -346,6 +1447,8 @@ func main() {
 		HandshakeConfig: sdk.HandshakeConfig,
 		Plugins: map[string]plugin.Plugin{
 			pluginName: &sdk.DatasourcePlugin{Impl: impl},
+			// the same plugin dispensed as a controller accepts commands for the recording
+			controllerPluginName: &sdk.ControllerPlugin{Impl: impl},
 		},
 		// A non-nil value here enables gRPC serving for this plugin...
 		GRPCServer: plugin.DefaultGRPCServer,
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	testInterval     = 10 * time.Millisecond
+	testFrameTimeout = 5 * time.Second
+	errTestScan      = bg.Error("scan failed")
+)
+
+// fakeDAQ is a DAQ reading a fixed value from every channel, counting how often it's started and stopped
+type fakeDAQ struct {
+	channels []string
+	value    float64
+	scanErr  error
+	started  int32
+	stopped  int32
+	closed   int32
+	scanning int32
+	reads    int32
+	scanMu   sync.Mutex
+}
+
+// StartScanning implements the DAQ interface
+func (d *fakeDAQ) StartScanning() error {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+	if d.scanErr != nil {
+		return d.scanErr
+	}
+	atomic.AddInt32(&d.started, 1)
+	atomic.StoreInt32(&d.scanning, 1)
+	return nil
+}
+
+// StopScanning implements the DAQ interface
+func (d *fakeDAQ) StopScanning() error {
+	atomic.AddInt32(&d.stopped, 1)
+	atomic.StoreInt32(&d.scanning, 0)
+	return nil
+}
+
+// ReadItems implements the DAQ interface
+func (d *fakeDAQ) ReadItems(tick int64) []Reading {
+	atomic.AddInt32(&d.reads, 1)
+	readings := make([]Reading, len(d.channels))
+	for i, name := range d.channels {
+		readings[i] = Reading{
+			Item:  opc.Item{Value: d.value, Quality: opc.OPCQualityGood, Timestamp: time.Now()},
+			Name:  name,
+			Type:  "temperature",
+			Unit:  "degC",
+			Index: i + 1,
+		}
+	}
+	return readings
+}
+
+// Close implements the DAQ interface
+func (d *fakeDAQ) Close() {
+	atomic.AddInt32(&d.closed, 1)
+}
+
+// newTestDatasource returns a datasource recording from the given DAQs, polling them without waiting
+func newTestDatasource(config *cfg.Config, daqs ...DAQ) *FlukeDatasource {
+	if config == nil {
+		config = &cfg.Config{}
+	}
+	e := &FlukeDatasource{
+		stopChan:         make(chan struct{}),
+		statusChan:       make(chan *proto.Frame, 1),
+		reloadChan:       make(chan struct{}, 1),
+		ackChan:          make(chan struct{}, 1),
+		config:           config,
+		intervalOverride: testInterval,
+	}
+	e.connectDAQs = func(*cfg.Config) ([]DAQ, error) {
+		return daqs, nil
+	}
+	return e
+}
+
+// nextDataFrame returns the next data frame of the recording, skipping other frames
+func nextDataFrame(t *testing.T, frames chan *proto.Frame) Frame {
+	t.Helper()
+	timeout := time.After(testFrameTimeout)
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatal("frame channel closed before a data frame was sent")
+			}
+			if frame.Type != jsonFrameType {
+				continue
+			}
+			var f Frame
+			if err := json.Unmarshal(frame.Payload, &f); err != nil {
+				t.Fatalf("could not decode data frame: %v", err)
+			}
+			return f
+		case <-timeout:
+			t.Fatal("no data frame was sent")
+		}
+	}
+}
+
+// waitClosed drains the frame channel until it's closed
+func waitClosed(t *testing.T, frames chan *proto.Frame) {
+	t.Helper()
+	timeout := time.After(testFrameTimeout)
+	for {
+		select {
+		case _, ok := <-frames:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("frame channel wasn't closed")
+		}
+	}
+}
+
+func TestStartRecordReadsInjectedDAQs(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1", "TC_2"}, value: 21.5}
+	e := newTestDatasource(nil, daq)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	if atomic.LoadInt32(&daq.scanning) != 1 {
+		t.Fatal("DAQ isn't scanning once recording")
+	}
+	f := nextDataFrame(t, frames)
+	if len(f.Data) != 2 || f.Data[0].Name != "TC_1" || f.Data[1].Name != "TC_2" {
+		t.Fatalf("unexpected channels in frame: %+v", f.Data)
+	}
+	if f.Data[0].Value != 21.5 {
+		t.Fatalf("TC_1 is %v, expected 21.5", f.Data[0].Value)
+	}
+	next := nextDataFrame(t, frames)
+	if next.Sequence <= f.Sequence {
+		t.Fatalf("sequence %d follows %d", next.Sequence, f.Sequence)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+	if atomic.LoadInt32(&daq.scanning) != 0 {
+		t.Fatal("DAQ still scanning once the recording stopped")
+	}
+}
+
+func TestStartRecordWhileRecording(t *testing.T) {
+	e := newTestDatasource(nil, &fakeDAQ{channels: []string{"TC_1"}})
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	if _, err := e.StartRecord(); !errors.Is(err, ErrAlreadyRecording) {
+		t.Fatalf("second StartRecord returned %v, expected %v", err, ErrAlreadyRecording)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+}
+
+func TestStartRecordConnectFailure(t *testing.T) {
+	connectErr := errors.New("no DAQ")
+	e := newTestDatasource(&cfg.Config{ConnectAttempts: 1})
+	e.connectDAQs = func(*cfg.Config) ([]DAQ, error) {
+		return nil, connectErr
+	}
+	defer e.Stop()
+	if _, err := e.StartRecord(); !errors.Is(err, connectErr) {
+		t.Fatalf("StartRecord returned %v, expected %v", err, connectErr)
+	}
+	if atomic.LoadInt32(&e.recording) != 0 {
+		t.Fatal("recording after failing to connect")
+	}
+}
+
+func TestStartRecordScanFailure(t *testing.T) {
+	good := &fakeDAQ{channels: []string{"TC_1"}}
+	bad := &fakeDAQ{channels: []string{"TC_2"}, scanErr: errTestScan}
+	e := newTestDatasource(nil, good, bad)
+	defer e.Stop()
+	if _, err := e.StartRecord(); !errors.Is(err, errTestScan) {
+		t.Fatalf("StartRecord returned %v, expected %v", err, errTestScan)
+	}
+	// the DAQs already started are stopped again
+	if atomic.LoadInt32(&good.scanning) != 0 {
+		t.Fatal("DAQ left scanning after another failed to start")
+	}
+	// the failed start leaves the datasource ready to try again
+	bad.scanMu.Lock()
+	bad.scanErr = nil
+	bad.scanMu.Unlock()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord after a failed start: %v", err)
+	}
+	if f := nextDataFrame(t, frames); len(f.Data) != 2 {
+		t.Fatalf("expected both DAQs in the frame, got %+v", f.Data)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+}
+
+func TestStopWhileRecording(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1"}}
+	e := newTestDatasource(nil, daq)
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	nextDataFrame(t, frames)
+	done := make(chan struct{})
+	go func() {
+		// frames are drained so that the recording isn't blocked sending
+		waitClosed(t, frames)
+		close(done)
+	}()
+	if err := e.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	<-done
+	if atomic.LoadInt32(&daq.scanning) != 0 {
+		t.Fatal("DAQ still scanning after Stop")
+	}
+	// Stop can be called again
+	if err := e.Stop(); err != nil {
+		t.Fatalf("second Stop: %v", err)
+	}
+}
+
+func TestStartStopStart(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1"}}
+	e := newTestDatasource(nil, daq)
+	if err := e.StopRecord(); !errors.Is(err, ErrAlreadyStoppedRecording) {
+		t.Fatalf("StopRecord while idle returned %v, expected %v", err, ErrAlreadyStoppedRecording)
+	}
+	for i := 0; i < 2; i++ {
+		frames, err := e.StartRecord()
+		if err != nil {
+			t.Fatalf("StartRecord %d: %v", i+1, err)
+		}
+		nextDataFrame(t, frames)
+		if err := e.StopRecord(); err != nil {
+			t.Fatalf("StopRecord %d: %v", i+1, err)
+		}
+		waitClosed(t, frames)
+		if err := e.StopRecord(); !errors.Is(err, ErrAlreadyStoppedRecording) {
+			t.Fatalf("StopRecord %d after stopping returned %v, expected %v", i+1, err, ErrAlreadyStoppedRecording)
+		}
+	}
+	if started, stopped := atomic.LoadInt32(&daq.started), atomic.LoadInt32(&daq.stopped); started != 2 || stopped != 2 {
+		t.Fatalf("DAQ started %d and stopped %d times, expected 2 each", started, stopped)
+	}
+	// Stop once the recording already stopped only waits for the background goroutines
+	if err := e.Stop(); err != nil {
+		t.Fatalf("Stop after StopRecord: %v", err)
+	}
+	if atomic.LoadInt32(&daq.scanning) != 0 {
+		t.Fatal("DAQ still scanning after Stop")
+	}
+}
+
+// stallingConnection is an OPC connection whose reads of the stalled tag block until it's released
+// nextAlarm returns the alarm of the next alarm frame of the recording, skipping other frames
+func nextAlarm(t *testing.T, frames chan *proto.Frame) Alarm {
+	t.Helper()
+	timeout := time.After(testFrameTimeout)
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatal("frame channel closed before an alarm frame was sent")
+			}
+			if frame.Type != alarmFrameType {
+				continue
+			}
+			var alarm Alarm
+			if err := json.Unmarshal(frame.Payload, &alarm); err != nil {
+				t.Fatalf("could not decode alarm frame: %v", err)
+			}
+			return alarm
+		case <-timeout:
+			t.Fatal("no alarm frame was sent")
+		}
+	}
+}
+
+func TestAcknowledgeRuleAlarm(t *testing.T) {
+	above := 20.0
+	config := &cfg.Config{AlarmRules: []cfg.AlarmRule{{Name: "hot", When: cfg.AlarmCondition{Channel: "TC_1", Above: &above}}}}
+	daq := &fakeDAQ{channels: []string{"TC_1"}, value: 21.5}
+	e := newTestDatasource(config, daq)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	if alarm := nextAlarm(t, frames); alarm.Rule != "hot" || alarm.State != alarmStateActive {
+		t.Fatalf("alarm %+v, expected rule hot to be active", alarm)
+	}
+	if err := e.ShelveAlarms([]string{"hot"}, 1); err != nil {
+		t.Fatalf("ShelveAlarms: %v", err)
+	}
+	if shelved := e.shelvedChannels(); len(shelved) != 1 || shelved[0] != "hot" {
+		t.Fatalf("shelved %q, expected hot", shelved)
+	}
+	if err := e.UnshelveAlarms([]string{"hot"}); err != nil {
+		t.Fatalf("UnshelveAlarms: %v", err)
+	}
+	if err := e.AcknowledgeAlarms([]string{"hot"}); err != nil {
+		t.Fatalf("AcknowledgeAlarms: %v", err)
+	}
+	if alarm := nextAlarm(t, frames); alarm.Rule != "hot" || alarm.State != alarmStateAcked || !alarm.Acked {
+		t.Fatalf("alarm %+v, expected rule hot to be acknowledged", alarm)
+	}
+	if err := e.AcknowledgeAlarms([]string{"cold"}); !errors.Is(err, ErrUnknownAlarmSource) {
+		t.Fatalf("acknowledging an unknown rule returned %v, expected %v", err, ErrUnknownAlarmSource)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+}
+
+func TestStopRecordEndsBurst(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1"}, value: 21.5}
+	e := newTestDatasource(nil, daq)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	if err := e.StartBurst(minBurstInterval, time.Hour); err != nil {
+		t.Fatalf("StartBurst: %v", err)
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+	e.burstMu.Lock()
+	defer e.burstMu.Unlock()
+	if e.burst != nil {
+		t.Fatal("burst kept once the recording stopped")
+	}
+}
+
+func TestPausedRecordingIsNotSampled(t *testing.T) {
+	daq := &fakeDAQ{channels: []string{"TC_1"}, value: 21.5}
+	e := newTestDatasource(&cfg.Config{SampleInterval: 1}, daq)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	nextDataFrame(t, frames)
+	if err := e.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	// reads already under way when paused are let through first
+	time.Sleep(5 * testInterval)
+	reads := atomic.LoadInt32(&daq.reads)
+	time.Sleep(5 * testInterval)
+	if n := atomic.LoadInt32(&daq.reads) - reads; n != 0 {
+		t.Fatalf("DAQ read %d times while paused", n)
+	}
+	if err := e.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	nextDataFrame(t, frames)
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+}
+
+func TestInfluxIntervalDoesNotDrift(t *testing.T) {
+	health := &influxHealth{}
+	w, err := newInfluxExportWriter(&cfg.Config{}, t.TempDir(), health)
+	if err != nil {
+		t.Fatalf("newInfluxExportWriter: %v", err)
+	}
+	defer w.Close()
+	w.interval = 30 * time.Second
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	reading := Reading{Name: "TC_1", Type: "temperature"}
+	var written []time.Duration
+	// frames every 5 seconds, some of which are a little late
+	for i := 0; i < 20; i++ {
+		offset := time.Duration(i) * 5 * time.Second
+		if i%12 == 0 {
+			offset += 100 * time.Millisecond
+		}
+		before := health.get().Enqueued
+		err := w.Write(&SinkFrame{
+			Frame:    &Frame{Data: []Payload{{Name: "TC_1", Value: 21.5}}},
+			Time:     start.Add(offset),
+			Readings: map[string]Reading{"TC_1": reading},
+		})
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if health.get().Enqueued != before {
+			written = append(written, offset)
+		}
+	}
+	expected := []time.Duration{100 * time.Millisecond, 30 * time.Second, 60*time.Second + 100*time.Millisecond, 90 * time.Second}
+	if !reflect.DeepEqual(written, expected) {
+		t.Fatalf("points written at %v, expected %v", written, expected)
+	}
+}
+
+func TestConvertScaledOverload(t *testing.T) {
+	tag := Tag{scale: 0.001}
+	if v := tag.convert(overRangeValue); !isBadValue(v) {
+		t.Fatalf("overload converted to %v, expected it to stay an overload", v)
+	}
+	if v := tag.convert(-overRangeValue); !isBadValue(v) {
+		t.Fatalf("negative overload converted to %v, expected it to stay an overload", v)
+	}
+	if v := tag.convert(float32(overRangeValue)); !isBadValue(v) {
+		t.Fatalf("float32 overload converted to %v, expected it to stay an overload", v)
+	}
+	if v := tag.convert(1500.0); v != 1.5 {
+		t.Fatalf("1500 converted to %v, expected 1.5", v)
+	}
+}
+
+func TestCalibrationAfterLinearization(t *testing.T) {
+	// the calibration lab corrects the RTD by half a degree at 100 °C
+	tag := Tag{
+		scale:     1,
+		calib:     &cfg.Calibration{Gain: 1, Offset: 0.5},
+		linearize: sensorLinearization(cfg.SensorPT100),
+	}
+	v, ok := tag.convert(138.5055).(float64)
+	if !ok || math.Abs(v-100.5) > 0.01 {
+		t.Fatalf("138.5055 ohms converted to %v, expected the calibration to correct 100 °C to 100.5 °C", v)
+	}
+}
+
+func TestInfluxSequenceIsSigned(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newInfluxExportWriter(&cfg.Config{}, dir, &influxHealth{})
+	if err != nil {
+		t.Fatalf("newInfluxExportWriter: %v", err)
+	}
+	err = w.Write(&SinkFrame{
+		Frame:    &Frame{Sequence: 123, Data: []Payload{{Name: "TC_1", Value: 21.5}}},
+		Time:     time.Now(),
+		Readings: map[string]Reading{"TC_1": {Name: "TC_1", Type: "temperature"}},
+	})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	w.Close()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+influxQueueExt))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("exported files %v (%v), expected one", files, err)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "sequence=123i") {
+		t.Fatalf("exported %q, expected the sequence as a signed integer", b)
+	}
+}
+
+type stallingConnection struct {
+	stalled string
+	release chan struct{}
+}
+
+func (c *stallingConnection) Add(...string) error             { return nil }
+func (c *stallingConnection) Remove(string)                   {}
+func (c *stallingConnection) Read() map[string]opc.Item       { return nil }
+func (c *stallingConnection) Tags() []string                  { return nil }
+func (c *stallingConnection) Write(string, interface{}) error { return nil }
+func (c *stallingConnection) Close()                          {}
+func (c *stallingConnection) ReadItem(tag string) opc.Item {
+	if tag == c.stalled {
+		<-c.release
+	}
+	return opc.Item{Value: 1.0, Quality: opc.OPCQualityGood, Timestamp: time.Now()}
+}
+
+func TestReadItemsStalledConnection(t *testing.T) {
+	conn := &stallingConnection{stalled: "TC_2", release: make(chan struct{})}
+	d := &DAQConnection{
+		Connection:  conn,
+		Name:        "stalling",
+		ServerName:  simulatedServerName,
+		ReadTimeout: testInterval,
+		TagMap: map[int]Tag{
+			1: {name: "TC_1", tag: "TC_1"},
+			2: {name: "TC_2", tag: "TC_2"},
+			3: {name: "TC_3", tag: "TC_3"},
+		},
+		missingChan: make(chan []string, 1),
+	}
+	readings := d.ReadItems(0)
+	if len(readings) != 3 {
+		t.Fatalf("got %d readings, expected 3", len(readings))
+	}
+	// the channels after the stalled read are failed rather than queued up behind it
+	for i, value := range []interface{}{1.0, nil, nil} {
+		if readings[i].Item.Value != value {
+			t.Fatalf("%s read %v, expected %v", readings[i].Name, readings[i].Item.Value, value)
+		}
+	}
+	// nothing more is read from the connection until the stalled read returns
+	for _, reading := range d.ReadItems(1) {
+		if reading.Item.Value != nil {
+			t.Fatalf("%s was read while the connection was stalled", reading.Name)
+		}
+	}
+	close(conn.release)
+	deadline := time.Now().Add(testFrameTimeout)
+	for d.isBusy(conn) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection still busy once the stalled read returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, reading := range d.ReadItems(2) {
+		if reading.Item.Value != 1.0 {
+			t.Fatalf("%s read %v once the connection recovered", reading.Name, reading.Item.Value)
+		}
+	}
+}
+
+func TestCheckHealthWhileReadStalled(t *testing.T) {
+	conn := &stallingConnection{stalled: "TC_1", release: make(chan struct{})}
+	defer close(conn.release)
+	d := &DAQConnection{
+		Connection:  conn,
+		Name:        "stalling",
+		ServerName:  simulatedServerName,
+		ReadTimeout: testInterval,
+		TagMap: map[int]Tag{
+			0: {tag: "Scan"},
+			1: {name: "TC_1", tag: "TC_1"},
+		},
+		missingChan: make(chan []string, 1),
+	}
+	d.ReadItems(0)
+	if !d.isBusy(conn) {
+		t.Fatal("connection not busy with the stalled read")
+	}
+	// the health check doesn't wait on the stalled channel read, nor does it free the connection for other reads
+	if !d.CheckHealth() {
+		t.Fatal("health check failed while a channel read was stalled")
+	}
+	if !d.isBusy(conn) {
+		t.Fatal("health check freed the connection of the stalled read")
+	}
+}
+
+func TestCheckTagShifts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("LOCALAPPDATA", t.TempDir())
+	if err := os.MkdirAll(cfg.AppDataDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfgTags := map[int]cfg.CfgTag{
+		0: {Tag: "Scan"},
+		1: {Tag: "TC_1"},
+		2: {Tag: "TC_2", OPCTag: "Channel 5"},
+	}
+	browsed := []string{"Scan", "Channel 1", "Channel 2", "Channel 5"}
+	tagMap, _ := createTagMap(browsed, cfgTags)
+	if err := checkTagShifts("Fluke.DAQ.OPC", "rig", tagMap, cfgTags); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	// a tag added in the DAQ software shifts the channel matched by index but not the one given its OPC tag
+	browsed = []string{"Scan", "Channel 0", "Channel 1", "Channel 2", "Channel 5"}
+	tagMap, _ = createTagMap(browsed, cfgTags)
+	if err := checkTagShifts("Fluke.DAQ.OPC", "rig", tagMap, cfgTags); !errors.Is(err, ErrTagsShifted) {
+		t.Fatalf("shifted channel returned %v, expected %v", err, ErrTagsShifted)
+	}
+	// once given its OPC tag the channel is no longer checked
+	cfgTags[1] = cfg.CfgTag{Tag: "TC_1", OPCTag: "Channel 0"}
+	tagMap, _ = createTagMap(browsed, cfgTags)
+	if err := checkTagShifts("Fluke.DAQ.OPC", "rig", tagMap, cfgTags); err != nil {
+		t.Fatalf("channel given its OPC tag: %v", err)
+	}
+}
+
+// changingConnection is an OPC connection whose TC_1 changes on every read while TC_2 never does
+type changingConnection struct {
+	reads int64
+}
+
+func (c *changingConnection) Add(...string) error             { return nil }
+func (c *changingConnection) Remove(string)                   {}
+func (c *changingConnection) Tags() []string                  { return []string{"Scan", "TC_1", "TC_2"} }
+func (c *changingConnection) Write(string, interface{}) error { return nil }
+func (c *changingConnection) Close()                          {}
+func (c *changingConnection) ReadItem(tag string) opc.Item    { return c.Read()[tag] }
+func (c *changingConnection) Read() map[string]opc.Item {
+	reads := atomic.AddInt64(&c.reads, 1)
+	return map[string]opc.Item{
+		"Scan": {Value: true, Quality: opc.OPCQualityGood, Timestamp: time.Unix(0, 0)},
+		"TC_1": {Value: float64(reads), Quality: opc.OPCQualityGood, Timestamp: time.Unix(reads, 0)},
+		"TC_2": {Value: 20.0, Quality: opc.OPCQualityGood, Timestamp: time.Unix(0, 0)},
+	}
+}
+
+func TestSubscriptionSendsChanges(t *testing.T) {
+	conn := &changingConnection{}
+	d := &DAQConnection{
+		Connection:  conn,
+		Name:        "changing",
+		ServerName:  simulatedServerName,
+		ReadTimeout: testInterval,
+		standIn:     true,
+		TagMap: map[int]Tag{
+			0: {tag: "Scan"},
+			1: {name: "TC_1", tag: "TC_1"},
+			2: {name: "TC_2", tag: "TC_2"},
+		},
+		missingChan: make(chan []string, 1),
+	}
+	e := newTestDatasource(&cfg.Config{AcquisitionMode: cfg.AcquisitionModeSubscription}, d)
+	defer e.Stop()
+	frames, err := e.StartRecord()
+	if err != nil {
+		t.Fatalf("StartRecord: %v", err)
+	}
+	// every channel is pushed when subscribed, and only the channel which keeps changing after that
+	if frame := nextDataFrame(t, frames); len(frame.Data) != 2 {
+		t.Fatalf("first frame has %d channels, expected 2", len(frame.Data))
+	}
+	for i := 0; i < 3; i++ {
+		frame := nextDataFrame(t, frames)
+		if len(frame.Data) != 1 || frame.Data[0].Name != "TC_1" {
+			t.Fatalf("frame %d has %+v, expected only TC_1", i, frame.Data)
+		}
+	}
+	if err := e.StopRecord(); err != nil {
+		t.Fatalf("StopRecord: %v", err)
+	}
+	waitClosed(t, frames)
+	if d.isSubscribed() {
+		t.Fatal("still subscribed once the recording stopped")
+	}
+}
+
+func TestGroupReadReadsOnce(t *testing.T) {
+	conn := &changingConnection{}
+	d := &DAQConnection{
+		Connection:  conn,
+		Name:        "changing",
+		ServerName:  simulatedServerName,
+		ReadTimeout: testFrameTimeout,
+		GroupRead:   true,
+		standIn:     true,
+		TagMap: map[int]Tag{
+			0: {tag: "Scan"},
+			1: {name: "TC_1", tag: "TC_1"},
+			2: {name: "TC_2", tag: "TC_2"},
+		},
+		missingChan: make(chan []string, 1),
+	}
+	readings := d.ReadItems(0)
+	if reads := atomic.LoadInt64(&conn.reads); reads != 1 {
+		t.Fatalf("connection read %d times, expected the channels to be read at once", reads)
+	}
+	if len(readings) != 2 || readings[0].Item.Value != 1.0 || readings[1].Item.Value != 20.0 {
+		t.Fatalf("readings %+v, expected TC_1 and TC_2 of the first read", readings)
+	}
+	scanned, skew := scanTime(readings)
+	if !scanned.Equal(time.Unix(1, 0)) || skew != time.Second {
+		t.Fatalf("scan time %v with skew %v, expected %v with skew %v", scanned, skew, time.Unix(1, 0), time.Second)
+	}
+}
+
+// fakeGroup is an OPC group holding only the given tags
+type fakeGroup struct {
+	tags   map[string]bool
+	closed bool
+}
+
+func (g *fakeGroup) SyncRead(tags []string) ([]opc.Item, error) {
+	items := make([]opc.Item, len(tags))
+	for i, tag := range tags {
+		if !g.tags[tag] {
+			return nil, fmt.Errorf("%s is not in the OPC group", tag)
+		}
+		items[i] = opc.Item{Value: 7.0, Quality: opc.OPCQualityGood, Timestamp: time.Unix(1, 0)}
+	}
+	return items, nil
+}
+
+func (g *fakeGroup) Close() { g.closed = true }
+
+func TestRegroupedReadUsesNewGroup(t *testing.T) {
+	old := &fakeGroup{tags: map[string]bool{"TC_1": true}}
+	group := &fakeGroup{tags: map[string]bool{"TC_1": true, "TC_2": true}}
+	d := &DAQConnection{
+		Connection:  &changingConnection{},
+		Name:        "regrouped",
+		ReadTimeout: testFrameTimeout,
+		GroupRead:   true,
+		TagMap: map[int]Tag{
+			0: {tag: "Scan"},
+			1: {name: "TC_1", tag: "TC_1"},
+			2: {name: "TC_2", tag: "TC_2"},
+		},
+		group:       group,
+		retired:     []groupReader{old},
+		missingChan: make(chan []string, 1),
+	}
+	readings := d.ReadItems(0)
+	if !old.closed || group.closed {
+		t.Fatalf("old group closed %v and new group closed %v, expected only the old group to be closed", old.closed, group.closed)
+	}
+	if len(d.retired) != 0 {
+		t.Fatalf("%d groups left retired, expected none", len(d.retired))
+	}
+	for _, r := range readings {
+		if r.Item.Value != 7.0 {
+			t.Fatalf("reading %+v, expected %s to be read from the new group", r, r.Name)
+		}
+	}
+}
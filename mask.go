@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+)
+
+var (
+	ErrUnknownChannel = bg.Error("unknown channel")
+)
+
+// MaskChannels excludes the given channels from frames and Influx, e.g. a known bad thermocouple, until they're
+// unmasked. Masks last until the plugin is restarted
+func (e *FlukeDatasource) MaskChannels(names []string) error {
+	if err := e.checkChannels(names); err != nil {
+		return err
+	}
+	e.maskMu.Lock()
+	defer e.maskMu.Unlock()
+	if e.masked == nil {
+		e.masked = make(map[string]bool)
+	}
+	for _, name := range names {
+		e.masked[name] = true
+	}
+	log.Printf("Masked channels: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// UnmaskChannels includes previously masked channels in frames again
+func (e *FlukeDatasource) UnmaskChannels(names []string) error {
+	if err := e.checkChannels(names); err != nil {
+		return err
+	}
+	e.maskMu.Lock()
+	defer e.maskMu.Unlock()
+	for _, name := range names {
+		delete(e.masked, name)
+	}
+	log.Printf("Unmasked channels: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// checkChannels returns an error if any of the given names isn't a recorded channel
+func (e *FlukeDatasource) checkChannels(names []string) error {
+	known := make(map[string]bool)
+	for _, name := range e.channelNames() {
+		known[name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("%w %q", ErrUnknownChannel, name)
+		}
+	}
+	return nil
+}
+
+// maskedChannels returns the names of the masked channels in order
+func (e *FlukeDatasource) maskedChannels() []string {
+	e.maskMu.RLock()
+	defer e.maskMu.RUnlock()
+	names := make([]string, 0, len(e.masked))
+	for name := range e.masked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unmasked returns the readings of the channels which aren't masked
+func (e *FlukeDatasource) unmasked(readings []Reading) []Reading {
+	e.maskMu.RLock()
+	defer e.maskMu.RUnlock()
+	if len(e.masked) == 0 {
+		return readings
+	}
+	kept := make([]Reading, 0, len(readings))
+	for _, reading := range readings {
+		if !e.masked[reading.Name] {
+			kept = append(kept, reading)
+		}
+	}
+	return kept
+}
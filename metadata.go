@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+var (
+	metadataFrameType = "application/x-fluke-metadata"
+)
+
+type ChannelMetadata struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Unit      string `json:"unit,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Sensor    string `json:"sensor,omitempty"`
+	OPCTag    string `json:"opc_tag"`
+	PollEvery int64  `json:"poll_every,omitempty"`
+}
+
+type DAQMetadata struct {
+	Name       string            `json:"name"`
+	ServerName string            `json:"server_name"`
+	Host       string            `json:"host"`
+	Channels   []ChannelMetadata `json:"channels"`
+}
+
+type Metadata struct {
+	PluginVersion   string            `json:"plugin_version"`
+	Profile         string            `json:"profile,omitempty"`
+	PollingInterval int64             `json:"polling_interval_ms"`
+	PayloadEncoding string            `json:"payload_encoding"`
+	DAQs            []DAQMetadata     `json:"daqs"`
+	VirtualChannels []ChannelMetadata `json:"virtual_channels,omitempty"`
+}
+
+// channelMetadata returns a description of every recorded channel of the DAQ in the order they are read
+func (d *DAQConnection) channelMetadata() []ChannelMetadata {
+	tagMap := d.GetTagMap()
+	idxs := make([]int, 0, len(tagMap))
+	for idx := range tagMap {
+		if idx != 0 {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+	channels := make([]ChannelMetadata, 0, len(idxs))
+	for _, idx := range idxs {
+		tag := tagMap[idx]
+		channels = append(channels, ChannelMetadata{
+			ID:        idx,
+			Name:      tag.name,
+			Type:      tag.tagType,
+			Unit:      tag.unit,
+			Kind:      tag.kind,
+			Sensor:    tag.sensor,
+			OPCTag:    tag.tag,
+			PollEvery: tag.pollEvery,
+		})
+	}
+	return channels
+}
+
+// metadataFrame returns a frame describing the plugin, the OPC server each DAQ is connected to and every channel
+// so that consumers can configure themselves
+func (e *FlukeDatasource) metadataFrame() (*proto.Frame, error) {
+	config := e.getConfig()
+	metadata := Metadata{
+		PluginVersion:   pluginVersion,
+		Profile:         config.Profile,
+		PollingInterval: pollingInterval(config).Milliseconds(),
+		PayloadEncoding: config.PayloadEncoding,
+	}
+	if metadata.PayloadEncoding == "" {
+		metadata.PayloadEncoding = cfg.PayloadEncodingJSON
+	}
+	for _, conn := range e.getConnections() {
+		metadata.DAQs = append(metadata.DAQs, DAQMetadata{
+			Name:       conn.Name,
+			ServerName: conn.ServerName,
+			Host:       conn.Host,
+			Channels:   conn.channelMetadata(),
+		})
+	}
+	for i, virtual := range config.VirtualChannels {
+		metadata.VirtualChannels = append(metadata.VirtualChannels, ChannelMetadata{
+			ID:   -(i + 1),
+			Name: virtual.Name,
+			Type: virtual.Type,
+			Unit: virtual.Unit,
+		})
+	}
+	b, err := json.Marshal(&metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Frame{
+		Source:    e.frameSource(),
+		Type:      metadataFrameType,
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   b,
+	}, nil
+}
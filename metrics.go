@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus metrics of the plugin, served on /metrics by the HTTP server
+type metrics struct {
+	registry    *prometheus.Registry
+	pollSeconds *prometheus.HistogramVec
+	readErrors  *prometheus.CounterVec
+	frames      prometheus.Counter
+	spikes      *prometheus.CounterVec
+}
+
+// channelCollector collects the latest value of every numeric channel at scrape time
+type channelCollector struct {
+	e    *FlukeDatasource
+	desc *prometheus.Desc
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *channelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *channelCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, payload := range c.e.getLatest() {
+		var v float64
+		switch value := payload.Value.(type) {
+		case float64:
+			v = value
+		case int64:
+			v = float64(value)
+		default:
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, v, payload.Name, payload.Unit)
+	}
+}
+
+// newMetrics returns the metrics of the plugin, including the latest channel values
+func (e *FlukeDatasource) newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		pollSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "fluke_poll_duration_seconds",
+			Help: "Time taken to read the channels of a DAQ",
+		}, []string{"daq"}),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluke_read_errors_total",
+			Help: "Channel reads which failed or timed out",
+		}, []string{"daq"}),
+		frames: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fluke_frames_total",
+			Help: "Data frames sent to Laniakea",
+		}),
+		spikes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluke_spikes_rejected_total",
+			Help: "Readings rejected as spikes by the spike filter",
+		}, []string{"channel"}),
+	}
+	m.registry.MustRegister(
+		m.pollSeconds,
+		m.readErrors,
+		m.frames,
+		m.spikes,
+		&channelCollector{e: e, desc: prometheus.NewDesc(
+			"fluke_channel_value",
+			"Latest value of a channel",
+			[]string{"channel", "unit"},
+			nil,
+		)},
+		prometheus.NewGoCollector(),
+	)
+	return m
+}
+
+// handler returns the handler serving the metrics
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRead records a read of the channels of a DAQ
+func (m *metrics) observeRead(daq string, d time.Duration, readings []Reading) {
+	if m == nil {
+		return
+	}
+	m.pollSeconds.WithLabelValues(daq).Observe(d.Seconds())
+	for _, reading := range readings {
+		if reading.Item.Value == nil {
+			m.readErrors.WithLabelValues(daq).Inc()
+		}
+	}
+}
+
+// spikeRejected counts a reading of a channel rejected as a spike
+func (m *metrics) spikeRejected(channel string) {
+	if m == nil {
+		return
+	}
+	m.spikes.WithLabelValues(channel).Inc()
+}
+
+// frameSent counts a data frame sent to Laniakea
+func (m *metrics) frameSent() {
+	if m == nil {
+		return
+	}
+	m.frames.Inc()
+}
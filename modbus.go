@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	modbusReadHoldingRegisters byte   = 0x03
+	modbusIllegalFunction      byte   = 0x01
+	modbusIllegalDataAddress   byte   = 0x02
+	modbusIllegalDataValue     byte   = 0x03
+	modbusMaxRegisters         uint16 = 125
+	modbusUnavailableInt16     int16  = math.MinInt16
+)
+
+// modbusServer serves the latest channel values as holding registers over Modbus TCP. Only reading holding
+// registers is supported, every other function is answered with an illegal function exception
+type modbusServer struct {
+	e        *FlukeDatasource
+	config   *cfg.Modbus
+	listener net.Listener
+}
+
+// startModbusServer starts serving the configured register map. The server is stopped when the plugin stops
+func (e *FlukeDatasource) startModbusServer(config *cfg.Modbus) error {
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return err
+	}
+	s := &modbusServer{e: e, config: config, listener: listener}
+	e.Add(1)
+	go func() {
+		defer e.Done()
+		<-e.stopChan
+		listener.Close()
+	}()
+	e.Add(1)
+	go func() {
+		defer e.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serve answers the requests of a client until it disconnects
+func (s *modbusServer) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 7)
+	for {
+		// MBAP header: transaction id, protocol id, length of the unit id and PDU, unit id
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if length < 2 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		// requests for other units are left unanswered like a gateway without that unit would
+		if s.config.UnitID != 0 && int64(header[6]) != s.config.UnitID {
+			continue
+		}
+		response := s.handle(pdu)
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(response)+1))
+		if _, err := conn.Write(append(header, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response PDU to a request PDU
+func (s *modbusServer) handle(pdu []byte) []byte {
+	function := pdu[0]
+	if function != modbusReadHoldingRegisters {
+		return []byte{function | 0x80, modbusIllegalFunction}
+	}
+	if len(pdu) != 5 {
+		return []byte{function | 0x80, modbusIllegalDataValue}
+	}
+	start := binary.BigEndian.Uint16(pdu[1:3])
+	count := binary.BigEndian.Uint16(pdu[3:5])
+	if count == 0 || count > modbusMaxRegisters {
+		return []byte{function | 0x80, modbusIllegalDataValue}
+	}
+	if int(start)+int(count) > 65536 {
+		return []byte{function | 0x80, modbusIllegalDataAddress}
+	}
+	registers := s.registers()
+	response := make([]byte, 2+2*int(count))
+	response[0] = function
+	response[1] = byte(2 * count)
+	for i := 0; i < int(count); i++ {
+		binary.BigEndian.PutUint16(response[2+2*i:], registers[start+uint16(i)])
+	}
+	return response
+}
+
+// registers returns the mapped registers holding the latest channel values. Registers which aren't mapped read as 0
+// and channels without a good numeric value read as NaN or -32768
+func (s *modbusServer) registers() map[uint16]uint16 {
+	latest := s.e.getLatest()
+	registers := make(map[uint16]uint16)
+	for _, r := range s.config.Registers {
+		v := math.NaN()
+		if payload, ok := latest[r.Channel]; ok && !payload.Bad {
+			switch value := payload.Value.(type) {
+			case float64:
+				v = value
+			case int64:
+				v = float64(value)
+			}
+		}
+		address := uint16(r.Address)
+		if r.Type == cfg.ModbusTypeInt16 {
+			scaled := modbusUnavailableInt16
+			scale := r.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			if scaledValue := math.Round(v * scale); !math.IsNaN(scaledValue) {
+				scaled = int16(math.Max(math.MinInt16+1, math.Min(math.MaxInt16, scaledValue)))
+			}
+			registers[address] = uint16(scaled)
+			continue
+		}
+		bits := math.Float32bits(float32(v))
+		registers[address] = uint16(bits >> 16)
+		registers[address+1] = uint16(bits)
+	}
+	return registers
+}
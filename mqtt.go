@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttDisconnectQuiesce uint = 250 // milliseconds
+)
+
+// mqttReading is the payload of the message published for a reading
+type mqttReading struct {
+	Value     interface{} `json:"value"`
+	Unit      string      `json:"unit,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+	Sequence  uint64      `json:"sequence"`
+}
+
+// mqttSink publishes every reading to its own topic on an MQTT broker
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+	retain bool
+}
+
+func init() {
+	RegisterSink("mqtt", func(_ *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.MQTT == nil {
+			return nil, nil
+		}
+		return newMQTTSink(config.MQTT), nil
+	})
+}
+
+// newMQTTSink returns an mqttSink for the configured broker. The connection is made in the background and retried
+// until the broker can be reached, so an unreachable broker doesn't hold up the recording
+func newMQTTSink(config *cfg.MQTT) *mqttSink {
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = pluginName
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(clientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.Printf("Connected to MQTT broker %s", config.Broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Lost connection to MQTT broker %s: %v", config.Broker, err)
+		})
+	client := mqtt.NewClient(options)
+	client.Connect()
+	return &mqttSink{client: client, topic: config.Topic, qos: byte(config.QoS), retain: config.Retain}
+}
+
+// Write publishes the readings of a frame which aren't bad
+func (s *mqttSink) Write(frame *SinkFrame) error {
+	for _, payload := range frame.Frame.Data {
+		if payload.Bad {
+			continue
+		}
+		if reading, ok := frame.Readings[payload.Name]; ok {
+			s.publish(reading, payload.Value, frame.Frame.Sequence, frame.Time)
+		}
+	}
+	return nil
+}
+
+// Flush does nothing as messages are queued by the client until they're sent
+func (s *mqttSink) Flush() error {
+	return nil
+}
+
+// publish publishes a reading, unless its channel is ignored. Messages are published asynchronously and queued
+// while the broker is unreachable
+func (s *mqttSink) publish(reading Reading, value interface{}, sequence uint64, t time.Time) {
+	if reading.Type == "ignore" {
+		return
+	}
+	b, err := json.Marshal(&mqttReading{Value: value, Unit: reading.Unit, Timestamp: t.UnixMilli(), Sequence: sequence})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	topic := strings.NewReplacer("{channel}", reading.Name, "{type}", reading.Type).Replace(s.topic)
+	s.client.Publish(topic, s.qos, s.retain, b)
+}
+
+// Close disconnects from the broker, giving messages in flight a moment to be sent
+func (s *mqttSink) Close() error {
+	s.client.Disconnect(mqttDisconnectQuiesce)
+	return nil
+}
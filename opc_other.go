@@ -0,0 +1,41 @@
+//go:build !windows
+
+package main
+
+import (
+	"time"
+
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+// OPC DA is only available on Windows. Elsewhere the plugin can still be built and tested with simulated, replayed
+// or injected DAQs, but connecting to an OPC server fails
+var ErrOPCUnsupported = bg.Error("OPC servers can only be reached from Windows")
+
+// GetAllTags returns a slice of all detected tags on the given OPC server
+func GetAllTags(serverName, host string) ([]string, error) {
+	return []string{}, ErrOPCUnsupported
+}
+
+// newOPCConnection connects to the given OPC server, adding the given tags to the connection
+func newOPCConnection(serverName, host string, tags []string) (opc.Connection, error) {
+	return nil, ErrOPCUnsupported
+}
+
+// newOPCSubscription subscribes to the data changes of the given tags on the OPC server, which pushes those that
+// changed at most once every rate
+func newOPCSubscription(serverName, host string, tags []string, rate time.Duration, onChange dataChangeFunc) (opcSubscription, error) {
+	return nil, ErrOPCUnsupported
+}
+
+// newOPCGroupReader connects to the given OPC server and adds an OPC group holding the given tags, whose cache the
+// server updates at the given rate
+func newOPCGroupReader(serverName, host string, tags []string, rate time.Duration) (groupReader, error) {
+	return nil, ErrOPCUnsupported
+}
+
+// withDCOMCredentials calls fn with the DCOM connections it makes authenticated with the given credentials
+func withDCOMCredentials(creds dcomCredentials, fn func() error) error {
+	return ErrOPCUnsupported
+}
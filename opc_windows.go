@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"github.com/konimarti/opc"
+)
+
+// GetAllTags returns a slice of all detected tags on the given OPC server
+func GetAllTags(serverName, host string) ([]string, error) {
+	b, err := opc.CreateBrowser(
+		serverName,
+		[]string{host},
+	)
+	if err != nil {
+		return []string{}, err
+	}
+	return opc.CollectTags(b), nil
+}
+
+// newOPCConnection connects to the given OPC server, adding the given tags to the connection
+func newOPCConnection(serverName, host string, tags []string) (opc.Connection, error) {
+	return opc.NewConnection(
+		serverName,
+		[]string{host},
+		tags,
+	)
+}
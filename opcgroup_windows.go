@@ -0,0 +1,454 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"github.com/konimarti/opc"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// the dispid of the DataChange event of an OPC automation group
+	dispidDataChange = 1
+	// the number of arguments of the DataChange event
+	dataChangeArgs = 6
+	// OPCCache, the data source of a SyncRead reading the values the server last updated the group with
+	opcSourceCache = 1
+)
+
+var (
+	// the OPC automation wrappers, in the order the OPC library tries them
+	opcAutomationWrappers = []string{"OPC.Automation.1", "Graybox.OPC.DAWrapper.1"}
+	// DIID_DIOPCGroupEvent, the event interface of an OPC automation group
+	iidOPCGroupEvent        = ole.NewGUID("{28E68F97-8D75-11D1-8DC3-3C302A000000}")
+	modoleaut32             = windows.NewLazySystemDLL("oleaut32.dll")
+	procSafeArrayGetLBound  = modoleaut32.NewProc("SafeArrayGetLBound")
+	procSafeArrayGetUBound  = modoleaut32.NewProc("SafeArrayGetUBound")
+	procSafeArrayGetElement = modoleaut32.NewProc("SafeArrayGetElement")
+	procSafeArrayCreateVec  = modoleaut32.NewProc("SafeArrayCreateVector")
+	procSafeArrayPutElement = modoleaut32.NewProc("SafeArrayPutElement")
+	procSafeArrayDestroy    = modoleaut32.NewProc("SafeArrayDestroy")
+	// the vtable shared by every data change sink, since only so many callbacks can be created
+	dataChangeSinkVtbl = &dispatchVtbl{
+		queryInterface:   syscall.NewCallback(sinkQueryInterface),
+		addRef:           syscall.NewCallback(sinkAddRef),
+		release:          syscall.NewCallback(sinkRelease),
+		getTypeInfoCount: syscall.NewCallback(sinkGetTypeInfoCount),
+		getTypeInfo:      syscall.NewCallback(sinkGetTypeInfo),
+		getIDsOfNames:    syscall.NewCallback(sinkGetIDsOfNames),
+		invoke:           syscall.NewCallback(sinkInvoke),
+	}
+)
+
+// opcGroup is an OPC group of its own on a new connection to an OPC server. It's made through the OPC automation
+// wrapper directly since the OPC library doesn't expose the group of its connections
+type opcGroup struct {
+	server   *ole.IDispatch
+	group    *ole.IDispatch
+	tags     []string         // the tags of the items, by client handle less one
+	handles  map[string]int32 // the server handles of the items by tag
+	syncRead int32            // the dispid of the group's SyncRead
+	point    *ole.IConnectionPoint
+	sink     *dataChangeSink
+	cookie   uint32
+}
+
+// newOPCGroup connects to the given OPC server and adds an active group holding the given tags, which the server
+// updates at the given rate
+func newOPCGroup(serverName, host string, tags []string, rate time.Duration) (_ *opcGroup, err error) {
+	g := &opcGroup{tags: tags, handles: make(map[string]int32, len(tags))}
+	defer func() {
+		if err != nil {
+			g.Close()
+		}
+	}()
+	var unknown *ole.IUnknown
+	for _, wrapper := range opcAutomationWrappers {
+		if unknown, err = oleutil.CreateObject(wrapper); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load the OPC automation wrapper: %v", err)
+	}
+	g.server, err = unknown.QueryInterface(ole.IID_IDispatch)
+	unknown.Release()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = oleutil.CallMethod(g.server, "Connect", serverName, host); err != nil {
+		return nil, fmt.Errorf("could not connect to %s on %s: %v", serverName, host, err)
+	}
+	groups, err := oleutil.GetProperty(g.server, "OPCGroups")
+	if err != nil {
+		return nil, err
+	}
+	defer groups.Clear()
+	group, err := oleutil.CallMethod(groups.ToIDispatch(), "Add")
+	if err != nil {
+		return nil, fmt.Errorf("could not add an OPC group: %v", err)
+	}
+	g.group = group.ToIDispatch()
+	if _, err = oleutil.PutProperty(g.group, "UpdateRate", int32(rate/time.Millisecond)); err != nil {
+		return nil, err
+	}
+	if _, err = oleutil.PutProperty(g.group, "IsActive", true); err != nil {
+		return nil, err
+	}
+	items, err := oleutil.GetProperty(g.group, "OPCItems")
+	if err != nil {
+		return nil, err
+	}
+	defer items.Clear()
+	for i, tag := range tags {
+		item, err := oleutil.CallMethod(items.ToIDispatch(), "AddItem", tag, int32(i+1))
+		if err != nil {
+			return nil, fmt.Errorf("could not add %s to the OPC group: %v", tag, err)
+		}
+		handle, err := oleutil.GetProperty(item.ToIDispatch(), "ServerHandle")
+		item.Clear()
+		if err != nil {
+			return nil, err
+		}
+		g.handles[tag] = int32(handle.Val)
+		handle.Clear()
+	}
+	return g, nil
+}
+
+// newOPCGroupReader connects to the given OPC server and adds an OPC group holding the given tags, whose cache the
+// server updates at the given rate
+func newOPCGroupReader(serverName, host string, tags []string, rate time.Duration) (groupReader, error) {
+	g, err := newOPCGroup(serverName, host, tags, rate)
+	if err != nil {
+		return nil, err
+	}
+	if g.syncRead, err = g.group.GetSingleIDOfName("SyncRead"); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// SyncRead reads the items of the given tags from the server cache in a single call of the group's SyncRead, so
+// that they all come from the same update of the group. go-ole can't pass the arrays of its arguments, so the group
+// is invoked directly. Items the server couldn't read are left without a value
+func (g *opcGroup) SyncRead(tags []string) ([]opc.Item, error) {
+	items := make([]opc.Item, len(tags))
+	if len(tags) == 0 {
+		return items, nil
+	}
+	handles, err := safeArrayCreateVector(ole.VT_I4, 1, uint32(len(tags)))
+	if err != nil {
+		return nil, err
+	}
+	defer safeArrayDestroy(handles)
+	for pos, tag := range tags {
+		handle, ok := g.handles[tag]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the OPC group", tag)
+		}
+		if err := safeArrayPutElement(handles, int32(pos+1), unsafe.Pointer(&handle)); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		values, errs          *ole.SafeArray
+		qualities, timestamps ole.VARIANT
+		result                ole.VARIANT
+		excepInfo             ole.EXCEPINFO
+	)
+	ole.VariantInit(&qualities)
+	ole.VariantInit(&timestamps)
+	ole.VariantInit(&result)
+	// the arguments Source, NumItems, ServerHandles, Values, Errors, Qualities and TimeStamps go in reverse
+	args := []ole.VARIANT{
+		ole.NewVariant(ole.VT_VARIANT|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&timestamps)))),
+		ole.NewVariant(ole.VT_VARIANT|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&qualities)))),
+		ole.NewVariant(ole.VT_ARRAY|ole.VT_I4|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&errs)))),
+		ole.NewVariant(ole.VT_ARRAY|ole.VT_VARIANT|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&values)))),
+		ole.NewVariant(ole.VT_ARRAY|ole.VT_I4|ole.VT_BYREF, int64(uintptr(unsafe.Pointer(&handles)))),
+		ole.NewVariant(ole.VT_I4, int64(len(tags))),
+		ole.NewVariant(ole.VT_I2, opcSourceCache),
+	}
+	params := dispParams{args: &args[0], numArgs: uint32(len(args))}
+	hr, _, _ := syscall.Syscall9(
+		g.group.VTable().Invoke,
+		9,
+		uintptr(unsafe.Pointer(g.group)),
+		uintptr(g.syncRead),
+		uintptr(unsafe.Pointer(ole.IID_NULL)),
+		uintptr(ole.GetUserDefaultLCID()),
+		uintptr(ole.DISPATCH_METHOD),
+		uintptr(unsafe.Pointer(&params)),
+		uintptr(unsafe.Pointer(&result)),
+		uintptr(unsafe.Pointer(&excepInfo)),
+		0,
+	)
+	runtime.KeepAlive(args)
+	defer func() {
+		safeArrayDestroy(values)
+		safeArrayDestroy(errs)
+		_ = ole.VariantClear(&qualities)
+		_ = ole.VariantClear(&timestamps)
+		_ = ole.VariantClear(&result)
+	}()
+	if hr != 0 {
+		return nil, ole.NewErrorWithSubError(hr, excepInfo.String(), excepInfo)
+	}
+	qualityArray, timeArray := variantSafeArray(&qualities), variantSafeArray(&timestamps)
+	if values == nil || errs == nil || qualityArray == nil || timeArray == nil {
+		return nil, fmt.Errorf("the OPC server returned no items")
+	}
+	for pos := range tags {
+		i := int32(pos + 1)
+		var itemErr int32
+		if err := safeArrayGetElement(errs, i, unsafe.Pointer(&itemErr)); err != nil || itemErr != 0 {
+			continue
+		}
+		items[pos] = safeArrayItem(values, qualityArray, timeArray, i)
+	}
+	return items, nil
+}
+
+// subscribe advises a sink of the DataChange events of the group, which calls onChange with the items the server
+// pushes, and has the server start pushing them
+func (g *opcGroup) subscribe(onChange dataChangeFunc) error {
+	unknown, err := g.group.QueryInterface(ole.IID_IConnectionPointContainer)
+	if err != nil {
+		return err
+	}
+	container := (*ole.IConnectionPointContainer)(unsafe.Pointer(unknown))
+	defer container.Release()
+	if err := container.FindConnectionPoint(iidOPCGroupEvent, &g.point); err != nil {
+		return fmt.Errorf("could not find the events of the OPC group: %v", err)
+	}
+	sink := &dataChangeSink{vtbl: dataChangeSinkVtbl, tags: g.tags, onChange: onChange}
+	if g.cookie, err = g.point.Advise((*ole.IUnknown)(unsafe.Pointer(sink))); err != nil {
+		return fmt.Errorf("could not subscribe to the OPC group: %v", err)
+	}
+	// the group keeps the sink reachable for as long as COM may call it
+	g.sink = sink
+	_, err = oleutil.PutProperty(g.group, "IsSubscribed", true)
+	return err
+}
+
+// Close stops the data change events of the group, if subscribed, and disconnects from the OPC server
+func (g *opcGroup) Close() {
+	if g.point != nil {
+		if g.sink != nil {
+			_ = g.point.Unadvise(g.cookie)
+		}
+		g.point.Release()
+	}
+	if g.group != nil {
+		g.group.Release()
+	}
+	if g.server != nil {
+		_, _ = oleutil.CallMethod(g.server, "Disconnect")
+		g.server.Release()
+	}
+}
+
+// newOPCSubscription subscribes to the data changes of the given tags on the OPC server, which pushes those that
+// changed at most once every rate
+func newOPCSubscription(serverName, host string, tags []string, rate time.Duration, onChange dataChangeFunc) (opcSubscription, error) {
+	g, err := newOPCGroup(serverName, host, tags, rate)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.subscribe(onChange); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// dispatchVtbl is the vtable of an IDispatch implemented in Go
+type dispatchVtbl struct {
+	queryInterface   uintptr
+	addRef           uintptr
+	release          uintptr
+	getTypeInfoCount uintptr
+	getTypeInfo      uintptr
+	getIDsOfNames    uintptr
+	invoke           uintptr
+}
+
+// dispParams is the layout of DISPPARAMS, whose fields go-ole doesn't export
+type dispParams struct {
+	args      *ole.VARIANT
+	namedArgs *int32
+	numArgs   uint32
+	numNamed  uint32
+}
+
+// dataChangeSink is the IDispatch receiving the events of an OPC automation group. COM refers to it by a pointer to
+// its vtable, which therefore comes first
+type dataChangeSink struct {
+	vtbl     *dispatchVtbl
+	refs     int32
+	tags     []string
+	onChange dataChangeFunc
+}
+
+// sinkQueryInterface implements IUnknown for the sink, which is only an IDispatch of the group's events
+func sinkQueryInterface(this *dataChangeSink, iid *ole.GUID, object **dataChangeSink) uintptr {
+	if !ole.IsEqualGUID(iid, ole.IID_IUnknown) && !ole.IsEqualGUID(iid, ole.IID_IDispatch) && !ole.IsEqualGUID(iid, iidOPCGroupEvent) {
+		*object = nil
+		return ole.E_NOINTERFACE
+	}
+	sinkAddRef(this)
+	*object = this
+	return ole.S_OK
+}
+
+// sinkAddRef implements IUnknown for the sink. Its memory is managed by Go so the count is only kept for COM
+func sinkAddRef(this *dataChangeSink) uintptr {
+	return uintptr(atomic.AddInt32(&this.refs, 1))
+}
+
+// sinkRelease implements IUnknown for the sink
+func sinkRelease(this *dataChangeSink) uintptr {
+	return uintptr(atomic.AddInt32(&this.refs, -1))
+}
+
+// sinkGetTypeInfoCount implements IDispatch for the sink, which has no type information
+func sinkGetTypeInfoCount(this *dataChangeSink, count *uint32) uintptr {
+	*count = 0
+	return ole.S_OK
+}
+
+// sinkGetTypeInfo implements IDispatch for the sink
+func sinkGetTypeInfo(this *dataChangeSink, index uint32, lcid uint32, info *uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+// sinkGetIDsOfNames implements IDispatch for the sink, whose events are only invoked by dispid
+func sinkGetIDsOfNames(this *dataChangeSink, iid *ole.GUID, names uintptr, count uint32, lcid uint32, ids *int32) uintptr {
+	return ole.E_NOTIMPL
+}
+
+// sinkInvoke implements IDispatch for the sink, passing the items of DataChange events on to its onChange. The
+// other events of the group are ignored
+func sinkInvoke(this *dataChangeSink, dispid int32, iid *ole.GUID, lcid uint32, flags uint16, params *dispParams, result *ole.VARIANT, excepInfo *ole.EXCEPINFO, argErr *uint32) uintptr {
+	if dispid != dispidDataChange || params == nil || params.numArgs != dataChangeArgs {
+		return ole.S_OK
+	}
+	// the arguments TransactionID, NumItems, ClientHandles, ItemValues, Qualities and TimeStamps come in reverse
+	args := unsafe.Slice(params.args, params.numArgs)
+	if items := this.dataChange(&args[3], &args[2], &args[1], &args[0]); len(items) > 0 {
+		this.onChange(items)
+	}
+	return ole.S_OK
+}
+
+// dataChange returns the items of a DataChange event by tag, matched through their client handles
+func (s *dataChangeSink) dataChange(handles, values, qualities, timestamps *ole.VARIANT) map[string]opc.Item {
+	handleArray, valueArray := variantSafeArray(handles), variantSafeArray(values)
+	qualityArray, timeArray := variantSafeArray(qualities), variantSafeArray(timestamps)
+	if handleArray == nil || valueArray == nil || qualityArray == nil || timeArray == nil {
+		return nil
+	}
+	lower, upper, err := safeArrayBounds(handleArray)
+	if err != nil {
+		return nil
+	}
+	items := make(map[string]opc.Item, upper-lower+1)
+	for i := lower; i <= upper; i++ {
+		var handle int32
+		if err := safeArrayGetElement(handleArray, i, unsafe.Pointer(&handle)); err != nil || handle < 1 || int(handle) > len(s.tags) {
+			continue
+		}
+		items[s.tags[handle-1]] = safeArrayItem(valueArray, qualityArray, timeArray, i)
+	}
+	return items
+}
+
+// safeArrayItem returns the item at the given index of the value, quality and timestamp arrays of a group read or
+// event. Elements which can't be read are left unset
+func safeArrayItem(values, qualities, timestamps *ole.SafeArray, i int32) opc.Item {
+	var item opc.Item
+	var value ole.VARIANT
+	if safeArrayGetElement(values, i, unsafe.Pointer(&value)) == nil {
+		item.Value = value.Value()
+		_ = value.Clear()
+	}
+	// qualities are Longs, or Integers for some servers, and only the low word is set for the latter
+	var quality int32
+	if safeArrayGetElement(qualities, i, unsafe.Pointer(&quality)) == nil {
+		item.Quality = int16(quality)
+	}
+	var date float64
+	if safeArrayGetElement(timestamps, i, unsafe.Pointer(&date)) == nil {
+		item.Timestamp, _ = ole.GetVariantDate(math.Float64bits(date))
+	}
+	return item
+}
+
+// variantSafeArray returns the safe array held by a variant, directly or by reference, or nil if it holds none
+func variantSafeArray(v *ole.VARIANT) *ole.SafeArray {
+	switch {
+	case v.VT&ole.VT_ARRAY == 0:
+		return nil
+	case v.VT&ole.VT_BYREF != 0:
+		return **(***ole.SafeArray)(unsafe.Pointer(&v.Val))
+	}
+	return *(**ole.SafeArray)(unsafe.Pointer(&v.Val))
+}
+
+// safeArrayBounds returns the lower and upper bound of a one dimensional safe array. The arrays of OPC automation
+// start at 1
+func safeArrayBounds(array *ole.SafeArray) (int32, int32, error) {
+	var lower, upper int32
+	if hr, _, _ := procSafeArrayGetLBound.Call(uintptr(unsafe.Pointer(array)), 1, uintptr(unsafe.Pointer(&lower))); hr != 0 {
+		return 0, 0, ole.NewError(hr)
+	}
+	if hr, _, _ := procSafeArrayGetUBound.Call(uintptr(unsafe.Pointer(array)), 1, uintptr(unsafe.Pointer(&upper))); hr != 0 {
+		return 0, 0, ole.NewError(hr)
+	}
+	return lower, upper, nil
+}
+
+// safeArrayCreateVector returns a new one dimensional safe array of the given type, length and lower bound
+func safeArrayCreateVector(vt ole.VT, lower int32, length uint32) (*ole.SafeArray, error) {
+	array, _, err := procSafeArrayCreateVec.Call(uintptr(vt), uintptr(lower), uintptr(length))
+	if array == 0 {
+		return nil, err
+	}
+	// the array is allocated by COM rather than Go
+	return *(**ole.SafeArray)(unsafe.Pointer(&array)), nil
+}
+
+// safeArrayPutElement copies v to the element at the given index of a one dimensional safe array
+func safeArrayPutElement(array *ole.SafeArray, i int32, v unsafe.Pointer) error {
+	if hr, _, _ := procSafeArrayPutElement.Call(uintptr(unsafe.Pointer(array)), uintptr(unsafe.Pointer(&i)), uintptr(v)); hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// safeArrayDestroy frees a safe array along with its elements, if it isn't nil
+func safeArrayDestroy(array *ole.SafeArray) {
+	if array != nil {
+		_, _, _ = procSafeArrayDestroy.Call(uintptr(unsafe.Pointer(array)))
+	}
+}
+
+// safeArrayGetElement copies the element at the given index of a one dimensional safe array to v
+func safeArrayGetElement(array *ole.SafeArray, i int32, v unsafe.Pointer) error {
+	if hr, _, _ := procSafeArrayGetElement.Call(uintptr(unsafe.Pointer(array)), uintptr(unsafe.Pointer(&i)), uintptr(v)); hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var (
+	parquetPartExt           = ".part"
+	parquetParallelism int64 = 4
+)
+
+// parquetRow is a reading as stored in a Parquet file, one row per reading so that campaigns can be loaded and
+// pivoted by channel with pandas
+type parquetRow struct {
+	Timestamp int64    `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Channel   string   `parquet:"name=channel, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Value     *float64 `parquet:"name=value, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Text      *string  `parquet:"name=text, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Unit      string   `parquet:"name=unit, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Quality   int32    `parquet:"name=quality, type=INT32"`
+	Sequence  int64    `parquet:"name=sequence, type=INT64"`
+	Bad       bool     `parquet:"name=bad, type=BOOLEAN"`
+}
+
+// parquetSink buffers readings and writes them to a Parquet file per hour or day. The file being written has a
+// .part suffix which is dropped once the period is over or recording is stopped
+type parquetSink struct {
+	dir    string
+	period string
+	file   source.ParquetFile
+	writer *writer.ParquetWriter
+	path   string
+	window time.Time
+}
+
+func init() {
+	RegisterSink("parquet", func(e *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.ParquetDir == "" {
+			return nil, nil
+		}
+		return newParquetSink(e.configRelativePath(config.ParquetDir), config.ParquetPeriod)
+	})
+}
+
+// newParquetSink returns a parquetSink writing files to the given directory, creating it if needed
+func newParquetSink(dir, period string) (*parquetSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &parquetSink{dir: dir, period: period}, nil
+}
+
+// windowStart returns the start of the hour or day t falls in
+func (s *parquetSink) windowStart(t time.Time) time.Time {
+	if s.period == cfg.ParquetPeriodDay {
+		year, month, day := t.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Write adds the readings of a frame, starting a new file first if the frame falls in a new period
+func (s *parquetSink) Write(frame *SinkFrame) error {
+	f, t := frame.Frame, frame.Time
+	window := s.windowStart(t)
+	if s.writer != nil && !window.Equal(s.window) {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	if s.writer == nil {
+		if err := s.open(window); err != nil {
+			return err
+		}
+	}
+	for _, payload := range f.Data {
+		row := parquetRow{
+			Timestamp: t.UnixMilli(),
+			Channel:   payload.Name,
+			Unit:      payload.Unit,
+			Quality:   int32(payload.Quality),
+			Sequence:  int64(f.Sequence),
+			Bad:       payload.Bad,
+		}
+		switch v := payload.Value.(type) {
+		case float64:
+			row.Value = &v
+		case int64:
+			value := float64(v)
+			row.Value = &value
+		case bool:
+			var value float64
+			if v {
+				value = 1
+			}
+			row.Value = &value
+		case string:
+			row.Text = &v
+		}
+		if err := s.writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open starts the file of the period starting at window. A file left from an earlier recording in the same period
+// isn't overwritten
+func (s *parquetSink) open(window time.Time) error {
+	name := fmt.Sprintf("%s-%s.parquet", pluginName, window.Format("20060102T1504"))
+	path := filepath.Join(s.dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = filepath.Join(s.dir, fmt.Sprintf("%s-%d.parquet", strings.TrimSuffix(name, ".parquet"), i))
+	}
+	file, err := local.NewLocalFileWriter(path + parquetPartExt)
+	if err != nil {
+		return err
+	}
+	pw, err := writer.NewParquetWriter(file, new(parquetRow), parquetParallelism)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	s.file, s.writer, s.path, s.window = file, pw, path, window
+	return nil
+}
+
+// Flush writes the rows buffered so far to the current file as a row group
+func (s *parquetSink) Flush() error {
+	if s.writer == nil {
+		return nil
+	}
+	return s.writer.Flush(true)
+}
+
+// Close completes the current file by writing its footer and dropping its .part suffix
+func (s *parquetSink) Close() error {
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.WriteStop()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file, s.writer = nil, nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(s.path+parquetPartExt, s.path)
+}
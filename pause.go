@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Pause suspends a recording without stopping it. The DAQs keep scanning and the connections stay open, but no
+// frames are sent until the recording is resumed
+func (e *FlukeDatasource) Pause() error {
+	if atomic.LoadInt32(&e.recording) == 0 {
+		return ErrNotRecording
+	}
+	if ok := atomic.CompareAndSwapInt32(&e.paused, 0, 1); !ok {
+		return ErrAlreadyPaused
+	}
+	log.Println("Recording paused")
+	return nil
+}
+
+// Resume resumes a paused recording
+func (e *FlukeDatasource) Resume() error {
+	if ok := atomic.CompareAndSwapInt32(&e.paused, 1, 0); !ok {
+		return ErrNotPaused
+	}
+	log.Println("Recording resumed")
+	return nil
+}
+
+// isPaused returns true if the recording is paused
+func (e *FlukeDatasource) isPaused() bool {
+	return atomic.LoadInt32(&e.paused) == 1
+}
+
+// inSchedule returns true if the current time falls within the recording schedule. Changes are logged so that
+// operators can tell why no frames are being sent
+func (e *FlukeDatasource) inSchedule() bool {
+	active := e.getConfig().Schedule.Active(time.Now())
+	var outside int32
+	if !active {
+		outside = 1
+	}
+	if atomic.SwapInt32(&e.unscheduled, outside) != outside {
+		if active {
+			log.Println("Recording schedule window started")
+		} else {
+			log.Println("Outside of the recording schedule, no frames will be sent until the next window")
+		}
+	}
+	return active
+}
+
+// startPauseWatcher starts the background goroutine which pauses the recording while the pause file exists. A
+// relative path is relative to the directory of the config file
+func (e *FlukeDatasource) startPauseWatcher(path string) {
+	e.Add(1)
+	go e.watchPauseFile(e.configRelativePath(path), configWatchInterval)
+}
+
+// watchPauseFile pauses the recording when the given file is created and resumes it once the file is removed
+func (e *FlukeDatasource) watchPauseFile(path string, interval time.Duration) {
+	defer e.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := os.Stat(path)
+			exists := err == nil
+			if exists && !e.isPaused() && atomic.LoadInt32(&e.recording) == 1 {
+				if err := e.Pause(); err != nil {
+					log.Printf("Could not pause recording: %v", err)
+				}
+			} else if !exists && e.isPaused() {
+				if err := e.Resume(); err != nil {
+					log.Printf("Could not resume recording: %v", err)
+				}
+			}
+		case <-e.stopChan:
+			return
+		}
+	}
+}
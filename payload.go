@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	jsonFrameType     = "application/json"
+	protobufFrameType = "application/x-protobuf"
+	csvFrameType      = "text/csv"
+)
+
+// payloadEncoder encodes frames with the configured payload encoding. CSV frames have a column for every channel so
+// a header row is sent first and again whenever the channels change
+type payloadEncoder struct {
+	encoding string
+	compress bool
+	columns  []string
+}
+
+// newPayloadEncoder returns a payloadEncoder for the given payload encoding, optionally gzip compressing payloads
+func newPayloadEncoder(encoding string, compress bool) *payloadEncoder {
+	return &payloadEncoder{encoding: encoding, compress: compress}
+}
+
+// gzipPayload compresses a payload with gzip
+func gzipPayload(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// payloadValue converts an OPC value to the type it's sent as. Floats are sent as float64 and integers as int64 while
+// bools and strings are sent as is. False is returned for any other type
+func payloadValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case float64, bool, string:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int, int8, int16, int32, int64:
+		return reflect.ValueOf(v).Int(), true
+	case uint, uint8, uint16, uint32, uint64:
+		return int64(reflect.ValueOf(v).Uint()), true
+	}
+	return nil, false
+}
+
+// roundValue rounds a float value to the given number of decimal places. Other values are returned unchanged
+func roundValue(value interface{}, places int64) interface{} {
+	v, ok := value.(float64)
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return value
+	}
+	// formatting and parsing back avoids the representation error of multiplying by a power of ten
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(v, 'f', int(places), 64), 64)
+	if err != nil {
+		return value
+	}
+	return rounded
+}
+
+// formatValue formats a payload value for a CSV row
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// appendProtoString appends a string field to a protobuf message, leaving it out if it's blank
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// marshalProto encodes the frame as the FlukeFrame message defined in fluke.proto
+func (f *Frame) marshalProto() []byte {
+	b := protowire.AppendTag(nil, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, f.Sequence)
+	if f.Burst {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	if f.PreTrigger {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	for _, p := range f.Data {
+		var r []byte
+		r = appendProtoString(r, 1, p.Name)
+		switch v := p.Value.(type) {
+		case float64:
+			r = protowire.AppendTag(r, 2, protowire.Fixed64Type)
+			r = protowire.AppendFixed64(r, math.Float64bits(v))
+		case int64:
+			r = protowire.AppendTag(r, 9, protowire.VarintType)
+			r = protowire.AppendVarint(r, uint64(v))
+		case bool:
+			r = protowire.AppendTag(r, 10, protowire.VarintType)
+			r = protowire.AppendVarint(r, protowire.EncodeBool(v))
+		case string:
+			r = protowire.AppendTag(r, 11, protowire.BytesType)
+			r = protowire.AppendString(r, v)
+		}
+		r = appendProtoString(r, 3, p.Unit)
+		r = appendProtoString(r, 4, p.Kind)
+		r = protowire.AppendTag(r, 5, protowire.VarintType)
+		r = protowire.AppendVarint(r, uint64(int64(p.Quality)))
+		r = protowire.AppendTag(r, 6, protowire.VarintType)
+		r = protowire.AppendVarint(r, uint64(p.Timestamp))
+		r = protowire.AppendTag(r, 7, protowire.VarintType)
+		r = protowire.AppendVarint(r, uint64(int64(p.ID)))
+		r = appendProtoString(r, 8, p.OPCTag)
+		if p.Bad {
+			r = protowire.AppendTag(r, 12, protowire.VarintType)
+			r = protowire.AppendVarint(r, protowire.EncodeBool(true))
+		}
+		if p.Stats != nil {
+			r = protowire.AppendTag(r, 13, protowire.BytesType)
+			r = protowire.AppendBytes(r, p.Stats.marshalProto())
+		}
+		if p.Suspect {
+			r = protowire.AppendTag(r, 14, protowire.VarintType)
+			r = protowire.AppendVarint(r, protowire.EncodeBool(true))
+		}
+		r = appendProtoString(r, 15, p.Fault)
+		if p.Raw != nil {
+			r = protowire.AppendTag(r, 16, protowire.Fixed64Type)
+			r = protowire.AppendFixed64(r, math.Float64bits(*p.Raw))
+		}
+		if p.Spike {
+			r = protowire.AppendTag(r, 17, protowire.VarintType)
+			r = protowire.AppendVarint(r, protowire.EncodeBool(true))
+		}
+		if p.Rejected != 0 {
+			r = protowire.AppendTag(r, 18, protowire.VarintType)
+			r = protowire.AppendVarint(r, uint64(p.Rejected))
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, r)
+	}
+	return b
+}
+
+// marshalProto encodes the statistics as the Stats message defined in fluke.proto
+func (s *ChannelStats) marshalProto() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Count))
+	for i, v := range []float64{s.Min, s.Max, s.Mean, s.StdDev} {
+		b = protowire.AppendTag(b, protowire.Number(i+2), protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// marshalCSV encodes the given rows as CSV
+func marshalCSV(rows ...[]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCSV encodes the frame as a CSV row with the reading time and frame sequence number followed by the value of
+// every channel. Channels without a reading in this frame are left blank
+func (p *payloadEncoder) encodeCSV(f *Frame, channels []string, t time.Time) ([][]byte, error) {
+	var payloads [][]byte
+	if !reflect.DeepEqual(channels, p.columns) {
+		header, err := marshalCSV(append([]string{"timestamp", "sequence"}, channels...))
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, header)
+		p.columns = channels
+	}
+	values := make(map[string]interface{}, len(f.Data))
+	for _, payload := range f.Data {
+		values[payload.Name] = payload.Value
+	}
+	row := make([]string, 0, len(channels)+2)
+	row = append(row, strconv.FormatInt(t.UnixMilli(), 10), strconv.FormatUint(f.Sequence, 10))
+	for _, name := range channels {
+		if v, ok := values[name]; ok {
+			row = append(row, formatValue(v))
+		} else {
+			row = append(row, "")
+		}
+	}
+	b, err := marshalCSV(row)
+	if err != nil {
+		return nil, err
+	}
+	return append(payloads, b), nil
+}
+
+// encode encodes the frame and returns the payloads to send along with their frame type. Compressed payloads have
+// +gzip appended to their frame type
+func (p *payloadEncoder) encode(f *Frame, channels []string, t time.Time) ([][]byte, string, error) {
+	payloads, frameType, err := p.encodeFrame(f, channels, t)
+	if err != nil || !p.compress {
+		return payloads, frameType, err
+	}
+	for i, b := range payloads {
+		if payloads[i], err = gzipPayload(b); err != nil {
+			return nil, "", err
+		}
+	}
+	return payloads, frameType + "+gzip", nil
+}
+
+// encodeFrame encodes the frame with the payload encoding. Only CSV can produce more than one payload, when a
+// header row is needed
+func (p *payloadEncoder) encodeFrame(f *Frame, channels []string, t time.Time) ([][]byte, string, error) {
+	switch p.encoding {
+	case cfg.PayloadEncodingProtobuf:
+		return [][]byte{f.marshalProto()}, protobufFrameType, nil
+	case cfg.PayloadEncodingCSV:
+		payloads, err := p.encodeCSV(f, channels, t)
+		return payloads, csvFrameType, err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, "", err
+	}
+	return [][]byte{b}, jsonFrameType, nil
+}
+
+// channelNames returns the names of every recorded channel in the order they are read
+func (e *FlukeDatasource) channelNames() []string {
+	var names []string
+	for _, conn := range e.getConnections() {
+		for _, channel := range conn.channelMetadata() {
+			names = append(names, channel.Name)
+		}
+	}
+	for _, virtual := range e.getConfig().VirtualChannels {
+		names = append(names, virtual.Name)
+	}
+	return names
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields decodes a message into the values of each of its fields, as uint64 for varint and fixed64 fields and
+// as []byte for length delimited ones
+func protoFields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	t.Helper()
+	fields := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("field %d has unexpected wire type %d", num, typ)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields
+}
+
+func TestMarshalProto(t *testing.T) {
+	raw := 21.75
+	// int64 fields are encoded in two's complement rather than zigzag
+	counter := int64(-7)
+	frame := &Frame{
+		Sequence:   42,
+		Burst:      true,
+		PreTrigger: true,
+		Data: []Payload{
+			{
+				Name:      "TC_1",
+				Value:     21.5,
+				Unit:      "degC",
+				Kind:      "temperature",
+				Quality:   192,
+				Timestamp: 1700000000000,
+				ID:        3,
+				OPCTag:    "Fluke.TC_1",
+				Raw:       &raw,
+				Spike:     true,
+				Rejected:  4,
+			},
+			{
+				Name:    "Counter",
+				Value:   counter,
+				Quality: 192,
+				ID:      4,
+				Stats:   &ChannelStats{Count: 5, Min: -1, Max: 2.5, Mean: 0.5, StdDev: 1.25},
+			},
+			{Name: "Valve", Value: true, Quality: 192, ID: 5},
+			{Name: "Mode", Value: "auto", Quality: 24, ID: 6, Bad: true, Suspect: true, Fault: "sensor-failure"},
+		},
+	}
+	fields := protoFields(t, frame.marshalProto())
+	if seq := fields[2]; !reflect.DeepEqual(seq, []interface{}{uint64(42)}) {
+		t.Fatalf("sequence %v, expected 42", seq)
+	}
+	if !reflect.DeepEqual(fields[3], []interface{}{uint64(1)}) || !reflect.DeepEqual(fields[4], []interface{}{uint64(1)}) {
+		t.Fatalf("burst %v and pre_trigger %v, expected both set", fields[3], fields[4])
+	}
+	if len(fields[1]) != len(frame.Data) {
+		t.Fatalf("%d readings, expected %d", len(fields[1]), len(frame.Data))
+	}
+	readings := make([]map[protowire.Number][]interface{}, len(fields[1]))
+	for i, r := range fields[1] {
+		readings[i] = protoFields(t, r.([]byte))
+	}
+
+	tests := []struct {
+		reading int
+		field   protowire.Number
+		want    interface{}
+	}{
+		{0, 1, []byte("TC_1")},
+		{0, 2, math.Float64bits(21.5)},
+		{0, 3, []byte("degC")},
+		{0, 4, []byte("temperature")},
+		{0, 5, uint64(192)},
+		{0, 6, uint64(1700000000000)},
+		{0, 7, uint64(3)},
+		{0, 8, []byte("Fluke.TC_1")},
+		{0, 16, math.Float64bits(21.75)},
+		{0, 17, uint64(1)},
+		{0, 18, uint64(4)},
+		{1, 9, uint64(counter)},
+		{2, 10, uint64(1)},
+		{3, 11, []byte("auto")},
+		{3, 5, uint64(24)},
+		{3, 12, uint64(1)},
+		{3, 14, uint64(1)},
+		{3, 15, []byte("sensor-failure")},
+	}
+	for _, tc := range tests {
+		got := readings[tc.reading][tc.field]
+		if !reflect.DeepEqual(got, []interface{}{tc.want}) {
+			t.Errorf("reading %d field %d is %v, expected %v", tc.reading, tc.field, got, tc.want)
+		}
+	}
+
+	// only one field of the typed_value oneof is set for each reading
+	for i, want := range []protowire.Number{2, 9, 10, 11} {
+		for _, num := range []protowire.Number{2, 9, 10, 11} {
+			if _, ok := readings[i][num]; ok != (num == want) {
+				t.Errorf("reading %d has value field %d set %v, expected only field %d", i, num, ok, want)
+			}
+		}
+	}
+	// raw is optional, so it's only present for channels which have it, and stats and rejected are left out when unset
+	for i := 1; i < len(readings); i++ {
+		for _, num := range []protowire.Number{16, 18} {
+			if _, ok := readings[i][num]; ok {
+				t.Errorf("reading %d has field %d set, expected it to be left out", i, num)
+			}
+		}
+		if _, ok := readings[i][13]; ok != (i == 1) {
+			t.Errorf("reading %d has stats set %v, expected only reading 1 to have them", i, ok)
+		}
+	}
+
+	if len(readings[1][13]) != 1 {
+		t.Fatalf("stats %v, expected one Stats message", readings[1][13])
+	}
+	stats := protoFields(t, readings[1][13][0].([]byte))
+	want := map[protowire.Number][]interface{}{
+		1: {uint64(5)},
+		2: {math.Float64bits(-1)},
+		3: {math.Float64bits(2.5)},
+		4: {math.Float64bits(0.5)},
+		5: {math.Float64bits(1.25)},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("stats %v, expected %v", stats, want)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/lib/pq"
+)
+
+var (
+	defaultPostgresTable               = "fluke_readings"
+	postgresBufferSize   int           = 64
+	postgresCloseTimeout time.Duration = 10 * time.Second
+	postgresSchema                     = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	time     TIMESTAMPTZ NOT NULL,
+	channel  TEXT NOT NULL,
+	value    DOUBLE PRECISION,
+	text     TEXT,
+	unit     TEXT,
+	quality  SMALLINT NOT NULL,
+	sequence BIGINT NOT NULL,
+	bad      BOOLEAN NOT NULL
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (channel, time DESC)`
+)
+
+// postgresBatch is the readings of a frame waiting to be written
+type postgresBatch struct {
+	frame Frame
+	time  time.Time
+}
+
+// postgresSink writes every reading to a PostgreSQL table, one row per reading, which is turned into a hypertable
+// if the TimescaleDB extension is installed. Frames are written in the background so a slow or unreachable server
+// doesn't hold up the recording. Frames are dropped once too many are waiting
+type postgresSink struct {
+	db      *sql.DB
+	table   string
+	index   string
+	batches chan postgresBatch
+	done    chan struct{}
+	ready   bool
+	failing bool
+}
+
+func init() {
+	RegisterSink("postgres", func(_ *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.PostgresDSN == "" {
+			return nil, nil
+		}
+		return newPostgresSink(config.PostgresDSN, config.PostgresTable)
+	})
+}
+
+// newPostgresSink returns a postgresSink for the given DSN and table and starts writing in the background. The
+// table is created once the server can be reached
+func newPostgresSink(dsn, table string) (*postgresSink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if table == "" {
+		table = defaultPostgresTable
+	}
+	s := &postgresSink{
+		db:      db,
+		table:   pq.QuoteIdentifier(table),
+		index:   pq.QuoteIdentifier(table + "_channel_time_idx"),
+		batches: make(chan postgresBatch, postgresBufferSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write queues the readings of a frame
+func (s *postgresSink) Write(frame *SinkFrame) error {
+	select {
+	case s.batches <- postgresBatch{frame: *frame.Frame, time: frame.Time}:
+	default:
+		log.Printf("Dropped frame %d, too many frames are waiting to be written to Postgres", frame.Frame.Sequence)
+	}
+	return nil
+}
+
+// Flush does nothing as frames are written in the background as soon as possible
+func (s *postgresSink) Flush() error {
+	return nil
+}
+
+// run writes the queued frames until the sink is closed. Only the first of consecutive failures is logged
+func (s *postgresSink) run() {
+	defer close(s.done)
+	for batch := range s.batches {
+		err := s.insert(batch)
+		if err != nil && !s.failing {
+			log.Printf("Could not write to Postgres: %v", err)
+		} else if err == nil && s.failing {
+			log.Println("Writing to Postgres recovered")
+		}
+		s.failing = err != nil
+	}
+}
+
+// setup creates the table and its index, turning it into a hypertable if TimescaleDB is installed
+func (s *postgresSink) setup() error {
+	if _, err := s.db.Exec(fmt.Sprintf(postgresSchema, s.table, s.index)); err != nil {
+		return err
+	}
+	var timescale bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&timescale); err != nil {
+		return err
+	}
+	if timescale {
+		if _, err := s.db.Exec("SELECT create_hypertable($1, 'time', if_not_exists => TRUE)", s.table); err != nil {
+			return err
+		}
+	}
+	s.ready = true
+	return nil
+}
+
+// insert writes the readings of a frame in a single transaction
+func (s *postgresSink) insert(batch postgresBatch) error {
+	if !s.ready {
+		if err := s.setup(); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (time, channel, value, text, unit, quality, sequence, bad) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", s.table))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, payload := range batch.frame.Data {
+		var (
+			value interface{}
+			text  interface{}
+		)
+		switch v := payload.Value.(type) {
+		case float64, int64:
+			value = v
+		case bool:
+			value = 0
+			if v {
+				value = 1
+			}
+		case string:
+			text = v
+		}
+		if _, err := stmt.Exec(batch.time, payload.Name, value, text, payload.Unit, payload.Quality, int64(batch.frame.Sequence), payload.Bad); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close writes the frames still waiting and closes the connection. Frames which can't be written in time, e.g.
+// because the server is unreachable, are dropped
+func (s *postgresSink) Close() error {
+	close(s.batches)
+	select {
+	case <-s.done:
+	case <-time.After(postgresCloseTimeout):
+		log.Printf("Dropped %d frames which couldn't be written to Postgres in time", len(s.batches))
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	configWatchInterval time.Duration = 5 * time.Second
+)
+
+// sameDAQs returns true if the two DAQ lists connect to the same OPC servers with the same credentials
+func sameDAQs(a, b []cfg.DAQConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		aServers, aHost := a[i].Server()
+		bServers, bHost := b[i].Server()
+		if a[i].Name != b[i].Name || aHost != bHost || !reflect.DeepEqual(aServers, bServers) {
+			return false
+		}
+		if a[i].Username != b[i].Username || a[i].Password != b[i].Password || a[i].Domain != b[i].Domain {
+			return false
+		}
+	}
+	return true
+}
+
+// keepInfluxConfig copies the Influx settings of the old config into the new one since the Influx client is only
+// created on startup. It returns true if they were different
+func keepInfluxConfig(old, config *cfg.Config) bool {
+	changed := old.Influx != config.Influx ||
+		old.InfluxURL != config.InfluxURL ||
+		old.InfluxAPIToken != config.InfluxAPIToken ||
+		old.InfluxVersion != config.InfluxVersion ||
+		old.InfluxUsername != config.InfluxUsername ||
+		old.InfluxPassword != config.InfluxPassword ||
+		old.InfluxDatabase != config.InfluxDatabase ||
+		old.InfluxRetention != config.InfluxRetention ||
+		old.InfluxOrgName != config.InfluxOrgName ||
+		old.InfluxBucketName != config.InfluxBucketName ||
+		old.InfluxSkipTLS != config.InfluxSkipTLS ||
+		old.InfluxCAFile != config.InfluxCAFile ||
+		old.InfluxCertFile != config.InfluxCertFile ||
+		old.InfluxKeyFile != config.InfluxKeyFile ||
+		old.InfluxBatchSize != config.InfluxBatchSize ||
+		old.InfluxFlushPeriod != config.InfluxFlushPeriod ||
+		old.InfluxExportDir != config.InfluxExportDir ||
+		old.InfluxAggBucket != config.InfluxAggBucket
+	config.Influx = old.Influx
+	config.InfluxURL = old.InfluxURL
+	config.InfluxAPIToken = old.InfluxAPIToken
+	config.InfluxVersion = old.InfluxVersion
+	config.InfluxUsername = old.InfluxUsername
+	config.InfluxPassword = old.InfluxPassword
+	config.InfluxDatabase = old.InfluxDatabase
+	config.InfluxRetention = old.InfluxRetention
+	config.InfluxOrgName = old.InfluxOrgName
+	config.InfluxBucketName = old.InfluxBucketName
+	config.InfluxSkipTLS = old.InfluxSkipTLS
+	config.InfluxCAFile = old.InfluxCAFile
+	config.InfluxCertFile = old.InfluxCertFile
+	config.InfluxKeyFile = old.InfluxKeyFile
+	config.InfluxBatchSize = old.InfluxBatchSize
+	config.InfluxFlushPeriod = old.InfluxFlushPeriod
+	config.InfluxExportDir = old.InfluxExportDir
+	config.InfluxAggBucket = old.InfluxAggBucket
+	return changed
+}
+
+// Reload reads the config file again and applies it. Channel names, tags and the polling interval take effect
+// immediately, even while recording. Changes to the DAQ connection settings are applied the next time recording
+// is started and changes to the Influx settings require a restart of the plugin
+func (e *FlukeDatasource) Reload() error {
+	config, err := cfg.InitConfig(e.configPath)
+	if err != nil {
+		return err
+	}
+	for _, warning := range config.Warnings() {
+		log.Println(warning)
+	}
+	old := e.getConfig()
+	if keepInfluxConfig(old, config) {
+		log.Println("Influx settings changed, restart the plugin for them to take effect")
+	}
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	if e.daqs != nil {
+		if !sameDAQs(old.DAQs, config.DAQs) {
+			if atomic.LoadInt32(&e.recording) == 1 {
+				return ErrReloadWhileRecording
+			}
+			// reconnect using the new settings the next time recording is started
+			for _, daq := range e.daqs {
+				daq.Close()
+			}
+			e.daqs = nil
+		} else if err := e.remapConnections(config); err != nil {
+			return err
+		}
+	}
+	e.configMu.Lock()
+	e.config = config
+	e.configMu.Unlock()
+	// let the recording goroutine pick up the new polling interval
+	select {
+	case e.reloadChan <- struct{}{}:
+	default:
+	}
+	log.Printf("Reloaded config from %s", e.configPath)
+	return nil
+}
+
+// SetProfile switches to the named profile of the config file, or to no profile if blank, by reloading the config
+// with the profile given like the -profile flag. The profile is kept for later reloads until the plugin is restarted
+// and the previous one is restored if the config can't be reloaded with it
+func (e *FlukeDatasource) SetProfile(name string) error {
+	previous, set := os.LookupEnv(profileEnv)
+	os.Setenv(profileEnv, name)
+	if err := e.Reload(); err != nil {
+		if set {
+			os.Setenv(profileEnv, previous)
+		} else {
+			os.Unsetenv(profileEnv)
+		}
+		return err
+	}
+	log.Printf("Switched to profile %q", name)
+	return nil
+}
+
+// remapConnections replaces the TagMap of every connection with one built from the given config, along with the OPC
+// group of those using GroupRead when their channels changed. Nothing is replaced unless every DAQ's tags are valid
+// and every group could be made
+func (e *FlukeDatasource) remapConnections(config *cfg.Config) (err error) {
+	conns := connectionsOf(e.daqs)
+	tagMaps := make([]map[int]Tag, len(conns))
+	missing := make([][]string, len(conns))
+	for i, conn := range conns {
+		conn.tagMu.RLock()
+		tags := conn.Tags
+		conn.tagMu.RUnlock()
+		if err := validateTagIndices(tags, config.DAQs[i].FlukeTags); err != nil {
+			return fmt.Errorf("%s: %w", conn.Name, err)
+		}
+		tagMaps[i], missing[i] = createTagMap(tags, config.DAQs[i].FlukeTags)
+	}
+	groups := make([]groupReader, len(conns))
+	defer func() {
+		if err != nil {
+			for _, group := range groups {
+				if group != nil {
+					group.Close()
+				}
+			}
+		}
+	}()
+	for i, conn := range conns {
+		if groups[i], err = conn.regroup(tagMaps[i], pollingInterval(config)); err != nil {
+			return err
+		}
+	}
+	for i, conn := range conns {
+		conn.tagMu.Lock()
+		conn.TagMap = tagMaps[i]
+		if groups[i] != nil {
+			conn.retired = append(conn.retired, conn.group)
+			conn.group = groups[i]
+		}
+		conn.tagMu.Unlock()
+		if len(missing[i]) == 0 {
+			continue
+		}
+		log.Printf("%s: channels not found on OPC server: %s", conn.Name, strings.Join(missing[i], ", "))
+		select {
+		case <-conn.missingChan:
+		default:
+		}
+		conn.missingChan <- missing[i]
+	}
+	return nil
+}
+
+// startConfigWatcher starts the background goroutine which reloads the config file when it is modified
+func (e *FlukeDatasource) startConfigWatcher() {
+	e.Add(1)
+	go e.watchConfig(configWatchInterval)
+}
+
+// watchConfig checks the modification time of the config file on every tick and reloads it if it has changed
+func (e *FlukeDatasource) watchConfig(interval time.Duration) {
+	defer e.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var modTime time.Time
+	if info, err := os.Stat(e.configPath); err == nil {
+		modTime = info.ModTime()
+	}
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(e.configPath)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			if err := e.Reload(); err != nil {
+				log.Printf("Could not reload config: %v", err)
+			}
+		case <-e.stopChan:
+			return
+		}
+	}
+}
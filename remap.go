@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+var (
+	minRemapInterval time.Duration = 1 * time.Minute
+	errorFrameType                 = "application/x-fluke-error"
+)
+
+type ErrorPayload struct {
+	Error    string   `json:"error"`
+	DAQ      string   `json:"daq"`
+	Channels []string `json:"channels"`
+}
+
+// checkForMissingTags browses the OPC server again in the background to find out if any mapped tags were removed.
+// Channels stay mapped to their OPC tag by name, so those which can no longer be found are dropped from the TagMap
+// and reported on the missing channel
+func (d *DAQConnection) checkForMissingTags() {
+	// simulated, replayed and golden DAQs have no OPC server to browse
+	if d.ServerName == simulatedServerName || d.ServerName == replayServerName || d.ServerName == goldenServerName {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&d.remapping, 0, 1) {
+		return
+	}
+	if time.Since(d.lastRemap) < minRemapInterval {
+		atomic.StoreInt32(&d.remapping, 0)
+		return
+	}
+	d.lastRemap = time.Now()
+	go func() {
+		defer atomic.StoreInt32(&d.remapping, 0)
+		var tags []string
+		err := d.credentials.run(func() (err error) {
+			tags, err = getTags(d.ServerName, d.Host, 0, true)
+			return err
+		})
+		if err != nil {
+			log.Printf("%s: could not browse OPC server: %v", d.Name, err)
+			return
+		}
+		browsed := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			browsed[tag] = true
+		}
+		tagMap := d.GetTagMap()
+		newTagMap := make(map[int]Tag, len(tagMap))
+		var missing []string
+		for i, tag := range tagMap {
+			if !browsed[tag.tag] {
+				missing = append(missing, tag.name)
+				continue
+			}
+			newTagMap[i] = tag
+		}
+		if len(missing) == 0 {
+			return
+		}
+		sort.Strings(missing)
+		log.Printf("%s: channels removed from OPC server: %s", d.Name, strings.Join(missing, ", "))
+		d.tagMu.Lock()
+		d.Tags = tags
+		d.TagMap = newTagMap
+		d.tagMu.Unlock()
+		// keep any missing channels which haven't been reported yet
+		select {
+		case prev := <-d.missingChan:
+			missing = append(prev, missing...)
+		default:
+		}
+		d.missingChan <- missing
+	}()
+}
+
+// missingTagFrames returns an error frame for every DAQ with channels which couldn't be found on its OPC server
+func (e *FlukeDatasource) missingTagFrames() []*proto.Frame {
+	var frames []*proto.Frame
+	for _, conn := range e.getConnections() {
+		select {
+		case missing := <-conn.missingChan:
+			b, err := json.Marshal(&ErrorPayload{
+				Error:    "channels not found on OPC server",
+				DAQ:      conn.Name,
+				Channels: missing,
+			})
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			frames = append(frames, &proto.Frame{
+				Source:    e.frameSource(),
+				Type:      errorFrameType,
+				Timestamp: time.Now().UnixMilli(),
+				Payload:   b,
+			})
+		default:
+		}
+	}
+	return frames
+}
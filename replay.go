@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	replayServerName       = "Replay"
+	ErrEmptyReplay         = bg.Error("replay file has no readings")
+	ErrReplayMissingHeader = bg.Error("replay CSV file has rows before its header row")
+)
+
+// recordedValue is the value of a channel at a point in a recording
+type recordedValue struct {
+	time  int64
+	value interface{}
+}
+
+// recording holds the values of every channel of a recording in time order. Times are in milliseconds
+type recording struct {
+	channels map[string][]recordedValue
+	start    int64
+	end      int64
+}
+
+// loadRecording reads a recording from a CSV log or a JSON file of data frames, going by its extension
+func loadRecording(path string) (*recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &recording{channels: make(map[string][]recordedValue)}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = r.readCSV(f)
+	} else {
+		err = r.readJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(r.channels) == 0 {
+		return nil, ErrEmptyReplay
+	}
+	r.start, r.end = math.MaxInt64, math.MinInt64
+	for _, values := range r.channels {
+		sort.SliceStable(values, func(i, j int) bool { return values[i].time < values[j].time })
+		if values[0].time < r.start {
+			r.start = values[0].time
+		}
+		if last := values[len(values)-1].time; last > r.end {
+			r.end = last
+		}
+	}
+	return r, nil
+}
+
+// add adds the value of a channel at the given time
+func (r *recording) add(channel string, t int64, value interface{}) {
+	r.channels[channel] = append(r.channels[channel], recordedValue{time: t, value: value})
+}
+
+// readCSV reads a CSV log, which starts with a header row repeated whenever the channels change. Empty cells are
+// channels which weren't read
+func (r *recording) readCSV(f io.Reader) error {
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var header []string
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) > 0 && row[0] == "timestamp" {
+			header = row
+			continue
+		}
+		if header == nil {
+			return ErrReplayMissingHeader
+		}
+		t, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		for i := 2; i < len(row) && i < len(header); i++ {
+			if row[i] != "" {
+				r.add(header[i], t, parseRecordedValue(row[i]))
+			}
+		}
+	}
+}
+
+// readJSON reads data frames encoded as JSON one after the other, taking the time of each value from its payload
+func (r *recording) readJSON(f io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var frame Frame
+		err := dec.Decode(&frame)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, payload := range frame.Data {
+			if payload.Value != nil {
+				r.add(payload.Name, payload.Timestamp, payload.Value)
+			}
+		}
+	}
+}
+
+// parseRecordedValue parses a CSV cell as a number or bool if it is one, and keeps it as a string otherwise
+func parseRecordedValue(s string) interface{} {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v
+	}
+	if v, err := strconv.ParseBool(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// at returns the value of the channel at the given time in the recording, which is its latest value up to then
+func (r *recording) at(channel string, t int64) (interface{}, bool) {
+	values := r.channels[channel]
+	i := sort.Search(len(values), func(i int) bool { return values[i].time > t })
+	if i == 0 {
+		return nil, false
+	}
+	return values[i-1].value, true
+}
+
+// replayConnection plays back a recording in place of an OPC server, mapping the channels of the recording to those
+// of the DAQ by name
+type replayConnection struct {
+	recording *recording
+	tags      []string
+	channels  map[string]string
+	speed     float64
+	loop      bool
+	started   time.Time
+	done      bool
+	mu        sync.Mutex
+}
+
+// position returns the time in the recording being played back, and marks the replay as done once it's past the end
+// of a recording which isn't looped
+func (c *replayConnection) position() int64 {
+	elapsed := int64(float64(time.Since(c.started).Milliseconds()) * c.speed)
+	length := c.recording.end - c.recording.start
+	if elapsed <= length {
+		return c.recording.start + elapsed
+	}
+	if c.loop {
+		return c.recording.start + elapsed%(length+1)
+	}
+	c.done = true
+	return c.recording.end
+}
+
+// finished returns true once a recording which isn't looped has been played back to the end
+func (c *replayConnection) finished() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Add implements the opc.Connection interface
+func (c *replayConnection) Add(...string) error {
+	return nil
+}
+
+// Remove implements the opc.Connection interface
+func (c *replayConnection) Remove(string) {}
+
+// Read implements the opc.Connection interface
+func (c *replayConnection) Read() map[string]opc.Item {
+	items := make(map[string]opc.Item, len(c.tags))
+	for _, tag := range c.tags {
+		items[tag] = c.ReadItem(tag)
+	}
+	return items
+}
+
+// ReadItem implements the opc.Connection interface. Channels without a value in the recording so far read with bad
+// quality, while the scan control tag reads as good so that health checks pass
+func (c *replayConnection) ReadItem(tag string) opc.Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	channel, ok := c.channels[tag]
+	if !ok {
+		return opc.Item{Value: true, Quality: opc.OPCQualityGood, Timestamp: now}
+	}
+	value, ok := c.recording.at(channel, c.position())
+	if !ok {
+		return opc.Item{Value: math.NaN(), Quality: opc.OPCQualityBad, Timestamp: now}
+	}
+	return opc.Item{Value: value, Quality: opc.OPCQualityGood, Timestamp: now}
+}
+
+// Tags implements the opc.Connection interface
+func (c *replayConnection) Tags() []string {
+	return c.tags
+}
+
+// Write implements the opc.Connection interface. Writes, like those to the scan control tag, are accepted and ignored
+func (c *replayConnection) Write(string, interface{}) error {
+	return nil
+}
+
+// Close implements the opc.Connection interface
+func (c *replayConnection) Close() {}
+
+// connectReplay loads the recording to replay and returns a DAQ connection playing it back for every DAQ. The
+// recorded values were sent in engineering units so none of the conversions of the channels are applied again
+func (e *FlukeDatasource) connectReplay(config *cfg.Config) ([]*DAQConnection, error) {
+	rec, err := loadRecording(e.configRelativePath(config.Replay.File))
+	if err != nil {
+		return nil, err
+	}
+	speed := config.Replay.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	started := time.Now()
+	conns := make([]*DAQConnection, 0, len(config.DAQs))
+	for _, daqCfg := range config.DAQs {
+		tags := simulatedTags(daqCfg)
+		c := &replayConnection{
+			recording: rec,
+			tags:      tags,
+			channels:  make(map[string]string),
+			speed:     speed,
+			loop:      config.Replay.Loop,
+			started:   started,
+		}
+		for i, cfgTag := range daqCfg.FlukeTags {
+			if i != 0 {
+				c.channels[tags[i]] = cfgTag.Tag
+			}
+		}
+		conn := newStandInConnection(daqCfg, config, replayServerName, c, tags)
+		for i, tag := range conn.TagMap {
+			tag.scale, tag.offset, tag.calib, tag.linearize, tag.toUnit = 0, 0, nil, nil, nil
+			conn.TagMap[i] = tag
+		}
+		log.Printf("%s: replaying %s at %gx", conn.Name, config.Replay.File, speed)
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// replayFinished returns true once the replayed recording has been played back to the end
+func (e *FlukeDatasource) replayFinished() bool {
+	for _, conn := range e.getConnections() {
+		c := conn.Connection
+		// the replay can have faults injected into it
+		if faulty, ok := c.(*faultyConnection); ok {
+			c = faulty.Connection
+		}
+		if c, ok := c.(*replayConnection); ok && c.finished() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	ole "github.com/go-ole/go-ole"
+)
+
+var (
+	scanWriteAttempts                = 5
+	scanWriteBackoff   time.Duration = 500 * time.Millisecond
+	fatalOPCWriteCodes               = map[uint32]bool{
+		0xC0040001: true, // OPC_E_INVALIDHANDLE
+		0xC0040004: true, // OPC_E_BADTYPE
+		0xC0040006: true, // OPC_E_BADRIGHTS
+		0xC0040007: true, // OPC_E_UNKNOWNITEMID
+		0xC0040008: true, // OPC_E_INVALIDITEMID
+	}
+)
+
+// isFatalWriteError returns true for write errors which won't go away by retrying, like a bad tag
+func isFatalWriteError(err error) bool {
+	if errors.Is(err, ErrScanTagNotFound) {
+		return true
+	}
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return false
+	}
+	if fatalOPCWriteCodes[uint32(oleErr.Code())] {
+		return true
+	}
+	// exceptions raised by the OPC automation wrapper carry the OPC error code
+	if excep, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		return fatalOPCWriteCodes[excep.SCODE()]
+	}
+	return false
+}
+
+// writeScanTag writes to the scan control tag of the DAQ, retrying transient failures with an exponential backoff
+func (d *DAQConnection) writeScanTag(value bool) error {
+	scanTag, ok := d.GetTagMap()[0]
+	if !ok {
+		return ErrScanTagNotFound
+	}
+	var found bool
+	for _, tag := range d.Connection.Tags() {
+		if tag == scanTag.tag {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrScanTagNotFound
+	}
+	backoff := scanWriteBackoff
+	var err error
+	for i := 1; i <= scanWriteAttempts; i++ {
+		err = d.Write(scanTag.tag, value)
+		if err == nil || isFatalWriteError(err) {
+			return err
+		}
+		log.Printf("%s: could not write scan tag (attempt %d of %d): %v", d.Name, i, scanWriteAttempts, err)
+		if i < scanWriteAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
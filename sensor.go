@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	// Callendar-Van Dusen coefficients of IEC 60751 platinum RTDs
+	rtdA = 3.9083e-3
+	rtdB = -5.775e-7
+	rtdC = -4.183e-12
+)
+
+// sensorLinearization returns the function linearizing the raw readings of a sensor type, or nil if it has none.
+// Thermocouples are taken to be referenced to 0 °C. Readings out of the range of the sensor are linearized to NaN so
+// that they're treated as bad values
+func sensorLinearization(sensor string) func(float64) float64 {
+	switch sensor {
+	case cfg.SensorThermocoupleK:
+		return thermocoupleTypes[cfg.ThermocoupleK].linearize
+	case cfg.SensorThermocoupleJ:
+		return thermocoupleTypes[cfg.ThermocoupleJ].linearize
+	case cfg.SensorThermocoupleT:
+		return thermocoupleTypes[cfg.ThermocoupleT].linearize
+	case cfg.SensorPT100:
+		return func(ohms float64) float64 { return rtdTemperature(100, ohms) }
+	case cfg.SensorPT1000:
+		return func(ohms float64) float64 { return rtdTemperature(1000, ohms) }
+	case cfg.SensorPKR251:
+		return pkr251Pressure
+	}
+	return nil
+}
+
+// linearize returns the temperature, in °C, of a thermocouple EMF in mV referenced to 0 °C
+func (tc thermocoupleType) linearize(emf float64) float64 {
+	t, ok := tc.temperature(emf)
+	if !ok {
+		return math.NaN()
+	}
+	return t
+}
+
+// rtdResistance returns the resistance, in ohms, of a platinum RTD with resistance r0 at 0 °C at the given temperature
+func rtdResistance(r0, t float64) float64 {
+	r := 1 + rtdA*t + rtdB*t*t
+	if t < 0 {
+		r += rtdC * (t - 100) * t * t * t
+	}
+	return r0 * r
+}
+
+// rtdTemperature returns the temperature, in °C, of a platinum RTD with resistance r0 at 0 °C from its resistance in
+// ohms, over the range of -200 to 850 °C. Above 0 °C the Callendar-Van Dusen equation is solved directly, below it's
+// found by bisection
+func rtdTemperature(r0, ohms float64) float64 {
+	if ohms < rtdResistance(r0, -200) || ohms > rtdResistance(r0, 850) {
+		return math.NaN()
+	}
+	if ohms >= r0 {
+		return (-rtdA + math.Sqrt(rtdA*rtdA-4*rtdB*(1-ohms/r0))) / (2 * rtdB)
+	}
+	low, high := -200.0, 0.0
+	for i := 0; i < 64 && high-low > 1e-9; i++ {
+		mid := (low + high) / 2
+		if rtdResistance(r0, mid) < ohms {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// pkr251Pressure returns the pressure, in mbar, of a Pfeiffer PKR 251 full range gauge from its output in V. Outputs
+// below 1.82 V or above 8.6 V signal a gauge error
+func pkr251Pressure(volts float64) float64 {
+	if volts < 1.82 || volts > 8.6 {
+		return math.NaN()
+	}
+	return math.Pow(10, 1.667*volts-11.33)
+}
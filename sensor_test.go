@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// the resistances of the IEC 60751 reference tables, rounded to the 0.01 Ω they're published with
+func TestRTDTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		r0   float64
+		ohms float64
+		want float64
+	}{
+		{"PT100 at 0 °C", 100, 100, 0},
+		{"PT100 at 100 °C", 100, 138.51, 100},
+		{"PT100 at 850 °C", 100, 390.48, 850},
+		{"PT100 at -50 °C", 100, 80.31, -50},
+		{"PT100 at -200 °C", 100, 18.52, -200},
+		{"PT1000 at 100 °C", 1000, 1385.06, 100},
+		{"PT1000 at -100 °C", 1000, 602.56, -100},
+		{"PT100 open circuit", 100, 1e6, math.NaN()},
+		{"PT100 short circuit", 100, 0, math.NaN()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := rtdTemperature(tc.r0, tc.ohms)
+			if math.IsNaN(tc.want) {
+				if !math.IsNaN(got) {
+					t.Fatalf("temperature %v °C, expected NaN", got)
+				}
+				return
+			}
+			// 0.01 Ω is about 0.03 °C for a PT100
+			if math.Abs(got-tc.want) > 0.05 {
+				t.Fatalf("temperature %v °C, expected %v °C", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSensorLinearizationBadValues(t *testing.T) {
+	tag := Tag{scale: 1, linearize: sensorLinearization(cfg.SensorPT100)}
+	// out of range readings become bad values, and overloads stay bad rather than being linearized
+	for _, ohms := range []float64{1e6, 0, overRangeValue} {
+		if v := tag.convert(ohms); !isBadValue(v) {
+			t.Fatalf("%v ohms converted to %v, expected a bad value", ohms, v)
+		}
+	}
+	if v := tag.convert(138.51); isBadValue(v) {
+		t.Fatalf("138.51 ohms converted to a bad value, expected 100 °C")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	bg "github.com/SSSOCPaulCote/blunderguard"
+	"github.com/konimarti/opc"
+)
+
+var (
+	ErrTraceChannelNotFound = bg.Error("channel not found in the recording of a trace waveform")
+	simulatedServerName     = "Simulated"
+	simulatedHost           = "localhost"
+	// simulated pressure channels pump down from atmosphere with this time constant to a base pressure
+	simulatedPumpDown           = 300 * time.Second
+	simulatedAtmosphere float64 = 1013
+	simulatedBase       float64 = 1e-6
+)
+
+// simulatedChannel is the state of a simulated channel
+type simulatedChannel struct {
+	kind     string
+	toUnit   func(float64) float64
+	phase    float64
+	drift    float64
+	digital  bool
+	waveform *simulatedWaveform
+}
+
+// simulatedWaveform generates the configured waveform of a channel. A trace is held as the times, in milliseconds
+// from its start, at which each of its values begins along with the length after which it starts over
+type simulatedWaveform struct {
+	config cfg.Waveform
+	noise  *rand.Rand
+	times  []int64
+	values []float64
+	length int64
+}
+
+// simulatedConnection fabricates readings for the configured channels in place of an OPC server. Temperatures drift
+// slowly around room temperature, pressures decay noisily from atmosphere and voltages hover around 1 V, each in the
+// unit the channel is measured in
+type simulatedConnection struct {
+	tags     []string
+	channels map[string]*simulatedChannel
+	started  time.Time
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+// simulatedTags returns the tags of an OPC server standing in for the DAQ, laid out so that every channel is mapped
+// to a tag
+func simulatedTags(daqCfg cfg.DAQConfig) []string {
+	size := 0
+	for i := range daqCfg.FlukeTags {
+		if i >= size {
+			size = i + 1
+		}
+	}
+	tags := make([]string, size)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("Simulated.Channel%03d", i)
+	}
+	for i, cfgTag := range daqCfg.FlukeTags {
+		switch {
+		case cfg.IsTagPattern(cfgTag.OPCTag):
+			// wildcards are filled in with the index so that the pattern matches
+			tags[i] = strings.NewReplacer("*", strconv.Itoa(i), "?", "0").Replace(cfgTag.OPCTag)
+		case cfgTag.OPCTag != "":
+			tags[i] = cfgTag.OPCTag
+		}
+	}
+	return tags
+}
+
+// newSimulatedConnection returns a simulated connection for the channels of the DAQ along with the tags it has
+func newSimulatedConnection(daqCfg cfg.DAQConfig) (*simulatedConnection, []string, error) {
+	tags := simulatedTags(daqCfg)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	channels := make(map[string]*simulatedChannel)
+	recordings := make(map[string]*recording)
+	for i, cfgTag := range daqCfg.FlukeTags {
+		if i == 0 {
+			continue
+		}
+		c := newSimulatedChannel(cfgTag, rng)
+		if cfgTag.Waveform != nil {
+			w, err := newSimulatedWaveform(cfgTag, recordings)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", cfgTag.Tag, err)
+			}
+			c.waveform = w
+		}
+		channels[tags[i]] = c
+	}
+	return &simulatedConnection{tags: tags, channels: channels, started: time.Now(), rng: rng}, tags, nil
+}
+
+// newSimulatedChannel returns the state of a simulated channel. Channels without a Kind are simulated by the kind of
+// their unit
+func newSimulatedChannel(cfgTag cfg.CfgTag, rng *rand.Rand) *simulatedChannel {
+	unit := cfgTag.MeasuredUnit()
+	c := &simulatedChannel{kind: cfgTag.Kind, phase: rng.Float64() * 2 * math.Pi}
+	for kind, from := range map[string]string{cfg.KindTemperature: "degC", cfg.KindPressure: "mbar"} {
+		if c.kind != "" && c.kind != kind {
+			continue
+		}
+		if toUnit, err := cfg.UnitConversion(from, unit); err == nil {
+			c.kind, c.toUnit = kind, toUnit
+			return c
+		}
+	}
+	if c.kind == "" {
+		c.kind = cfg.KindVoltage
+	}
+	c.toUnit = func(v float64) float64 { return v }
+	return c
+}
+
+// newSimulatedWaveform returns the waveform of a channel. Recordings played back as traces are loaded once for every
+// channel of the DAQ they're used by
+func newSimulatedWaveform(cfgTag cfg.CfgTag, recordings map[string]*recording) (*simulatedWaveform, error) {
+	w := &simulatedWaveform{config: *cfgTag.Waveform, noise: rand.New(rand.NewSource(cfgTag.Waveform.Seed))}
+	if w.config.Shape != cfg.WaveformTrace {
+		return w, nil
+	}
+	interval := int64(1000)
+	if w.config.Interval > 0 {
+		interval = int64(math.Max(w.config.Interval*1000, 1))
+	}
+	if w.config.File == "" {
+		for i, v := range w.config.Values {
+			w.times = append(w.times, int64(i)*interval)
+			w.values = append(w.values, v)
+		}
+		w.length = int64(len(w.values)) * interval
+		return w, nil
+	}
+	r, ok := recordings[w.config.File]
+	if !ok {
+		var err error
+		if r, err = loadRecording(w.config.File); err != nil {
+			return nil, err
+		}
+		recordings[w.config.File] = r
+	}
+	channel := w.config.Channel
+	if channel == "" {
+		channel = cfgTag.Tag
+	}
+	recorded := r.channels[channel]
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrTraceChannelNotFound, channel)
+	}
+	for _, rv := range recorded {
+		v := math.NaN()
+		switch value := rv.value.(type) {
+		case float64:
+			v = value
+		case bool:
+			if v = 0; value {
+				v = 1
+			}
+		}
+		w.times = append(w.times, rv.time-recorded[0].time)
+		w.values = append(w.values, v)
+	}
+	// the last value is held for an interval before the trace starts over
+	w.length = w.times[len(w.times)-1] + interval
+	return w, nil
+}
+
+// value returns the value of the waveform at the given time, in seconds, since the connection was made
+func (w *simulatedWaveform) value(t float64) float64 {
+	c := w.config
+	if c.Period > 0 && (c.Shape == cfg.WaveformRamp || c.Shape == cfg.WaveformStep) {
+		t = math.Mod(t, c.Period)
+	}
+	var v float64
+	switch c.Shape {
+	case cfg.WaveformSine:
+		v = c.Level + c.Amplitude*math.Sin(2*math.Pi*t/c.Period)
+	case cfg.WaveformRamp:
+		v = c.Level + c.Rate*t
+	case cfg.WaveformStep:
+		v = c.Level
+		if t >= c.At {
+			v += c.Amplitude
+		}
+	case cfg.WaveformTrace:
+		ms := int64(t*1000) % w.length
+		i := sort.Search(len(w.times), func(i int) bool { return w.times[i] > ms })
+		v = w.values[i-1]
+	default:
+		v = c.Level
+	}
+	if c.Noise > 0 {
+		v += w.noise.NormFloat64() * c.Noise
+	}
+	return v
+}
+
+// value returns the next value of the channel at the given time since the connection was made. Channels with a
+// waveform read it as is, in the unit they're measured in
+func (c *simulatedChannel) value(rng *rand.Rand, elapsed time.Duration) interface{} {
+	t := elapsed.Seconds()
+	if c.waveform != nil {
+		return c.waveform.value(t)
+	}
+	switch c.kind {
+	case cfg.KindTemperature:
+		c.drift += rng.NormFloat64() * 0.01
+		return c.toUnit(22 + 3*math.Sin(2*math.Pi*t/3600+c.phase) + c.drift + rng.NormFloat64()*0.05)
+	case cfg.KindPressure:
+		p := (simulatedAtmosphere-simulatedBase)*math.Exp(-t/simulatedPumpDown.Seconds()) + simulatedBase
+		return c.toUnit(p * (1 + rng.NormFloat64()*0.02))
+	case cfg.KindDigital:
+		if rng.Float64() < 0.01 {
+			c.digital = !c.digital
+		}
+		if c.digital {
+			return float64(1)
+		}
+		return float64(0)
+	}
+	return 1 + 0.1*math.Sin(2*math.Pi*t/60+c.phase) + rng.NormFloat64()*0.005
+}
+
+// Add implements the opc.Connection interface
+func (s *simulatedConnection) Add(...string) error {
+	return nil
+}
+
+// Remove implements the opc.Connection interface
+func (s *simulatedConnection) Remove(string) {}
+
+// Read implements the opc.Connection interface
+func (s *simulatedConnection) Read() map[string]opc.Item {
+	items := make(map[string]opc.Item, len(s.tags))
+	for _, tag := range s.tags {
+		items[tag] = s.ReadItem(tag)
+	}
+	return items
+}
+
+// ReadItem implements the opc.Connection interface. The scan control tag reads as good so that health checks pass
+func (s *simulatedConnection) ReadItem(tag string) opc.Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	channel, ok := s.channels[tag]
+	if !ok {
+		return opc.Item{Value: true, Quality: opc.OPCQualityGood, Timestamp: now}
+	}
+	return opc.Item{Value: channel.value(s.rng, now.Sub(s.started)), Quality: opc.OPCQualityGood, Timestamp: now}
+}
+
+// Tags implements the opc.Connection interface
+func (s *simulatedConnection) Tags() []string {
+	return s.tags
+}
+
+// Write implements the opc.Connection interface. Writes, like those to the scan control tag, are accepted and ignored
+func (s *simulatedConnection) Write(string, interface{}) error {
+	return nil
+}
+
+// Close implements the opc.Connection interface
+func (s *simulatedConnection) Close() {}
+
+// connectSimulated returns a DAQ connection to a simulated OPC server for the channels of the DAQ. The simulated
+// readings are already in engineering units so the Scale, Offset and Sensor of the channels aren't applied
+func connectSimulated(daqCfg cfg.DAQConfig, config *cfg.Config) (*DAQConnection, error) {
+	c, tags, err := newSimulatedConnection(daqCfg)
+	if err != nil {
+		return nil, err
+	}
+	conn := newStandInConnection(daqCfg, config, simulatedServerName, c, tags)
+	for i, tag := range conn.TagMap {
+		tag.scale, tag.offset, tag.linearize = 0, 0, nil
+		conn.TagMap[i] = tag
+	}
+	log.Printf("%s: simulating %d channels", conn.Name, len(conn.TagMap)-1)
+	return conn, nil
+}
+
+// newStandInConnection returns a DAQ connection to a connection standing in for the OPC server of the DAQ, under
+// the given server name
+func newStandInConnection(daqCfg cfg.DAQConfig, config *cfg.Config, serverName string, c opc.Connection, tags []string) *DAQConnection {
+	name := daqCfg.Name
+	if name == "" {
+		name = fmt.Sprintf("%s@%s", serverName, simulatedHost)
+	}
+	tagMap, _ := createTagMap(tags, daqCfg.FlukeTags)
+	return &DAQConnection{
+		Connection:  c,
+		Name:        name,
+		ServerName:  serverName,
+		Host:        simulatedHost,
+		standIn:     true,
+		Tags:        tags,
+		TagMap:      tagMap,
+		ReadTimeout: readTimeout(config),
+		GroupRead:   config.GroupRead,
+		missingChan: make(chan []string, 1),
+	}
+}
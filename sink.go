@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+// SinkFrame is a data frame as passed to sinks. Readings holds the reading behind each payload of the frame by
+// channel name, for sinks which need the type or labels of the channel
+type SinkFrame struct {
+	Frame    *Frame
+	Time     time.Time
+	Channels []string
+	Readings map[string]Reading
+}
+
+// Sink is an output of the plugin besides Laniakea, e.g. Influx or a CSV log. Write is called with every data frame
+// of a recording, Flush whenever the recording is paused or leaves its schedule and Close once it stops. Sinks
+// which buffer or write in the background shouldn't hold up the recording for long in Write
+type Sink interface {
+	Write(frame *SinkFrame) error
+	Flush() error
+	Close() error
+}
+
+// FrameSink is a sink which is also given every frame sent to Laniakea, including metadata, alarm and status frames,
+// e.g. to mirror them to a message broker. Publish is called as each frame is sent and mustn't block
+type FrameSink interface {
+	Sink
+	Publish(frame *proto.Frame)
+}
+
+// SinkFactory opens a sink for a recording from the config. A nil Sink is returned if the sink isn't configured
+type SinkFactory func(e *FlukeDatasource, config *cfg.Config) (Sink, error)
+
+var (
+	sinkRegistry   = make(map[string]SinkFactory)
+	sinkRegistryMu sync.Mutex
+)
+
+// RegisterSink adds a sink to the ones opened for every recording. Sinks register themselves from an init function
+// of their own file so that new sinks can be added without touching the poll loop
+func RegisterSink(name string, factory SinkFactory) {
+	sinkRegistryMu.Lock()
+	defer sinkRegistryMu.Unlock()
+	if _, ok := sinkRegistry[name]; ok {
+		panic("sink " + name + " registered twice")
+	}
+	sinkRegistry[name] = factory
+}
+
+// namedSink is an open sink along with its registered name for logging
+type namedSink struct {
+	name string
+	Sink
+}
+
+// sinks are the sinks open for a recording
+type sinks []namedSink
+
+// openSinks opens every configured sink in name order. If one of them fails, those already opened are closed
+func (e *FlukeDatasource) openSinks(config *cfg.Config) (sinks, error) {
+	sinkRegistryMu.Lock()
+	factories := make(map[string]SinkFactory, len(sinkRegistry))
+	names := make([]string, 0, len(sinkRegistry))
+	for name, factory := range sinkRegistry {
+		factories[name] = factory
+		names = append(names, name)
+	}
+	sinkRegistryMu.Unlock()
+	sort.Strings(names)
+	var open sinks
+	for _, name := range names {
+		sink, err := factories[name](e, config)
+		if err != nil {
+			open.close()
+			return nil, err
+		}
+		if sink != nil {
+			open = append(open, namedSink{name: name, Sink: sink})
+		}
+	}
+	return open, nil
+}
+
+// write passes a frame to every sink, logging the sinks which fail
+func (s sinks) write(frame *SinkFrame) {
+	for _, sink := range s {
+		if err := sink.Write(frame); err != nil {
+			log.Printf("Could not write to %s: %v", sink.name, err)
+		}
+	}
+}
+
+// flush flushes every sink
+func (s sinks) flush() {
+	for _, sink := range s {
+		if err := sink.Flush(); err != nil {
+			log.Printf("Could not flush %s: %v", sink.name, err)
+		}
+	}
+}
+
+// close closes every sink
+func (s sinks) close() {
+	for _, sink := range s {
+		if err := sink.Close(); err != nil {
+			log.Printf("Could not close %s: %v", sink.name, err)
+		}
+	}
+}
+
+// publish passes a frame sent to Laniakea to every sink which takes every frame
+func (s sinks) publish(frame *proto.Frame) {
+	for _, sink := range s {
+		if fs, ok := sink.Sink.(FrameSink); ok {
+			fs.Publish(frame)
+		}
+	}
+}
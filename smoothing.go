@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// smoother applies the smoothing of each channel over a recording
+type smoother struct {
+	settings map[string]*cfg.Smoothing
+	config   *cfg.Config
+	windows  map[string][]float64
+	averages map[string]float64
+}
+
+// newSmoother returns a smoother for the channels with smoothing, or nil if no channel has any
+func newSmoother(config *cfg.Config) *smoother {
+	settings := make(map[string]*cfg.Smoothing)
+	for _, daq := range config.DAQs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 && tag.Smoothing != nil {
+				settings[tag.Tag] = tag.Smoothing
+			}
+		}
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	return &smoother{
+		settings: settings,
+		config:   config,
+		windows:  make(map[string][]float64),
+		averages: make(map[string]float64),
+	}
+}
+
+// apply returns the smoothed value of a good reading along with its value before smoothing if it's to be included.
+// The smoothed value is rounded to the precision of the channel type. Non numeric values are returned unchanged
+func (s *smoother) apply(reading Reading, value interface{}) (interface{}, *float64) {
+	if s == nil {
+		return value, nil
+	}
+	settings, ok := s.settings[reading.Name]
+	if !ok {
+		return value, nil
+	}
+	var v float64
+	switch value := value.(type) {
+	case float64:
+		v = value
+	case int64:
+		v = float64(value)
+	default:
+		return value, nil
+	}
+	var smoothed float64
+	if settings.Samples > 0 {
+		window := append(s.windows[reading.Name], v)
+		if int64(len(window)) > settings.Samples {
+			window = window[1:]
+		}
+		s.windows[reading.Name] = window
+		for _, sample := range window {
+			smoothed += sample
+		}
+		smoothed /= float64(len(window))
+	} else {
+		smoothed = v
+		if prev, ok := s.averages[reading.Name]; ok {
+			smoothed = settings.Alpha*v + (1-settings.Alpha)*prev
+		}
+		s.averages[reading.Name] = smoothed
+	}
+	var raw *float64
+	if settings.IncludeRaw {
+		raw = &v
+	}
+	if places, ok := precision(s.config, reading); ok {
+		return roundValue(smoothed, places), raw
+	}
+	return smoothed, raw
+}
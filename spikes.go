@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// spikeHistory is the state of the spike filter of a channel
+type spikeHistory struct {
+	good     []float64
+	rejects  int64
+	rejected int64
+}
+
+// spikeFilter rejects spikes in the channels with a spike filter over a recording
+type spikeFilter struct {
+	settings map[string]*cfg.SpikeFilter
+	history  map[string]*spikeHistory
+	metrics  *metrics
+}
+
+// newSpikeFilter returns a spikeFilter for the channels with a spike filter, or nil if no channel has one
+func (e *FlukeDatasource) newSpikeFilter(config *cfg.Config) *spikeFilter {
+	settings := make(map[string]*cfg.SpikeFilter)
+	for _, daq := range config.DAQs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 && tag.Spikes != nil {
+				settings[tag.Tag] = tag.Spikes
+			}
+		}
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	return &spikeFilter{settings: settings, history: make(map[string]*spikeHistory), metrics: e.metrics}
+}
+
+// apply returns the value of a good reading, or the previous good value if it's a spike along with true. Non numeric
+// values are returned unchanged
+func (s *spikeFilter) apply(reading Reading, value interface{}) (interface{}, bool) {
+	if s == nil {
+		return value, false
+	}
+	settings, ok := s.settings[reading.Name]
+	if !ok {
+		return value, false
+	}
+	var v float64
+	switch value := value.(type) {
+	case float64:
+		v = value
+	case int64:
+		v = float64(value)
+	default:
+		return value, false
+	}
+	h, ok := s.history[reading.Name]
+	if !ok {
+		h = &spikeHistory{}
+		s.history[reading.Name] = h
+	}
+	maxRejects := settings.MaxRejects
+	if maxRejects == 0 {
+		maxRejects = 1
+	}
+	if len(h.good) > 0 && h.rejects < maxRejects && math.Abs(v-median(h.good)) > settings.MaxJump {
+		h.rejects++
+		h.rejected++
+		s.metrics.spikeRejected(reading.Name)
+		previous := h.good[len(h.good)-1]
+		if _, ok := value.(int64); ok {
+			return int64(previous), true
+		}
+		return previous, true
+	}
+	size := int(settings.Median)
+	if size < 1 {
+		size = 1
+	}
+	// after a step change the older readings no longer describe the channel
+	if h.rejects > 0 {
+		h.good = h.good[:0]
+	}
+	h.good = append(h.good, v)
+	if len(h.good) > size {
+		h.good = h.good[1:]
+	}
+	h.rejects = 0
+	return value, false
+}
+
+// rejected returns the number of spikes rejected on a channel since the recording started
+func (s *spikeFilter) rejected(channel string) int64 {
+	if s == nil {
+		return 0
+	}
+	if h, ok := s.history[channel]; ok {
+		return h.rejected
+	}
+	return 0
+}
+
+// median returns the median of the given values
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
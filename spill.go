@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+var (
+	defaultSpillTimeout time.Duration = 1 * time.Second
+)
+
+// spillFile holds the frames Laniakea wasn't ready to receive until they can be replayed. Frames are stored as
+// length prefixed Frame messages and the file is emptied once every frame has been replayed
+type spillFile struct {
+	file    *os.File
+	reader  *bufio.Reader
+	rfile   *os.File
+	pending int
+	next    *proto.Frame
+}
+
+// openSpillFile opens the spill file at the given path, keeping any frames left in it by a previous recording so
+// that they are replayed first
+func openSpillFile(path string) (*spillFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	rfile, err := os.Open(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &spillFile{file: file, rfile: rfile, reader: bufio.NewReader(rfile)}
+	for {
+		if _, err := s.read(); err != nil {
+			break
+		}
+		s.pending++
+	}
+	if _, err := rfile.Seek(0, io.SeekStart); err != nil {
+		s.close()
+		return nil, err
+	}
+	s.reader.Reset(rfile)
+	if s.pending > 0 {
+		log.Printf("Replaying %d frames left in %s", s.pending, path)
+	}
+	return s, nil
+}
+
+// read reads the next frame from the file
+func (s *spillFile) read() (*proto.Frame, error) {
+	n, err := binary.ReadUvarint(s.reader)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.reader, b); err != nil {
+		return nil, err
+	}
+	frame := &proto.Frame{}
+	if err := protobuf.Unmarshal(b, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// write appends a frame to the file
+func (s *spillFile) write(frame *proto.Frame) error {
+	b, err := protobuf.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(protowire.AppendBytes(nil, b)); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// peek returns the oldest frame which hasn't been replayed yet
+func (s *spillFile) peek() (*proto.Frame, error) {
+	if s.next == nil {
+		frame, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		s.next = frame
+	}
+	return s.next, nil
+}
+
+// pop marks the oldest frame as replayed, emptying the file once there are none left
+func (s *spillFile) pop() error {
+	s.next = nil
+	s.pending--
+	if s.pending > 0 {
+		return nil
+	}
+	return s.reset()
+}
+
+// reset empties the file, discarding any frames which haven't been replayed
+func (s *spillFile) reset() error {
+	s.next = nil
+	s.pending = 0
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.rfile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.reader.Reset(s.rfile)
+	return nil
+}
+
+// close closes the file, leaving any frames which haven't been replayed for the next recording
+func (s *spillFile) close() {
+	s.file.Close()
+	s.rfile.Close()
+}
+
+// spillTimeout returns the configured time to wait for Laniakea to receive a frame before spilling it
+func spillTimeout(config *cfg.Config) time.Duration {
+	if config.SpillTimeout != 0 {
+		return time.Duration(config.SpillTimeout) * time.Millisecond
+	}
+	return defaultSpillTimeout
+}
+
+// sendOrSpill sends a frame, first replaying any spilled frames in order. Frames Laniakea isn't ready to receive
+// within the timeout are spilled to the file instead of blocking the recording. False is returned if the recording
+// was stopped
+func sendOrSpill(ctx context.Context, frameChan chan *proto.Frame, spill *spillFile, timeout time.Duration, frame *proto.Frame) bool {
+	for spill.pending > 0 {
+		spilled, err := spill.peek()
+		if err != nil {
+			log.Printf("Discarding unreadable spilled frames: %v", err)
+			if err := spill.reset(); err != nil {
+				log.Println(err)
+			}
+			break
+		}
+		select {
+		case frameChan <- spilled:
+			if err := spill.pop(); err != nil {
+				log.Println(err)
+			}
+			if spill.pending == 0 {
+				log.Println("Finished replaying spilled frames")
+			}
+		case <-ctx.Done():
+			return false
+		case <-time.After(timeout):
+			if err := spill.write(frame); err != nil {
+				log.Printf("Could not spill frame: %v", err)
+			}
+			return true
+		}
+	}
+	select {
+	case frameChan <- frame:
+	case <-ctx.Done():
+		return false
+	case <-time.After(timeout):
+		log.Println("Laniakea isn't receiving frames, spilling them to disk until it catches up")
+		if err := spill.write(frame); err != nil {
+			log.Printf("Could not spill frame: %v", err)
+		}
+	}
+	return true
+}
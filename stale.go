@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+var (
+	alarmTypeStale = "stale"
+)
+
+// staleState is the last change seen in the value or OPC timestamp of a channel
+type staleState struct {
+	value     interface{}
+	timestamp time.Time
+	since     time.Time
+	stale     bool
+}
+
+// staleDetector raises a stale alarm for channels whose value and OPC timestamp haven't changed for a while, e.g.
+// because a sensor was unplugged or the DAQ scan hung. Their readings are flagged as suspect until they change again
+type staleDetector struct {
+	after    time.Duration
+	channels map[string]*staleState
+}
+
+// newStaleDetector returns a staleDetector for the given period, or nil if the period is 0
+func newStaleDetector(after time.Duration) *staleDetector {
+	if after <= 0 {
+		return nil
+	}
+	return &staleDetector{after: after, channels: make(map[string]*staleState)}
+}
+
+// check updates the channels with a scan and returns the stale alarms raised and cleared. Readings which failed or
+// have bad OPC quality are left out
+func (s *staleDetector) check(readings []Reading, t time.Time) []Alarm {
+	if s == nil {
+		return nil
+	}
+	var alarms []Alarm
+	for _, reading := range readings {
+		if !reading.Item.Good() {
+			continue
+		}
+		state, ok := s.channels[reading.Name]
+		if !ok || !reflect.DeepEqual(reading.Item.Value, state.value) || !reading.Item.Timestamp.Equal(state.timestamp) {
+			if ok && state.stale {
+				alarms = append(alarms, s.alarm(reading.Name, alarmStateCleared, t.Sub(state.since), t))
+			}
+			s.channels[reading.Name] = &staleState{value: reading.Item.Value, timestamp: reading.Item.Timestamp, since: t}
+			continue
+		}
+		if !state.stale && t.Sub(state.since) >= s.after {
+			state.stale = true
+			alarms = append(alarms, s.alarm(reading.Name, alarmStateActive, t.Sub(state.since), t))
+		}
+	}
+	return alarms
+}
+
+// alarm returns a stale alarm. Its value is how long the channel had been unchanged and its limit the stale period,
+// both in seconds
+func (s *staleDetector) alarm(channel, state string, unchanged time.Duration, t time.Time) Alarm {
+	return Alarm{
+		Channel:   channel,
+		Type:      alarmTypeStale,
+		Value:     unchanged.Seconds(),
+		Limit:     s.after.Seconds(),
+		State:     state,
+		Timestamp: t.UnixMilli(),
+	}
+}
+
+// isStale returns true if the channel is stale
+func (s *staleDetector) isStale(channel string) bool {
+	if s == nil {
+		return false
+	}
+	state, ok := s.channels[channel]
+	return ok && state.stale
+}
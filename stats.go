@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+)
+
+// ChannelStats are statistics of the samples of a channel gathered since the previous frame
+type ChannelStats struct {
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// runningStats accumulates samples with Welford's algorithm so that no samples need to be kept
+type runningStats struct {
+	count int64
+	min   float64
+	max   float64
+	mean  float64
+	m2    float64
+}
+
+// add adds a sample
+func (s *runningStats) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (v - s.mean)
+}
+
+// stats returns the statistics of the samples added so far
+func (s *runningStats) stats() *ChannelStats {
+	var variance float64
+	if s.count > 1 {
+		variance = s.m2 / float64(s.count-1)
+	}
+	return &ChannelStats{Count: s.count, Min: s.min, Max: s.max, Mean: s.mean, StdDev: math.Sqrt(variance)}
+}
+
+// windowStats gathers the samples of every channel between two frames
+type windowStats struct {
+	channels map[string]*runningStats
+}
+
+// newWindowStats returns a windowStats without any samples
+func newWindowStats() *windowStats {
+	return &windowStats{channels: make(map[string]*runningStats)}
+}
+
+// add adds the numeric values of the given readings. Readings with bad quality or a bad value are left out
+func (w *windowStats) add(readings []Reading) {
+	for _, reading := range readings {
+		if !reading.Item.Good() {
+			continue
+		}
+		var v float64
+		switch value, _ := payloadValue(reading.Item.Value); value := value.(type) {
+		case float64:
+			v = value
+		case int64:
+			v = float64(value)
+		default:
+			continue
+		}
+		if isBadValue(v) {
+			continue
+		}
+		s, ok := w.channels[reading.Name]
+		if !ok {
+			s = &runningStats{}
+			w.channels[reading.Name] = s
+		}
+		s.add(v)
+	}
+}
+
+// get returns the statistics of a channel, or nil if it has no samples or window statistics are disabled
+func (w *windowStats) get(name string) *ChannelStats {
+	if w == nil {
+		return nil
+	}
+	if s, ok := w.channels[name]; ok {
+		return s.stats()
+	}
+	return nil
+}
+
+// reset discards the samples gathered so far, starting a new window
+func (w *windowStats) reset() {
+	w.channels = make(map[string]*runningStats)
+}
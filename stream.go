@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	streamBufferSize   int           = 16
+	streamWriteTimeout time.Duration = 5 * time.Second
+)
+
+// streamFrame is a frame as streamed over the WebSocket endpoint. JSON payloads are embedded as is, any other
+// payload is base64 encoded
+type streamFrame struct {
+	Source    string      `json:"source"`
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	Payload   interface{} `json:"payload"`
+}
+
+// frameHub streams the frames sent to Laniakea to every connected WebSocket client. Clients which can't keep up
+// miss frames rather than holding up the recording
+type frameHub struct {
+	mu       sync.Mutex
+	clients  map[chan []byte]struct{}
+	upgrader websocket.Upgrader
+}
+
+// newFrameHub returns a frameHub without any clients. Connections are accepted from any origin so that a dashboard
+// opened from a local file can connect
+func newFrameHub() *frameHub {
+	return &frameHub{
+		clients:  make(map[chan []byte]struct{}),
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+	}
+}
+
+// broadcast sends a frame to every client
+func (h *frameHub) broadcast(frame *proto.Frame) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	sf := streamFrame{Source: frame.Source, Type: frame.Type, Timestamp: frame.Timestamp, Payload: frame.Payload}
+	if json.Valid(frame.Payload) {
+		sf.Payload = json.RawMessage(frame.Payload)
+	}
+	b, err := json.Marshal(&sf)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for client := range h.clients {
+		select {
+		case client <- b:
+		default:
+		}
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and streams frames to it until either side closes it
+func (h *frameHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	client := make(chan []byte, streamBufferSize)
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, client)
+		h.mu.Unlock()
+	}()
+	// messages from the client are discarded, reading only notices when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case b := <-client:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/SSSOC-CAN/laniakea-plugin-sdk/proto"
+)
+
+var (
+	summaryFrameType = "application/x-fluke-summary"
+)
+
+type Summary struct {
+	Reason     string `json:"reason"`
+	Started    int64  `json:"started"`
+	Stopped    int64  `json:"stopped"`
+	DurationMS int64  `json:"duration_ms"`
+	Frames     int64  `json:"frames"`
+}
+
+// recordingLimit returns the reason the recording has reached its configured limit, or a blank string if it hasn't
+func recordingLimit(config *cfg.Config, started time.Time, frames int64) string {
+	if config.MaxDuration > 0 && time.Since(started) >= time.Duration(config.MaxDuration)*time.Second {
+		return "maximum recording duration reached"
+	}
+	if config.MaxFrames > 0 && frames >= config.MaxFrames {
+		return "maximum number of frames reached"
+	}
+	return ""
+}
+
+// summaryFrame returns the final frame of a recording stopped by the plugin itself, describing why it stopped and
+// how much was recorded
+func (e *FlukeDatasource) summaryFrame(reason string, started time.Time, frames int64) (*proto.Frame, error) {
+	now := time.Now()
+	b, err := json.Marshal(&Summary{
+		Reason:     reason,
+		Started:    started.UnixMilli(),
+		Stopped:    now.UnixMilli(),
+		DurationMS: now.Sub(started).Milliseconds(),
+		Frames:     frames,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Frame{
+		Source:    e.frameSource(),
+		Type:      summaryFrameType,
+		Timestamp: now.UnixMilli(),
+		Payload:   b,
+	}, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	defaultTagCacheTTL time.Duration = 24 * time.Hour
+)
+
+type tagCache struct {
+	Timestamp int64    `json:"timestamp"`
+	Tags      []string `json:"tags"`
+	// the OPC tags the channels matched by index were last connected to, by channel name
+	Channels map[string]string `json:"channels,omitempty"`
+}
+
+// tagCacheTTL returns the configured amount of time browsed tags are cached for
+func tagCacheTTL(config *cfg.Config) time.Duration {
+	if config.TagCacheTTL != 0 {
+		return time.Duration(config.TagCacheTTL) * time.Second
+	}
+	return defaultTagCacheTTL
+}
+
+// tagCachePath returns the path of the tag cache file for the given OPC server
+func tagCachePath(serverName, host string) string {
+	name := strings.NewReplacer(`\`, "_", "/", "_", ":", "_").Replace(fmt.Sprintf("%s@%s", serverName, host))
+	return filepath.Join(cfg.AppDataDir(), fmt.Sprintf("fluke-tags-%s.json", name))
+}
+
+// readTagCache returns the tag cache of the given OPC server whether or not it has expired
+func readTagCache(serverName, host string) (tagCache, bool) {
+	b, err := ioutil.ReadFile(tagCachePath(serverName, host))
+	if err != nil {
+		return tagCache{}, false
+	}
+	var cache tagCache
+	if err := json.Unmarshal(b, &cache); err != nil {
+		log.Printf("Ignoring invalid tag cache for %s@%s: %v", serverName, host, err)
+		return tagCache{}, false
+	}
+	return cache, true
+}
+
+// writeTagCache writes the tag cache of the given OPC server
+func writeTagCache(serverName, host string, cache tagCache) error {
+	b, err := json.Marshal(&cache)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tagCachePath(serverName, host), b, 0644)
+}
+
+// loadCachedTags returns the cached tags of the given OPC server if they exist and haven't expired
+func loadCachedTags(serverName, host string, ttl time.Duration) ([]string, bool) {
+	cache, ok := readTagCache(serverName, host)
+	if !ok || time.Since(time.UnixMilli(cache.Timestamp)) > ttl || len(cache.Tags) == 0 {
+		return nil, false
+	}
+	return cache.Tags, true
+}
+
+// saveCachedTags writes the browsed tags of the given OPC server to the tag cache, keeping the OPC tags the channels
+// were last connected to
+func saveCachedTags(serverName, host string, tags []string) error {
+	cache, _ := readTagCache(serverName, host)
+	cache.Timestamp, cache.Tags = time.Now().UnixMilli(), tags
+	return writeTagCache(serverName, host, cache)
+}
+
+// checkTagShifts compares the OPC tags the channels matched by index are given in the tag map with those recorded in
+// the tag cache when they were last connected, so that channels don't silently read other tags once tags were added
+// to or removed from the DAQ software. An ErrTagsShifted error names the channels whose tag changed, otherwise the
+// tags of the channels are recorded for the next connection
+func checkTagShifts(serverName, host string, tagMap map[int]Tag, cfgTagMap map[int]cfg.CfgTag) error {
+	cache, _ := readTagCache(serverName, host)
+	idxs := make([]int, 0, len(tagMap))
+	for i := range tagMap {
+		if cfgTagMap[i].OPCTag == "" {
+			idxs = append(idxs, i)
+		}
+	}
+	sort.Ints(idxs)
+	var shifted []string
+	for _, i := range idxs {
+		tag := tagMap[i]
+		if last, ok := cache.Channels[tag.name]; ok && last != tag.tag {
+			shifted = append(shifted, fmt.Sprintf("%s at index %d was %s and is now %s", tag.name, i, last, tag.tag))
+		}
+	}
+	if len(shifted) > 0 {
+		return fmt.Errorf("%w: %s. Give these channels the OPCTag they should read", ErrTagsShifted, strings.Join(shifted, ", "))
+	}
+	if cache.Channels == nil {
+		cache.Channels = make(map[string]string)
+	}
+	for _, i := range idxs {
+		cache.Channels[tagMap[i].name] = tagMap[i].tag
+	}
+	if err := writeTagCache(serverName, host, cache); err != nil {
+		log.Printf("Could not record the tags of the channels of %s@%s: %v", serverName, host, err)
+	}
+	return nil
+}
+
+// getTags returns the tags of the given OPC server from the cache if possible, otherwise the server is browsed
+// and the result is cached. Setting refresh always browses the server
+func getTags(serverName, host string, ttl time.Duration, refresh bool) ([]string, error) {
+	if !refresh {
+		if tags, ok := loadCachedTags(serverName, host, ttl); ok {
+			return tags, nil
+		}
+	}
+	tags, err := GetAllTags(serverName, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveCachedTags(serverName, host, tags); err != nil {
+		log.Printf("Could not cache tags for %s@%s: %v", serverName, host, err)
+	}
+	return tags, nil
+}
+
+// RefreshTags browses every configured OPC server again and updates the tag cache. Existing connections are
+// closed so that the next recording reconnects using the refreshed tags
+func (e *FlukeDatasource) RefreshTags() error {
+	if atomic.LoadInt32(&e.recording) == 1 {
+		return ErrRefreshWhileRecording
+	}
+	config := e.getConfig()
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	// simulated and replayed DAQs have no OPC server to browse
+	for _, daqCfg := range config.DAQs {
+		if config.Simulate || config.Replay != nil {
+			break
+		}
+		serverNames, host := daqCfg.Server()
+		err := daqCredentials(daqCfg).run(func() (err error) {
+			for _, serverName := range serverNames {
+				if _, err = getTags(serverName, host, tagCacheTTL(config), true); err == nil {
+					break
+				}
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, daq := range e.daqs {
+		daq.Close()
+	}
+	e.daqs = nil
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/konimarti/opc"
+)
+
+// emfRange is a temperature range, in °C, of a thermocouple type along with the coefficients of the ITS-90 reference
+// polynomial giving its EMF, in mV, over that range
+type emfRange struct {
+	max          float64
+	coefficients []float64
+}
+
+// thermocoupleType is the ITS-90 reference function of a thermocouple type
+type thermocoupleType struct {
+	min    float64
+	ranges []emfRange
+	// exponential term of type K above 0 °C
+	a []float64
+}
+
+var (
+	thermocoupleTypes = map[string]thermocoupleType{
+		cfg.ThermocoupleK: {
+			min: -270,
+			ranges: []emfRange{
+				{max: 0, coefficients: []float64{
+					0, 0.394501280250e-01, 0.236223735980e-04, -0.328589067840e-06, -0.499048287770e-08,
+					-0.675090591730e-10, -0.574103274280e-12, -0.310888728940e-14, -0.104516093650e-16,
+					-0.198892668780e-19, -0.163226974860e-22,
+				}},
+				{max: 1372, coefficients: []float64{
+					-0.176004136860e-01, 0.389212049750e-01, 0.185587700320e-04, -0.994575928740e-07,
+					0.318409457190e-09, -0.560728448890e-12, 0.560750590590e-15, -0.320207200030e-18,
+					0.971511471520e-22, -0.121047212750e-25,
+				}},
+			},
+			a: []float64{0.118597600000e+00, -0.118343200000e-03, 0.126968600000e+03},
+		},
+		cfg.ThermocoupleJ: {
+			min: -210,
+			ranges: []emfRange{
+				{max: 760, coefficients: []float64{
+					0, 0.503811878150e-01, 0.304758369300e-04, -0.856810657200e-07, 0.132281952950e-09,
+					-0.170529583370e-12, 0.209480906970e-15, -0.125383953360e-18, 0.156317256970e-22,
+				}},
+				{max: 1200, coefficients: []float64{
+					0.296456256810e+03, -0.149761277860e+01, 0.317871039240e-02, -0.318476867010e-05,
+					0.157208190040e-08, -0.306913690560e-12,
+				}},
+			},
+		},
+		cfg.ThermocoupleT: {
+			min: -270,
+			ranges: []emfRange{
+				{max: 0, coefficients: []float64{
+					0, 0.387481063640e-01, 0.441944343470e-04, 0.118443231050e-06, 0.200329735540e-07,
+					0.901380195590e-09, 0.226511565930e-10, 0.360711542050e-12, 0.384939398830e-14,
+					0.282135219250e-16, 0.142515947790e-18, 0.487686622860e-21, 0.107955392700e-23,
+					0.139450270620e-26, 0.797951539270e-30,
+				}},
+				{max: 400, coefficients: []float64{
+					0, 0.387481063640e-01, 0.332922278800e-04, 0.206182434040e-06, -0.218822568460e-08,
+					0.109968809280e-10, -0.308157587720e-13, 0.454791352900e-16, -0.275129016730e-19,
+				}},
+			},
+		},
+	}
+)
+
+// max returns the highest temperature, in °C, of the thermocouple type
+func (tc thermocoupleType) max() float64 {
+	return tc.ranges[len(tc.ranges)-1].max
+}
+
+// emf returns the EMF, in mV, of the thermocouple type at the given temperature in °C, referenced to 0 °C
+func (tc thermocoupleType) emf(t float64) float64 {
+	r := tc.ranges[len(tc.ranges)-1]
+	for _, candidate := range tc.ranges {
+		if t <= candidate.max {
+			r = candidate
+			break
+		}
+	}
+	emf := polynomial(r.coefficients, t)
+	if tc.a != nil && t > 0 {
+		emf += tc.a[0] * math.Exp(tc.a[1]*(t-tc.a[2])*(t-tc.a[2]))
+	}
+	return emf
+}
+
+// temperature returns the temperature, in °C, at which the thermocouple type has the given EMF in mV, referenced to
+// 0 °C. The reference functions have no exact inverse so it's found by bisection. False is returned if the EMF is
+// outside the range of the thermocouple type
+func (tc thermocoupleType) temperature(emf float64) (float64, bool) {
+	low, high := tc.min, tc.max()
+	if emf < tc.emf(low) || emf > tc.emf(high) {
+		return 0, false
+	}
+	for i := 0; i < 64 && high-low > 1e-9; i++ {
+		mid := (low + high) / 2
+		if tc.emf(mid) < emf {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2, true
+}
+
+// coldJunctions compensates thermocouple channels read as EMF with the temperature of an external reference junction
+// read on another channel. The latest good reading of every reference is kept so that references which aren't read
+// on every scan can still be used
+type coldJunctions struct {
+	thermocouples map[string]*cfg.Thermocouple
+	references    map[string]float64
+}
+
+// newColdJunctions returns the cold junction compensation of the thermocouple channels, or nil if there are none.
+// Simulated and replayed thermocouples already read as temperatures so they aren't compensated
+func newColdJunctions(config *cfg.Config) *coldJunctions {
+	if config.Simulate || config.Replay != nil {
+		return nil
+	}
+	thermocouples := make(map[string]*cfg.Thermocouple)
+	for _, daq := range config.DAQs {
+		for i, tag := range daq.FlukeTags {
+			if i != 0 && tag.Thermocouple != nil {
+				thermocouples[tag.Tag] = tag.Thermocouple
+			}
+		}
+	}
+	if len(thermocouples) == 0 {
+		return nil
+	}
+	return &coldJunctions{thermocouples: thermocouples, references: make(map[string]float64)}
+}
+
+// apply replaces the EMF of the thermocouple channels in the given readings by their temperature in °C, adding the
+// EMF of their reference junction. Readings whose reference has no good value, or whose EMF is out of range, are
+// given bad quality. Bad values, like overloads, are left unchanged
+func (c *coldJunctions) apply(readings []Reading) []Reading {
+	if c == nil {
+		return readings
+	}
+	for _, reading := range readings {
+		if _, ok := c.thermocouples[reading.Name]; ok {
+			continue
+		}
+		if v, ok := goodFloat(reading); ok {
+			c.references[reading.Name] = v
+		} else {
+			delete(c.references, reading.Name)
+		}
+	}
+	for i, reading := range readings {
+		thermocouple, ok := c.thermocouples[reading.Name]
+		if !ok {
+			continue
+		}
+		v, ok := goodFloat(reading)
+		if !ok {
+			continue
+		}
+		tc := thermocoupleTypes[thermocouple.Type]
+		reference, ok := c.references[thermocouple.Reference]
+		if ok {
+			var t float64
+			if t, ok = tc.temperature(v + tc.emf(reference)); ok {
+				readings[i].Item.Value = t
+				continue
+			}
+		}
+		readings[i].Item.Quality = opc.OPCQualityBad
+	}
+	return readings
+}
+
+// goodFloat returns the numeric value of a reading if it has good quality and isn't a bad value
+func goodFloat(reading Reading) (float64, bool) {
+	if !reading.Item.Good() {
+		return 0, false
+	}
+	value, _ := payloadValue(reading.Item.Value)
+	switch value := value.(type) {
+	case float64:
+		return value, !isBadValue(value)
+	case int64:
+		return float64(value), true
+	}
+	return 0, false
+}
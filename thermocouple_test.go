@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/konimarti/opc"
+)
+
+// the EMF of the ITS-90 reference tables, referenced to 0 °C, rounded to the µV they're published with
+func TestThermocoupleTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		tcType  string
+		emf     float64
+		want    float64
+		inRange bool
+	}{
+		{"K at 0 °C", cfg.ThermocoupleK, 0, 0, true},
+		{"K at 25 °C", cfg.ThermocoupleK, 1.000, 25.0, true},
+		{"K at 100 °C", cfg.ThermocoupleK, 4.096, 100, true},
+		{"K at 1000 °C", cfg.ThermocoupleK, 41.276, 1000, true},
+		{"K at -100 °C", cfg.ThermocoupleK, -3.554, -100, true},
+		{"J at 100 °C", cfg.ThermocoupleJ, 5.269, 100, true},
+		{"J at 1000 °C", cfg.ThermocoupleJ, 57.953, 1000, true},
+		{"T at 100 °C", cfg.ThermocoupleT, 4.279, 100, true},
+		{"T at -100 °C", cfg.ThermocoupleT, -3.379, -100, true},
+		{"K above 1372 °C", cfg.ThermocoupleK, 60, 0, false},
+		{"K below -270 °C", cfg.ThermocoupleK, -7, 0, false},
+		{"T above 400 °C", cfg.ThermocoupleT, 21, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := thermocoupleTypes[tc.tcType].temperature(tc.emf)
+			if ok != tc.inRange {
+				t.Fatalf("in range %v, expected %v", ok, tc.inRange)
+			}
+			// a µV is about 0.025 °C for type K, and less for J and T
+			if ok && math.Abs(got-tc.want) > 0.05 {
+				t.Fatalf("temperature %v °C, expected %v °C", got, tc.want)
+			}
+		})
+	}
+	if v := thermocoupleTypes[cfg.ThermocoupleK].linearize(60); !math.IsNaN(v) {
+		t.Fatalf("out of range EMF linearized to %v, expected NaN", v)
+	}
+}
+
+func TestColdJunctionsApply(t *testing.T) {
+	good := func(name string, value interface{}) Reading {
+		return Reading{Name: name, Item: opc.Item{Value: value, Quality: opc.OPCQualityGood}}
+	}
+	tests := []struct {
+		name     string
+		readings []Reading
+		want     float64
+		good     bool
+	}{
+		// 0.202 mV on top of the 0.798 mV of a type K junction at 20 °C makes 1.000 mV, which is 25 °C
+		{"20 °C reference", []Reading{good("ICE", 20.0), good("TC_1", 0.202)}, 25.0, true},
+		{"0 °C reference", []Reading{good("ICE", 0.0), good("TC_1", 4.096)}, 100.0, true},
+		{"reference read after the thermocouple", []Reading{good("TC_1", 0.202), good("ICE", 20.0)}, 25.0, true},
+		{"integer reference", []Reading{good("ICE", int64(20)), good("TC_1", 0.202)}, 25.0, true},
+		{"no reference", []Reading{good("TC_1", 0.202)}, 0.202, false},
+		{"bad reference", []Reading{
+			{Name: "ICE", Item: opc.Item{Value: 20.0, Quality: opc.OPCQualityBad}},
+			good("TC_1", 0.202),
+		}, 0.202, false},
+		{"overload reference", []Reading{good("ICE", overRangeValue), good("TC_1", 0.202)}, 0.202, false},
+		{"out of range", []Reading{good("ICE", 20.0), good("TC_1", 60.0)}, 60.0, false},
+		// overloads stay overloads rather than becoming bad quality
+		{"overload", []Reading{good("ICE", 20.0), good("TC_1", overRangeValue)}, overRangeValue, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &coldJunctions{
+				thermocouples: map[string]*cfg.Thermocouple{"TC_1": {Type: cfg.ThermocoupleK, Reference: "ICE"}},
+				references:    make(map[string]float64),
+			}
+			var got Reading
+			for _, r := range c.apply(tc.readings) {
+				if r.Name == "TC_1" {
+					got = r
+				}
+			}
+			if got.Item.Good() != tc.good {
+				t.Fatalf("quality %d, expected good quality %v", got.Item.Quality, tc.good)
+			}
+			v, ok := got.Item.Value.(float64)
+			if !ok || math.Abs(v-tc.want) > 0.05 {
+				t.Fatalf("thermocouple reads %v, expected %v", got.Item.Value, tc.want)
+			}
+		})
+	}
+}
+
+func TestColdJunctionsKeepReference(t *testing.T) {
+	c := &coldJunctions{
+		thermocouples: map[string]*cfg.Thermocouple{"TC_1": {Type: cfg.ThermocoupleK, Reference: "ICE"}},
+		references:    make(map[string]float64),
+	}
+	c.apply([]Reading{{Name: "ICE", Item: opc.Item{Value: 20.0, Quality: opc.OPCQualityGood}}})
+	// the reference isn't read on this scan, so its latest good reading is used
+	readings := c.apply([]Reading{{Name: "TC_1", Item: opc.Item{Value: 0.202, Quality: opc.OPCQualityGood}}})
+	if v := readings[0].Item.Value.(float64); !readings[0].Item.Good() || math.Abs(v-25) > 0.05 {
+		t.Fatalf("thermocouple reads %v with quality %d, expected 25 °C", v, readings[0].Item.Quality)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+// triggerCrossed returns true if the trigger channel is among the readings and has crossed the trigger threshold
+func triggerCrossed(trigger *cfg.Trigger, readings []Reading) bool {
+	for _, reading := range readings {
+		if reading.Name != trigger.Channel || !reading.Item.Good() {
+			continue
+		}
+		var v float64
+		switch value, _ := payloadValue(reading.Item.Value); value := value.(type) {
+		case float64:
+			v = value
+		case int64:
+			v = float64(value)
+		default:
+			return false
+		}
+		if isBadValue(v) {
+			return false
+		}
+		if trigger.Below != nil && v < *trigger.Below {
+			return true
+		}
+		return trigger.Above != nil && v > *trigger.Above
+	}
+	return false
+}
+
+// scan is the readings of a single poll
+type scan struct {
+	readings   []Reading
+	time       time.Time
+	preTrigger bool
+}
+
+// scanRing keeps the most recent scans polled while waiting for the trigger
+type scanRing struct {
+	scans []scan
+	size  int
+}
+
+// newScanRing returns a scanRing keeping up to size scans
+func newScanRing(size int64) *scanRing {
+	return &scanRing{size: int(size)}
+}
+
+// add adds a scan, dropping the oldest one if the ring is full
+func (r *scanRing) add(s scan) {
+	if r == nil || r.size <= 0 {
+		return
+	}
+	if len(r.scans) == r.size {
+		r.scans = r.scans[1:]
+	}
+	r.scans = append(r.scans, s)
+}
+
+// drain returns the buffered scans, oldest first, and empties the ring
+func (r *scanRing) drain() []scan {
+	if r == nil {
+		return nil
+	}
+	scans := r.scans
+	r.scans = nil
+	return scans
+}
+
+// Trigger starts sending frames of a recording waiting for its trigger as if the trigger channel had crossed its
+// threshold
+func (e *FlukeDatasource) Trigger() error {
+	if atomic.LoadInt32(&e.recording) == 0 {
+		return ErrNotRecording
+	}
+	atomic.StoreInt32(&e.triggerNow, 1)
+	return nil
+}
+
+// manualTrigger returns true once if the recording was triggered with Trigger
+func (e *FlukeDatasource) manualTrigger() bool {
+	return atomic.CompareAndSwapInt32(&e.triggerNow, 1, 0)
+}
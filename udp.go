@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	udpMaxDatagram int = 1400
+)
+
+// udpFrame is a compact frame broadcast over UDP, holding the values of the channels by name
+type udpFrame struct {
+	Timestamp int64                  `json:"timestamp"`
+	Sequence  uint64                 `json:"sequence"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+// udpSink sends compact frames to a broadcast or multicast address on the lab LAN so that listeners can pick up
+// live data without any connection setup
+type udpSink struct {
+	conn net.Conn
+}
+
+func init() {
+	RegisterSink("udp", func(_ *FlukeDatasource, config *cfg.Config) (Sink, error) {
+		if config.UDPAddress == "" {
+			return nil, nil
+		}
+		return newUDPSink(config.UDPAddress)
+	})
+}
+
+// newUDPSink returns a udpSink sending to the given address
+func newUDPSink(addr string) (*udpSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSink{conn: conn}, nil
+}
+
+// Write sends the values of a frame
+func (s *udpSink) Write(frame *SinkFrame) error {
+	return s.send(frame.Frame, frame.Time)
+}
+
+// Flush does nothing as datagrams aren't buffered
+func (s *udpSink) Flush() error {
+	return nil
+}
+
+// send sends the values of a frame. Frames are split over several datagrams, each a complete frame with some of the
+// channels, so that no datagram is fragmented
+func (s *udpSink) send(f *Frame, t time.Time) error {
+	frame := udpFrame{Timestamp: t.UnixMilli(), Sequence: f.Sequence, Values: make(map[string]interface{})}
+	var last []byte
+	for _, payload := range f.Data {
+		frame.Values[payload.Name] = payload.Value
+		b, err := json.Marshal(&frame)
+		if err != nil {
+			return err
+		}
+		if len(b) > udpMaxDatagram && len(frame.Values) > 1 {
+			if _, err := s.conn.Write(last); err != nil {
+				return err
+			}
+			frame.Values = map[string]interface{}{payload.Name: payload.Value}
+			if b, err = json.Marshal(&frame); err != nil {
+				return err
+			}
+		}
+		last = b
+	}
+	if len(frame.Values) == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(last)
+	return err
+}
+
+// Close closes the socket
+func (s *udpSink) Close() error {
+	return s.conn.Close()
+}
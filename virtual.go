@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Knetic/govaluate"
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+	"github.com/konimarti/opc"
+)
+
+// virtualChannel is a virtual channel along with its parsed expression
+type virtualChannel struct {
+	cfg.VirtualChannel
+	expr *govaluate.EvaluableExpression
+}
+
+// virtualChannels computes the virtual channels of a recording from the latest reading of every channel, so that
+// channels which aren't read on every scan are still taken into account
+type virtualChannels struct {
+	channels []virtualChannel
+	values   map[string]interface{}
+}
+
+// newVirtualChannels returns the configured virtual channels, or nil if there are none
+func newVirtualChannels(config *cfg.Config) (*virtualChannels, error) {
+	if len(config.VirtualChannels) == 0 {
+		return nil, nil
+	}
+	v := &virtualChannels{values: make(map[string]interface{})}
+	for _, virtual := range config.VirtualChannels {
+		expr, err := govaluate.NewEvaluableExpressionWithFunctions(virtual.Expression, cfg.ExpressionFuncs)
+		if err != nil {
+			return nil, err
+		}
+		v.channels = append(v.channels, virtualChannel{VirtualChannel: virtual, expr: expr})
+	}
+	return v, nil
+}
+
+// add returns the readings of a scan followed by a reading of every virtual channel. Virtual channels are read in
+// the order they're defined so that they can refer to those before them. A virtual channel whose expression refers
+// to a channel without a good value, or can't be evaluated, has a reading with bad quality
+func (v *virtualChannels) add(readings []Reading) []Reading {
+	if v == nil {
+		return readings
+	}
+	for _, reading := range readings {
+		v.update(reading)
+	}
+	now := time.Now()
+	for i, channel := range v.channels {
+		reading := Reading{
+			Name:  channel.Name,
+			Type:  channel.Type,
+			Unit:  channel.Unit,
+			Index: -(i + 1),
+			Item:  opc.Item{Timestamp: now, Quality: opc.OPCQualityBad},
+		}
+		if value, err := channel.expr.Evaluate(v.values); err == nil {
+			if value, ok := value.(float64); ok {
+				reading.Item.Value = value
+				reading.Item.Quality = opc.OPCQualityGood
+			}
+		}
+		v.update(reading)
+		readings = append(readings, reading)
+	}
+	return readings
+}
+
+// update keeps the value of a reading if it's good and numeric, and forgets the value of the channel otherwise
+func (v *virtualChannels) update(reading Reading) {
+	value, _ := payloadValue(reading.Item.Value)
+	switch value := value.(type) {
+	case float64:
+		if reading.Item.Good() && !isBadValue(value) {
+			v.values[reading.Name] = value
+			return
+		}
+	case int64:
+		if reading.Item.Good() {
+			v.values[reading.Name] = float64(value)
+			return
+		}
+	}
+	delete(v.values, reading.Name)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/SSSOC-CAN/fluke-laniakea-plugin/cfg"
+)
+
+var (
+	webhookTimeout           time.Duration = 10 * time.Second
+	webhookCloseTimeout      time.Duration = 5 * time.Second
+	defaultWebhookRetryDelay time.Duration = 5 * time.Second
+	defaultWebhookRetries    int64         = 3
+	webhookBufferSize        int           = 64
+)
+
+// webhookNotifier posts alarms to a webhook in the background so that a slow or unreachable endpoint doesn't hold
+// up the recording. Alarms are dropped once too many are waiting
+type webhookNotifier struct {
+	config     *cfg.Webhook
+	body       *template.Template
+	client     *http.Client
+	retries    int64
+	retryDelay time.Duration
+	alarms     chan Alarm
+	done       chan struct{}
+	stop       chan struct{}
+}
+
+// newWebhookNotifier returns a webhookNotifier for the configured webhook and starts posting in the background
+func newWebhookNotifier(config *cfg.Webhook) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		config:     config,
+		client:     &http.Client{Timeout: webhookTimeout},
+		retries:    defaultWebhookRetries,
+		retryDelay: defaultWebhookRetryDelay,
+		alarms:     make(chan Alarm, webhookBufferSize),
+		done:       make(chan struct{}),
+		stop:       make(chan struct{}),
+	}
+	if config.Body != "" {
+		body, err := template.New("Body").Funcs(cfg.WebhookFuncs).Parse(config.Body)
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+	}
+	if config.Retries > 0 {
+		n.retries = config.Retries
+	}
+	if config.RetryDelay > 0 {
+		n.retryDelay = time.Duration(config.RetryDelay) * time.Second
+	}
+	go n.run()
+	return n, nil
+}
+
+// notify queues an alarm to be posted
+func (n *webhookNotifier) notify(alarm Alarm) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.alarms <- alarm:
+	default:
+		log.Printf("Dropped %s alarm of %s, too many alarms are waiting to be posted to the webhook", alarm.State, alarm.Channel)
+	}
+}
+
+// run posts the queued alarms until the notifier is closed
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for alarm := range n.alarms {
+		select {
+		case <-n.stop:
+			return
+		default:
+		}
+		body, err := n.render(alarm)
+		if err != nil {
+			log.Printf("Could not render webhook body: %v", err)
+			continue
+		}
+		if err := n.post(body); err != nil {
+			log.Printf("Could not post %s alarm of %s to the webhook: %v", alarm.State, alarm.Channel, err)
+		}
+	}
+}
+
+// render returns the body posted for an alarm
+func (n *webhookNotifier) render(alarm Alarm) ([]byte, error) {
+	if n.body == nil {
+		return json.Marshal(&alarm)
+	}
+	var buf bytes.Buffer
+	if err := n.body.Execute(&buf, &alarm); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// post posts a body to the webhook, retrying after RetryDelay if the request fails or the server responds with an
+// error. Retries are given up once the notifier is closed
+func (n *webhookNotifier) post(body []byte) error {
+	var err error
+	for attempt := int64(0); attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(n.retryDelay):
+			case <-n.stop:
+				return err
+			}
+		}
+		if err = n.postOnce(body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// postOnce makes a single attempt at posting a body to the webhook
+func (n *webhookNotifier) postOnce(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// close posts the alarms still waiting, giving up on those which can't be posted in time
+func (n *webhookNotifier) close() {
+	if n == nil {
+		return
+	}
+	close(n.alarms)
+	select {
+	case <-n.done:
+	case <-time.After(webhookCloseTimeout):
+		close(n.stop)
+		log.Printf("Dropped %d alarms which couldn't be posted to the webhook in time", len(n.alarms))
+	}
+}